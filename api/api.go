@@ -0,0 +1,144 @@
+// Package api defines the request and response bodies of the JSON API of the drand-note server.
+// It has no dependencies, so that clients such as cmd/drandnote don't link the server and its stores.
+package api
+
+// CreateNoteRequest represents the request body for creating a new note.
+// Either Text is set and the server encrypts it, or the client encrypts the note
+// itself and sets Ciphertext and Round, so that the server never sees the plaintext.
+type CreateNoteRequest struct {
+	Text     string `json:"text,omitempty"`
+	UnlockAt string `json:"unlock_at"` // RFC3339 format, optional with Ciphertext
+
+	Ciphertext []byte `json:"ciphertext,omitempty"` // Base64 encoded age file, binary or armored, e.g. from crypto.Seal or tle
+	Round      uint64 `json:"round,omitempty"`      // Round the ciphertext is locked to, default: read from the file
+
+	// FragmentKey marks notes encrypted in the browser with a key kept in the URL fragment.
+	// The timelocked text is then base64([nonce][AES-GCM ciphertext]).
+	FragmentKey bool `json:"fragment_key,omitempty"`
+
+	// MaxViews deletes the note once it was read that many times after unlock, default: no limit.
+	// BurnAfterReading is the same as a MaxViews of 1.
+	MaxViews         uint32 `json:"max_views,omitempty"`
+	BurnAfterReading bool   `json:"burn_after_reading,omitempty"`
+
+	// Retention is how long the note is kept after unlock, e.g. "24h", within the limit of the server.
+	// Default: the retention configured on the server.
+	Retention string `json:"retention,omitempty"`
+
+	// Passphrase encrypts the text with a key derived from it before it is timelocked.
+	// Readers must then POST it to the note URL after unlock, with a few attempts per note.
+	Passphrase string `json:"passphrase,omitempty"`
+
+	// Recipients are age ("age1...") or base64 X25519 public keys of people who can decrypt
+	// the note at any time: they decrypt its export with their age identity, e.g. with
+	// `age -d -i key.txt`. Anyone else with the link still waits for the unlock time.
+	// Clients that encrypt the note themselves add their recipients to the ciphertext.
+	Recipients []string `json:"recipients,omitempty"`
+
+	// Approvers is the number of approval tokens returned to the creator, to hand out to approvers.
+	// Once unlocked, the note can only be read after Approvals of them (default: all) called
+	// POST /api/note/{id}/{h}/approve with their token.
+	Approvers uint32 `json:"approvers,omitempty"`
+	Approvals uint32 `json:"approvals,omitempty"`
+
+	// CheckinInterval makes a dead man's switch note, e.g. "24h": until the note unlocks, each
+	// POST /api/note/{id}/{h}/checkin with the delete token moves its unlock time to the interval
+	// after the check-in. UnlockAt is the first deadline.
+	CheckinInterval string `json:"checkin_interval,omitempty"`
+}
+
+// CreateNoteResponse represents the response body for creating a new note
+type CreateNoteResponse struct {
+	URL       string `json:"url"`
+	ExpiresAt string `json:"expires_at"` // RFC3339 format, when the note is deleted
+
+	// DeleteToken is only given to the creator: DELETE /api/note/{id}/{h} with it removes the note
+	DeleteToken string `json:"delete_token"`
+
+	// ApprovalTokens are the tokens of the approvers of the note, if it has any
+	ApprovalTokens []string `json:"approval_tokens,omitempty"`
+}
+
+// Note statuses returned by the JSON API
+const (
+	NoteStatusLocked   = "locked"
+	NoteStatusUnlocked = "unlocked"
+
+	// NoteStatusAwaitingApproval is the status of unlocked notes that still need approvals
+	NoteStatusAwaitingApproval = "awaiting_approval"
+)
+
+// GetNoteResponse represents the response body for reading a note
+type GetNoteResponse struct {
+	Status           string `json:"status"`     // NoteStatusLocked, NoteStatusUnlocked or NoteStatusAwaitingApproval
+	UnlockAt         string `json:"unlock_at"`  // RFC3339 format
+	ExpiresAt        string `json:"expires_at"` // RFC3339 format, when the note is deleted
+	Round            uint64 `json:"round"`
+	RemainingSeconds int64  `json:"remaining_seconds"`
+	Text             string `json:"text,omitempty"` // Only set once a text note is unlocked
+
+	// Attachment and DownloadURL are set once a note with a file is unlocked
+	Attachment  *Attachment `json:"attachment,omitempty"`
+	DownloadURL string      `json:"download_url,omitempty"`
+
+	// FragmentKey is set when Text must be decrypted with the key in the URL fragment
+	FragmentKey bool `json:"fragment_key,omitempty"`
+
+	// ViewsLeft is the number of reads after unlock left before the note is deleted,
+	// only set for notes with max_views. Reads of the locked note don't count.
+	ViewsLeft *uint32 `json:"views_left,omitempty"`
+
+	// Passphrase is set for notes protected by a passphrase: once unlocked, Text is only
+	// returned to POST requests with the passphrase in an OpenNoteRequest
+	Passphrase bool `json:"passphrase,omitempty"`
+
+	// Approvals is the number of approvers who released their share of the key of a note
+	// that needs ApprovalsRequired of them, only set for notes with approvers
+	Approvals         uint32 `json:"approvals,omitempty"`
+	ApprovalsRequired uint32 `json:"approvals_required,omitempty"`
+}
+
+// Attachment describes the file of a note. It is timelocked to the same round as the file,
+// so the name, type and size are only revealed once the note unlocks.
+type Attachment struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // MIME type
+	Size int64  `json:"size"` // Size in bytes
+}
+
+// OpenNoteRequest represents the request body for reading a note protected by a passphrase
+type OpenNoteRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+// ApproveNoteResponse represents the response body for approving a note
+type ApproveNoteResponse struct {
+	Approvals         uint32 `json:"approvals"`          // Approvers who released their share
+	ApprovalsRequired uint32 `json:"approvals_required"` // Approvals needed to read the note
+}
+
+// LockNoteResponse represents the response body for moving the unlock time of a note:
+// a check-in, or an extension by its creator
+type LockNoteResponse struct {
+	UnlockAt  string `json:"unlock_at"`  // RFC3339 format, the next unlock time unless the owner checks in again
+	ExpiresAt string `json:"expires_at"` // RFC3339 format, when the note is deleted
+	Round     uint64 `json:"round"`
+
+	// URL is the new link of an extended note, which moves to the hash of its new ciphertext:
+	// its previous link returns 404 Not Found
+	URL string `json:"url,omitempty"`
+}
+
+// ExtendNoteRequest represents the request body for moving the unlock time of a note later.
+// A timelock can't be extended, so the note is encrypted again to the later round: by the server
+// for notes with an owner copy, i.e. the text notes it encrypted without recipients, which only
+// need UnlockAt, and by the client for the others, which send Ciphertext and Round like in
+// CreateNoteRequest. Files, and notes without an owner copy whose passphrase or approval layer
+// is held by the server, can't be extended. The note moves to the hash of its new ciphertext,
+// so its link changes.
+type ExtendNoteRequest struct {
+	UnlockAt string `json:"unlock_at"` // RFC3339 format, optional with Ciphertext
+
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+	Round      uint64 `json:"round,omitempty"`
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/korjavin/drand-poc/api"
+	"github.com/korjavin/drand-poc/internal/crypt/crypto"
+	"github.com/korjavin/drand-poc/internal/crypt/drand"
+)
+
+// maxPollInterval bounds the time between two polls of a locked note
+const maxPollInterval = time.Minute
+
+// createNote creates a note on the server and prints its URL
+func createNote(ctx context.Context, opts options, text []byte, unlockAt time.Time, clientSide bool, stdout io.Writer) error {
+	// unlock_at is sent with a precision of a second, and the round must match it
+	unlockAt = unlockAt.Truncate(time.Second)
+	req := api.CreateNoteRequest{UnlockAt: unlockAt.Format(time.RFC3339)}
+
+	if clientSide {
+		// Encrypt locally with the chain used by the server
+		info, err := fetchServerChain(ctx, opts.server)
+		if err != nil {
+			return err
+		}
+
+		round := info.RoundFor(unlockAt)
+		req.Ciphertext, _, err = crypto.Seal(info, text, round)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt note: %w", err)
+		}
+		req.Round = round
+	} else {
+		req.Text = string(text)
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(opts.server, "/")+"/api/note", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to create note: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp)
+	}
+
+	var createResp api.CreateNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&createResp); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	_, err = fmt.Fprintln(stdout, createResp.URL)
+	return err
+}
+
+// fetchServerChain gets the drand chain used by the server
+func fetchServerChain(ctx context.Context, serverURL string) (*drand.ChainInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(serverURL, "/")+"/api/chain", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chain info: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	// The chain hash is verified against the parameters
+	return drand.ParseChainInfo(resp.Body)
+}
+
+// getNote prints a note from its URL, or the content of its file. If wait is set,
+// it polls the note until it unlocks.
+func getNote(ctx context.Context, opts options, noteURL string, wait bool, stdout io.Writer) error {
+	apiURL, fragment, err := noteAPIURL(noteURL)
+	if err != nil {
+		return err
+	}
+
+	for {
+		note, err := fetchNote(ctx, apiURL, opts.passphrase)
+		if err != nil {
+			return err
+		}
+
+		if note.Status == api.NoteStatusUnlocked {
+			// The text of protected notes is only returned with their passphrase
+			if note.Passphrase && opts.passphrase == "" {
+				return errPassphraseRequired
+			}
+			if note.Attachment != nil {
+				return downloadFile(ctx, note.DownloadURL, stdout)
+			}
+
+			text := note.Text
+			if note.FragmentKey {
+				if text, err = fragmentDecrypt(text, fragment); err != nil {
+					return err
+				}
+			}
+			_, err = fmt.Fprint(stdout, text)
+			return err
+		}
+
+		if note.Status == api.NoteStatusAwaitingApproval {
+			return fmt.Errorf("%w (%d of %d approvals)", errAwaitingApproval, note.Approvals, note.ApprovalsRequired)
+		}
+
+		if !wait {
+			return fmt.Errorf("%w until %s (%ds remaining)", errLocked, note.UnlockAt, note.RemainingSeconds)
+		}
+
+		// Sleep until the unlock round, but poll regularly in case the clocks disagree
+		delay := time.Duration(note.RemainingSeconds) * time.Second
+		if delay > maxPollInterval {
+			delay = maxPollInterval
+		}
+		if delay < time.Second {
+			delay = time.Second
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// downloadFile writes the decrypted file of an unlocked note to stdout
+func downloadFile(ctx context.Context, downloadURL string, stdout io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	if _, err := io.Copy(stdout, resp.Body); err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	return nil
+}
+
+// noteAPIURL returns the JSON API URL of a note page URL, and the key in its fragment if any
+func noteAPIURL(noteURL string) (string, string, error) {
+	u, err := url.Parse(noteURL)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid note URL: %w", err)
+	}
+
+	if !strings.HasPrefix(u.Path, "/note/") {
+		return "", "", fmt.Errorf("invalid note URL: %s", noteURL)
+	}
+
+	fragment := u.Fragment
+	u.Fragment = ""
+	u.Path = "/api" + u.Path
+	return u.String(), fragment, nil
+}
+
+// fetchNote gets a note from the JSON API. With a passphrase, the note is read with a POST
+// request: once unlocked, each one counts as an attempt.
+func fetchNote(ctx context.Context, apiURL, passphrase string) (api.GetNoteResponse, error) {
+	method := http.MethodGet
+	var body io.Reader
+	if passphrase != "" {
+		data, err := json.Marshal(api.OpenNoteRequest{Passphrase: passphrase})
+		if err != nil {
+			return api.GetNoteResponse{}, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		method = http.MethodPost
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, body)
+	if err != nil {
+		return api.GetNoteResponse{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return api.GetNoteResponse{}, fmt.Errorf("failed to get note: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusLocked {
+		return api.GetNoteResponse{}, responseError(resp)
+	}
+
+	var note api.GetNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&note); err != nil {
+		return api.GetNoteResponse{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return note, nil
+}
+
+// fragmentDecrypt decrypts the payload of a note encrypted with a key kept in the URL fragment.
+// It mirrors fragmentDecrypt in frontend/fragment.js.
+func fragmentDecrypt(payload, fragment string) (string, error) {
+	if fragment == "" {
+		return "", fmt.Errorf("the note URL is missing the key in its fragment")
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(fragment)
+	if err != nil {
+		return "", fmt.Errorf("invalid fragment key: %w", err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid payload: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	if len(data) < aesgcm.NonceSize() {
+		return "", fmt.Errorf("invalid payload: too short")
+	}
+
+	plaintext, err := aesgcm.Open(nil, data[:aesgcm.NonceSize()], data[aesgcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// responseError returns an error with the status and body of a failed response
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
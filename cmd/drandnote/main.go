@@ -0,0 +1,168 @@
+// Command drandnote is a command-line client for time-locked notes.
+//
+// Usage:
+//
+//	drandnote note create [--unlock-at TIME | --in DURATION] < note.txt
+//	drandnote note get [--passphrase PASSPHRASE] URL
+//	drandnote note wait [--passphrase PASSPHRASE] URL
+//
+// By default it talks to the HTTP API of a drand-poc server. With --offline it
+// encrypts and decrypts files locally with the crypto package, using drand directly.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/korjavin/drand-poc/internal/crypt/drand"
+)
+
+// errLocked is returned by get for notes that are still locked
+var errLocked = errors.New("note is still locked")
+
+// errAwaitingApproval is returned by get and wait for unlocked notes that still need approvals,
+// which may never come, so wait doesn't poll them
+var errAwaitingApproval = errors.New("note is unlocked but waiting for approvals")
+
+// errPassphraseRequired is returned for unlocked notes protected by a passphrase that wasn't given
+var errPassphraseRequired = errors.New("note is protected by a passphrase: use --passphrase or DRANDNOTE_PASSPHRASE")
+
+const usage = `Usage:
+  drandnote note create [flags] < note.txt   Create a note and print its URL
+  drandnote note get [flags] URL             Print an unlocked note, or its file
+  drandnote note wait [flags] URL            Wait until a note unlocks and print it
+
+With --offline, create writes an age file (compatible with tle) and get/wait take
+a file instead of a URL. Notes protected by a passphrase need --passphrase, or the
+DRANDNOTE_PASSPHRASE environment variable, to be read. Notes that still need
+approvals once unlocked are reported as an error, even by wait.
+Run "drandnote note <command> -h" for the flags of a command.
+`
+
+// options holds the flags shared by all commands
+type options struct {
+	server        string
+	offline       bool
+	drandURLs     string
+	chainInfoPath string
+	output        string
+	armor         bool
+	passphrase    string
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "drandnote:", err)
+		if errors.Is(err, errLocked) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// run executes the command given by args
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) < 2 || args[0] != "note" {
+		fmt.Fprint(os.Stderr, usage)
+		return errors.New("expected a note command")
+	}
+
+	command := args[1]
+	fs := flag.NewFlagSet("drandnote note "+command, flag.ContinueOnError)
+
+	var opts options
+	defaultServer := os.Getenv("DRANDNOTE_SERVER")
+	if defaultServer == "" {
+		defaultServer = "http://localhost:8083"
+	}
+	fs.StringVar(&opts.server, "server", defaultServer, "Base URL of the server (env DRANDNOTE_SERVER)")
+	fs.BoolVar(&opts.offline, "offline", false, "Encrypt and decrypt locally without the server")
+	fs.StringVar(&opts.drandURLs, "drand-url", strings.Join(drand.DefaultURLs, ","), "Comma-separated drand HTTP endpoints (offline mode)")
+	fs.StringVar(&opts.chainInfoPath, "chain-info", "", "Path to a drand chain info JSON file (offline mode, default: quicknet)")
+
+	switch command {
+	case "create":
+		unlockAt := fs.String("unlock-at", "", "Unlock time in RFC3339 format")
+		in := fs.Duration("in", 0, "Unlock the note after this duration, e.g. 2h")
+		clientSide := fs.Bool("client-side", false, "Encrypt locally and upload only the ciphertext")
+		fs.StringVar(&opts.output, "o", "", "Output file of the encrypted note (offline mode, default: stdout)")
+		fs.BoolVar(&opts.armor, "armor", false, "Write an ASCII-armored age file (offline mode)")
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+
+		t, err := parseUnlockTime(*unlockAt, *in, time.Now())
+		if err != nil {
+			return err
+		}
+
+		text, err := io.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read note: %w", err)
+		}
+
+		if opts.offline {
+			return createOffline(ctx, opts, text, t, stdout)
+		}
+		return createNote(ctx, opts, text, t, *clientSide, stdout)
+
+	case "get", "wait":
+		fs.StringVar(&opts.passphrase, "passphrase", os.Getenv("DRANDNOTE_PASSPHRASE"), "Passphrase of a protected note (env DRANDNOTE_PASSPHRASE)")
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("expected a single %s", map[bool]string{false: "URL", true: "file"}[opts.offline])
+		}
+
+		wait := command == "wait"
+		if opts.offline {
+			return getOffline(ctx, opts, fs.Arg(0), wait, stdout)
+		}
+		return getNote(ctx, opts, fs.Arg(0), wait, stdout)
+
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// parseUnlockTime returns the unlock time given either as an RFC3339 time or as a duration from now
+func parseUnlockTime(unlockAt string, in time.Duration, now time.Time) (time.Time, error) {
+	switch {
+	case unlockAt != "" && in != 0:
+		return time.Time{}, errors.New("use either --unlock-at or --in, not both")
+	case unlockAt != "":
+		t, err := time.Parse(time.RFC3339, unlockAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid --unlock-at, use RFC3339 format (e.g., 2023-01-01T12:00:00Z): %w", err)
+		}
+		return t, nil
+	case in > 0:
+		return now.Add(in), nil
+	default:
+		return time.Time{}, errors.New("--unlock-at or a positive --in is required")
+	}
+}
+
+// sleep waits for the duration or until the context is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
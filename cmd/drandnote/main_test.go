@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	dcrypto "github.com/drand/drand/crypto"
+	"github.com/korjavin/drand-poc/api"
+	"github.com/korjavin/drand-poc/internal/crypt/crypto"
+	"github.com/korjavin/drand-poc/internal/crypt/drand/fake"
+	"github.com/korjavin/drand-poc/server"
+	"github.com/korjavin/drand-poc/storage"
+)
+
+// startServer starts a server backed by an in-memory store and a fake drand beacon
+func startServer(t *testing.T) (string, *fake.Beacon) {
+	t.Helper()
+
+	store, err := storage.NewBadgerStore(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatalf("Failed to create Badger store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to find an available port: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	beacon := fake.NewQuicknet()
+	locker := crypto.NewLocker(beacon, crypto.WithClock(beacon.Now))
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := server.NewServer(store, locker, logger, "http://"+addr, "../../frontend")
+
+	go srv.Start(addr)
+
+	// Wait for the server to start
+	time.Sleep(100 * time.Millisecond)
+
+	return "http://" + addr, beacon
+}
+
+func TestParseUnlockTime(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if got, err := parseUnlockTime("", 2*time.Hour, now); err != nil || !got.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("Unexpected time for --in 2h: %v, %v", got, err)
+	}
+	if got, err := parseUnlockTime("2025-06-01T00:00:00Z", 0, now); err != nil || !got.Equal(time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected time for --unlock-at: %v, %v", got, err)
+	}
+
+	for _, tt := range []struct {
+		unlockAt string
+		in       time.Duration
+	}{
+		{"", 0},
+		{"", -time.Hour},
+		{"tomorrow", 0},
+		{"2025-06-01T00:00:00Z", time.Hour},
+	} {
+		if _, err := parseUnlockTime(tt.unlockAt, tt.in, now); err == nil {
+			t.Errorf("Expected an error for --unlock-at %q --in %v", tt.unlockAt, tt.in)
+		}
+	}
+}
+
+func TestCreateGetWait(t *testing.T) {
+	ctx := context.Background()
+
+	for _, clientSide := range []bool{false, true} {
+		t.Run(fmt.Sprintf("client-side=%v", clientSide), func(t *testing.T) {
+			// Each run gets its own beacon clock, as --in is relative to the real time
+			serverURL, beacon := startServer(t)
+			noteText := "This is a note from the command line."
+
+			var out bytes.Buffer
+			args := []string{"note", "create", "--server", serverURL, "--in", "5m", fmt.Sprintf("--client-side=%v", clientSide)}
+			if err := run(ctx, args, strings.NewReader(noteText), &out); err != nil {
+				t.Fatalf("create failed: %v", err)
+			}
+			noteURL := strings.TrimSpace(out.String())
+
+			// A locked note cannot be read yet
+			if err := run(ctx, []string{"note", "get", "--server", serverURL, noteURL}, nil, io.Discard); !errors.Is(err, errLocked) {
+				t.Fatalf("Expected errLocked, got: %v", err)
+			}
+
+			// wait blocks until the note unlocks
+			done := make(chan error, 1)
+			var waitOut bytes.Buffer
+			go func() {
+				done <- run(ctx, []string{"note", "wait", "--server", serverURL, noteURL}, nil, &waitOut)
+			}()
+
+			beacon.Advance(5*time.Minute + beacon.Info().Period)
+
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("wait failed: %v", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("wait did not return after the note unlocked")
+			}
+			if waitOut.String() != noteText {
+				t.Errorf("Unexpected note. Got: %q, Want: %q", waitOut.String(), noteText)
+			}
+		})
+	}
+}
+
+func TestGetFragmentKeyNote(t *testing.T) {
+	serverURL, beacon := startServer(t)
+	ctx := context.Background()
+	noteText := "Only the link holder can read this."
+
+	// Encrypt the note like the browser does
+	key := make([]byte, 32)
+	nonce := make([]byte, 12)
+	rand.Read(key)
+	rand.Read(nonce)
+	block, _ := aes.NewCipher(key)
+	aesgcm, _ := cipher.NewGCM(block)
+	payload := base64.StdEncoding.EncodeToString(aesgcm.Seal(nonce, nonce, []byte(noteText), nil))
+
+	body, _ := json.Marshal(api.CreateNoteRequest{
+		Text:        payload,
+		UnlockAt:    beacon.Now().Add(time.Minute).Format(time.RFC3339),
+		FragmentKey: true,
+	})
+	resp, err := http.Post(serverURL+"/api/note", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create note: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var createResp api.CreateNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&createResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	noteURL := createResp.URL + "#" + base64.RawURLEncoding.EncodeToString(key)
+
+	beacon.Advance(time.Minute + beacon.Info().Period)
+
+	var out bytes.Buffer
+	if err := run(ctx, []string{"note", "get", noteURL}, nil, &out); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if out.String() != noteText {
+		t.Errorf("Unexpected note. Got: %q, Want: %q", out.String(), noteText)
+	}
+
+	// Without the fragment the note cannot be decrypted
+	if err := run(ctx, []string{"note", "get", createResp.URL}, nil, io.Discard); err == nil {
+		t.Errorf("Expected an error without the fragment key")
+	}
+}
+
+// postNote creates a note through the API and returns its URL
+func postNote(t *testing.T, serverURL string, req api.CreateNoteRequest) string {
+	t.Helper()
+
+	body, _ := json.Marshal(req)
+	resp, err := http.Post(serverURL+"/api/note", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create note: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var createResp api.CreateNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&createResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return createResp.URL
+}
+
+func TestGetPassphraseNote(t *testing.T) {
+	serverURL, beacon := startServer(t)
+	ctx := context.Background()
+	noteText := "Only readers with the passphrase can read this."
+	noteURL := postNote(t, serverURL, api.CreateNoteRequest{
+		Text:       noteText,
+		UnlockAt:   beacon.Now().Add(time.Minute).Format(time.RFC3339),
+		Passphrase: "correct horse battery staple",
+	})
+
+	// Locked notes are reported as such, with or without the passphrase
+	if err := run(ctx, []string{"note", "get", "--passphrase", "wrong", noteURL}, nil, io.Discard); !errors.Is(err, errLocked) {
+		t.Errorf("Expected errLocked, got: %v", err)
+	}
+
+	beacon.Advance(time.Minute + beacon.Info().Period)
+
+	if err := run(ctx, []string{"note", "get", noteURL}, nil, io.Discard); !errors.Is(err, errPassphraseRequired) {
+		t.Errorf("Expected errPassphraseRequired, got: %v", err)
+	}
+	if err := run(ctx, []string{"note", "get", "--passphrase", "wrong", noteURL}, nil, io.Discard); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected an error for a wrong passphrase, got: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := run(ctx, []string{"note", "get", "--passphrase", "correct horse battery staple", noteURL}, nil, &out); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if out.String() != noteText {
+		t.Errorf("Unexpected note. Got: %q, Want: %q", out.String(), noteText)
+	}
+}
+
+func TestGetAwaitingApproval(t *testing.T) {
+	serverURL, beacon := startServer(t)
+	noteURL := postNote(t, serverURL, api.CreateNoteRequest{
+		Text:      "Only readable once approved.",
+		UnlockAt:  beacon.Now().Add(time.Minute).Format(time.RFC3339),
+		Approvers: 1,
+	})
+
+	beacon.Advance(time.Minute + beacon.Info().Period)
+
+	// wait returns at once instead of polling for approvals that may never come
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, command := range []string{"get", "wait"} {
+		err := run(ctx, []string{"note", command, noteURL}, nil, io.Discard)
+		if !errors.Is(err, errAwaitingApproval) {
+			t.Errorf("%s: expected errAwaitingApproval, got: %v", command, err)
+		}
+	}
+}
+
+func TestGetFileNote(t *testing.T) {
+	serverURL, beacon := startServer(t)
+	ctx := context.Background()
+	content := strings.Repeat("A line of an attached file.\n", 1000)
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.WriteField("unlock_at", beacon.Now().Add(time.Minute).Format(time.RFC3339))
+	part, _ := mw.CreateFormFile("file", "notes.txt")
+	part.Write([]byte(content))
+	mw.Close()
+
+	resp, err := http.Post(serverURL+"/api/file", mw.FormDataContentType(), &form)
+	if err != nil {
+		t.Fatalf("Failed to upload file: %v", err)
+	}
+	var createResp api.CreateNoteResponse
+	err = json.NewDecoder(resp.Body).Decode(&createResp)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	beacon.Advance(time.Minute + beacon.Info().Period)
+
+	// get writes the content of the file
+	var out bytes.Buffer
+	if err := run(ctx, []string{"note", "get", createResp.URL}, nil, &out); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if out.String() != content {
+		t.Errorf("Unexpected file: got %d bytes, want %d", out.Len(), len(content))
+	}
+}
+
+func TestOffline(t *testing.T) {
+	beacon, err := fake.New(dcrypto.SigsOnG1ID, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create beacon: %v", err)
+	}
+	beacon.Run()
+	drandServer := beacon.StartServer()
+	defer drandServer.Close()
+
+	dir := t.TempDir()
+	chainInfo := filepath.Join(dir, "chain.json")
+	info, _ := json.Marshal(beacon.Info())
+	if err := os.WriteFile(chainInfo, info, 0644); err != nil {
+		t.Fatalf("Failed to write chain info: %v", err)
+	}
+
+	ctx := context.Background()
+	noteFile := filepath.Join(dir, "note.age")
+	noteText := "This note never touched a server."
+	flags := []string{"--offline", "--chain-info", chainInfo, "--drand-url", drandServer.URL}
+
+	create := append([]string{"note", "create", "--in", "1s", "--armor", "-o", noteFile}, flags...)
+	if err := run(ctx, create, strings.NewReader(noteText), io.Discard); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	data, err := os.ReadFile(noteFile)
+	if err != nil {
+		t.Fatalf("Failed to read note: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "-----BEGIN AGE ENCRYPTED FILE-----") {
+		t.Errorf("Expected an armored age file, got: %q", data)
+	}
+
+	get := append(append([]string{"note", "get"}, flags...), noteFile)
+	if err := run(ctx, get, nil, io.Discard); !errors.Is(err, errLocked) {
+		t.Fatalf("Expected errLocked, got: %v", err)
+	}
+
+	var out bytes.Buffer
+	wait := append(append([]string{"note", "wait"}, flags...), noteFile)
+	if err := run(ctx, wait, nil, &out); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	if out.String() != noteText {
+		t.Errorf("Unexpected note. Got: %q, Want: %q", out.String(), noteText)
+	}
+
+	// A corrupt note fails at once, without waiting for retries
+	ciphertext, err := crypto.Dearmor(data)
+	if err != nil {
+		t.Fatalf("Failed to dearmor note: %v", err)
+	}
+	ciphertext[len(ciphertext)-1] ^= 1
+	corruptFile := filepath.Join(dir, "corrupt.age")
+	if err := os.WriteFile(corruptFile, ciphertext, 0644); err != nil {
+		t.Fatalf("Failed to write note: %v", err)
+	}
+	start := time.Now()
+	get = append(append([]string{"note", "get"}, flags...), corruptFile)
+	if err := run(ctx, get, nil, io.Discard); err == nil {
+		t.Error("Expected an error for a corrupt note")
+	}
+	if elapsed := time.Since(start); elapsed >= beacon.Info().Period {
+		t.Errorf("Expected a corrupt note to fail without retries, took %s", elapsed)
+	}
+}
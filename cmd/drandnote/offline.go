@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/korjavin/drand-poc/internal/crypt/crypto"
+	"github.com/korjavin/drand-poc/internal/crypt/drand"
+)
+
+// offlineRetries is the number of attempts to fetch a round that should have been produced,
+// as relays may lag behind the round time
+const offlineRetries = 5
+
+// loadChainInfo returns the chain of the offline mode
+func (o options) loadChainInfo() (*drand.ChainInfo, error) {
+	if o.chainInfoPath != "" {
+		return drand.LoadChainInfo(o.chainInfoPath)
+	}
+	return drand.Quicknet(), nil
+}
+
+// createOffline encrypts a note locally and writes it to the output file
+func createOffline(ctx context.Context, opts options, text []byte, unlockAt time.Time, stdout io.Writer) error {
+	info, err := opts.loadChainInfo()
+	if err != nil {
+		return err
+	}
+
+	// Encrypting needs no beacon, so no drand endpoint is contacted
+	round := info.RoundFor(unlockAt)
+	if round <= info.RoundAt(time.Now()) {
+		return errors.New("unlock time must be in the future")
+	}
+
+	ciphertext, _, err := crypto.Seal(info, text, round)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt note: %w", err)
+	}
+
+	// The file is a standard age file, which the tle tool can decrypt as well
+	data := ciphertext
+	if opts.armor {
+		if data, err = crypto.Armor(ciphertext); err != nil {
+			return err
+		}
+	}
+
+	if opts.output == "" {
+		_, err = stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(opts.output, data, 0644)
+}
+
+// getOffline decrypts a note file with a beacon fetched from drand.
+// If wait is set, it sleeps until the unlock round is produced.
+func getOffline(ctx context.Context, opts options, path string, wait bool, stdout io.Writer) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read note: %w", err)
+	}
+
+	ciphertext, err := crypto.Dearmor(data)
+	if err != nil {
+		return fmt.Errorf("failed to read note: %w", err)
+	}
+	round, chainHash, err := crypto.ParseHeader(ciphertext)
+	if err != nil {
+		return fmt.Errorf("failed to parse note: %w", err)
+	}
+
+	info, err := opts.loadChainInfo()
+	if err != nil {
+		return err
+	}
+	if chainHash != info.HashString() {
+		return fmt.Errorf("note was encrypted for chain %s, not %s", chainHash, info.HashString())
+	}
+
+	client, err := drand.NewClient(info, strings.Split(opts.drandURLs, ",")...)
+	if err != nil {
+		return err
+	}
+	locker := crypto.NewLocker(client)
+
+	unlockAt := info.TimeOfRound(round)
+	if remaining := time.Until(unlockAt); remaining > 0 {
+		if !wait {
+			return fmt.Errorf("%w until %s (%ds remaining)", errLocked, unlockAt.UTC().Format(time.RFC3339), int64(remaining.Seconds()))
+		}
+		if err := sleep(ctx, remaining); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		plaintext, err := locker.Decrypt(ciphertext, round)
+		if err == nil {
+			_, err = stdout.Write(plaintext)
+			return err
+		}
+		// Only a round not published yet, e.g. with a skewed clock, or unreachable endpoints can
+		// succeed later: corrupt notes and invalid beacons fail at once
+		retry := errors.Is(err, crypto.ErrTooEarly) || errors.Is(err, crypto.ErrBeaconUnavailable)
+		if !retry || attempt == offlineRetries {
+			return fmt.Errorf("failed to decrypt note: %w", err)
+		}
+		if err := sleep(ctx, info.Period); err != nil {
+			return err
+		}
+	}
+}
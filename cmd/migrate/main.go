@@ -0,0 +1,50 @@
+// Command migrate re-encodes the notes of a Badger data directory in place
+// with the current record encoding. The server must be stopped while it runs.
+//
+// Usage:
+//
+//	migrate -data ./data
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/korjavin/drand-poc/storage"
+)
+
+func main() {
+	dataDir := flag.String("data", "./data", "Data directory for Badger DB")
+	flag.Parse()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if _, err := os.Stat(*dataDir); err != nil {
+		logger.Error("Data directory not found", "error", err)
+		os.Exit(1)
+	}
+
+	badgerOpts := badger.DefaultOptions(*dataDir)
+	badgerOpts.Logger = nil // Disable Badger's internal logger
+	store, err := storage.NewBadgerStore(badgerOpts)
+	if err != nil {
+		logger.Error("Failed to open Badger store", "error", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	migrated, err := store.Migrate(ctx)
+	if err != nil {
+		logger.Error("Migration failed", "error", err, "migrated", migrated)
+		store.Close()
+		os.Exit(1)
+	}
+	logger.Info("Migration done", "migrated", migrated)
+}
@@ -1,23 +1,45 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/dgraph-io/badger/v3"
+	"github.com/korjavin/drand-poc/internal/crypt/crypto"
+	"github.com/korjavin/drand-poc/internal/crypt/drand"
+	"github.com/korjavin/drand-poc/internal/crypt/drand/fake"
 	"github.com/korjavin/drand-poc/server"
 	"github.com/korjavin/drand-poc/storage"
+
+	_ "github.com/glebarez/go-sqlite" // Registers the pure Go "sqlite" driver of -store sqlite
+	_ "github.com/lib/pq"             // Registers the "postgres" driver of -store postgres
 )
 
 func main() {
 	// Parse command-line flags
 	addr := flag.String("addr", ":8083", "HTTP server address")
-	dataDir := flag.String("data", "./data", "Data directory for Badger DB")
+	dataDir := flag.String("data", "./data", "Data directory for Badger DB, SQLite and blobs")
+	storeKind := flag.String("store", "badger", "Note store: badger, sqlite or postgres")
+	sqlitePath := flag.String("sqlite-path", "", "Path of the SQLite database (default: DATA/notes.db)")
+	postgresDSN := flag.String("postgres-dsn", os.Getenv("DATABASE_URL"), "PostgreSQL connection string (env DATABASE_URL)")
 	staticDir := flag.String("static", "./frontend", "Static files directory")
 	baseDomain := flag.String("base-domain", "", "Base domain for URLs (default: http://localhost:PORT)")
 	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	drandURLs := flag.String("drand-url", strings.Join(drand.DefaultURLs, ","), "Comma-separated drand HTTP endpoints")
+	chainHash := flag.String("chain-hash", drand.DefaultChainHash, "Hash of the drand chain to use")
+	chainInfoPath := flag.String("chain-info", "", "Path to a drand chain info JSON file (default: fetched from the drand endpoints)")
+	drandFake := flag.Bool("drand-fake", false, "Use an in-process fake drand beacon for offline development (insecure)")
+	blobStore := flag.String("blob-store", "fs", "Where to keep large ciphertexts: fs, s3 (configured by S3_* variables) or none")
+	blobDir := flag.String("blob-dir", "", "Directory of the fs blob store (default: DATA/blobs)")
+	blobThreshold := flag.Int("blob-threshold", 64<<10, "Size in bytes from which ciphertexts are kept in the blob store")
+	defaultRetention := flag.Duration("default-retention", storage.Retention, "How long notes are kept after their unlock time, unless their creator chooses")
+	maxRetention := flag.Duration("max-retention", 30*24*time.Hour, "Longest time creators can choose to keep their notes after unlock")
 	flag.Parse()
 
 	// Set up logging
@@ -39,21 +61,72 @@ func main() {
 		Level: level,
 	}))
 
+	if *defaultRetention <= 0 || *defaultRetention > *maxRetention {
+		logger.Error("The default retention must be positive and at most the maximum retention",
+			"default_retention", *defaultRetention, "max_retention", *maxRetention)
+		os.Exit(1)
+	}
+
 	// Create the data directory if it doesn't exist
 	if err := os.MkdirAll(*dataDir, 0755); err != nil {
 		logger.Error("Failed to create data directory", "error", err)
 		os.Exit(1)
 	}
 
-	// Set up Badger DB
-	badgerOpts := badger.DefaultOptions(*dataDir)
-	badgerOpts.Logger = nil // Disable Badger's internal logger
-	store, err := storage.NewBadgerStore(badgerOpts)
+	// Set up the note store
+	store, err := newStore(*storeKind, *dataDir, *sqlitePath, *postgresDSN)
 	if err != nil {
-		logger.Error("Failed to create Badger store", "error", err)
+		logger.Error("Failed to create store", "store", *storeKind, "error", err)
 		os.Exit(1)
 	}
 	defer store.Close()
+	logger.Info("Using note store", "store", *storeKind)
+
+	// Keep large ciphertexts out of the note store
+	var notes storage.Store = store
+	blobs, err := newBlobStore(*blobStore, *blobDir, *dataDir, logger)
+	if err != nil {
+		logger.Error("Failed to create blob store", "error", err)
+		os.Exit(1)
+	}
+	if blobs != nil {
+		notes = storage.NewSplitStore(store, blobs, *blobThreshold)
+		logger.Info("Using blob store", "type", *blobStore, "threshold", *blobThreshold)
+	}
+
+	// Set up the drand client
+	var client crypto.Client
+	if *drandFake {
+		beacon := fake.NewQuicknet()
+		beacon.Run()
+		client = beacon
+		logger.Warn("Using an in-process fake drand beacon: notes are not securely timelocked")
+	} else {
+		// Load the drand chain parameters
+		urls := strings.Split(*drandURLs, ",")
+		chain, err := loadChainInfo(*chainInfoPath, urls, *chainHash)
+		if err != nil {
+			logger.Error("Failed to load drand chain info", "error", err)
+			os.Exit(1)
+		}
+
+		drandClient, err := drand.NewClient(chain, urls...)
+		if err != nil {
+			logger.Error("Failed to create drand client", "error", err)
+			os.Exit(1)
+		}
+		client = drandClient
+	}
+	chain := client.Info()
+	logger.Info("Using drand chain", "hash", chain.HashString(), "scheme", chain.Scheme.Name, "period", chain.Period)
+
+	// Cache verified beacons in memory and alongside the notes
+	cached, err := crypto.NewCachingClient(client, 1024, store)
+	if err != nil {
+		logger.Error("Failed to create beacon cache", "error", err)
+		os.Exit(1)
+	}
+	locker := crypto.NewLocker(cached)
 
 	// Set the base domain
 	if *baseDomain == "" {
@@ -79,10 +152,110 @@ func main() {
 	}
 
 	// Create and start the server
-	srv := server.NewServer(store, logger, *baseDomain, *staticDir)
+	srv := server.NewServer(notes, locker, logger, *baseDomain, *staticDir,
+		server.WithRetention(*defaultRetention, *maxRetention))
 	logger.Info("Starting server", "addr", *addr, "base_domain", *baseDomain)
 	if err := srv.Start(*addr); err != nil {
 		logger.Error("Server error", "error", err)
 		os.Exit(1)
 	}
 }
+
+// backend is a note store that also caches beacon signatures
+type backend interface {
+	storage.Store
+	crypto.SignatureStore
+	Close() error
+}
+
+// newStore opens the note store of the given kind
+func newStore(kind, dataDir, sqlitePath, postgresDSN string) (backend, error) {
+	switch kind {
+	case "badger":
+		badgerOpts := badger.DefaultOptions(dataDir)
+		badgerOpts.Logger = nil // Disable Badger's internal logger
+		return storage.NewBadgerStore(badgerOpts)
+	case "sqlite":
+		if sqlitePath == "" {
+			sqlitePath = filepath.Join(dataDir, "notes.db")
+		}
+		return storage.NewSQLiteStore(sqlitePath)
+	case "postgres":
+		if postgresDSN == "" {
+			return nil, fmt.Errorf("-postgres-dsn or DATABASE_URL is required")
+		}
+		return storage.NewPostgresStore(postgresDSN)
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
+
+// newBlobStore creates the blob store of the given type, or returns nil for none.
+// Expired blobs are swept in the background.
+func newBlobStore(kind, dir, dataDir string, logger *slog.Logger) (storage.BlobStore, error) {
+	var blobs storage.BlobStore
+	var err error
+	switch kind {
+	case "none":
+		return nil, nil
+	case "fs":
+		if dir == "" {
+			dir = filepath.Join(dataDir, "blobs")
+		}
+		blobs, err = storage.NewFSBlobStore(dir)
+	case "s3":
+		blobs, err = storage.NewS3BlobStore(storage.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    os.Getenv("S3_REGION"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			Prefix:    os.Getenv("S3_PREFIX"),
+		}, nil)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	go sweepBlobs(blobs, logger)
+	return blobs, nil
+}
+
+// sweepBlobs removes expired blobs every hour
+func sweepBlobs(blobs storage.BlobStore, logger *slog.Logger) {
+	for range time.Tick(time.Hour) {
+		removed, err := blobs.Sweep(context.Background())
+		if err != nil {
+			logger.Error("Failed to sweep blobs", "error", err)
+		} else if removed > 0 {
+			logger.Info("Swept expired blobs", "count", removed)
+		}
+	}
+}
+
+// loadChainInfo loads the chain info from a file, or fetches it from the first
+// reachable drand endpoint. The built-in quicknet parameters are used as a fallback.
+func loadChainInfo(path string, urls []string, chainHash string) (*drand.ChainInfo, error) {
+	if path != "" {
+		return drand.LoadChainInfo(path)
+	}
+
+	var lastErr error
+	for _, url := range urls {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		info, err := drand.FetchChainInfo(ctx, url, chainHash)
+		cancel()
+		if err == nil {
+			return info, nil
+		}
+		lastErr = err
+	}
+
+	if chainHash == drand.DefaultChainHash {
+		return drand.Quicknet(), nil
+	}
+
+	return nil, lastErr
+}
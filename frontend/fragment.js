@@ -0,0 +1,40 @@
+// Zero-knowledge links: the note is encrypted in the browser with a random AES-256-GCM key
+// that is only kept in the URL fragment, which browsers never send to the server.
+// The encrypted payload is base64([nonce (12 bytes)][AES-GCM ciphertext]) and is timelocked as is.
+
+function bytesToBase64(bytes) {
+    let binary = '';
+    bytes.forEach(b => binary += String.fromCharCode(b));
+    return btoa(binary);
+}
+
+function base64ToBytes(base64) {
+    return Uint8Array.from(atob(base64), c => c.charCodeAt(0));
+}
+
+// fragmentEncrypt encrypts the text with a new key and returns the payload and the key
+async function fragmentEncrypt(text) {
+    const key = crypto.getRandomValues(new Uint8Array(32));
+    const nonce = crypto.getRandomValues(new Uint8Array(12));
+    const cryptoKey = await crypto.subtle.importKey('raw', key, 'AES-GCM', false, ['encrypt']);
+    const ciphertext = await crypto.subtle.encrypt({ name: 'AES-GCM', iv: nonce }, cryptoKey, new TextEncoder().encode(text));
+    
+    const payload = new Uint8Array(nonce.length + ciphertext.byteLength);
+    payload.set(nonce);
+    payload.set(new Uint8Array(ciphertext), nonce.length);
+    
+    // The key is base64url encoded to be used in a URL
+    const encodedKey = bytesToBase64(key).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    return { payload: bytesToBase64(payload), key: encodedKey };
+}
+
+// fragmentDecrypt decrypts a payload produced by fragmentEncrypt with the key from the URL fragment
+async function fragmentDecrypt(payload, encodedKey) {
+    let base64Key = encodedKey.replace(/-/g, '+').replace(/_/g, '/');
+    base64Key += '='.repeat((4 - base64Key.length % 4) % 4);
+    
+    const data = base64ToBytes(payload);
+    const cryptoKey = await crypto.subtle.importKey('raw', base64ToBytes(base64Key), 'AES-GCM', false, ['decrypt']);
+    const plaintext = await crypto.subtle.decrypt({ name: 'AES-GCM', iv: data.slice(0, 12) }, cryptoKey, data.slice(12));
+    return new TextDecoder().decode(plaintext);
+}
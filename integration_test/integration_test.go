@@ -2,23 +2,41 @@ package integration
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
+	"mime/multipart"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"strings"
 	"testing"
 	"time"
 
+	"filippo.io/age"
+	"filippo.io/age/armor"
 	"github.com/dgraph-io/badger/v3"
+	"github.com/korjavin/drand-poc/api"
+	"github.com/korjavin/drand-poc/internal/crypt/crypto"
+	"github.com/korjavin/drand-poc/internal/crypt/drand"
+	"github.com/korjavin/drand-poc/internal/crypt/drand/fake"
 	"github.com/korjavin/drand-poc/server"
 	"github.com/korjavin/drand-poc/storage"
 )
 
-func TestIntegration(t *testing.T) {
+// startServer starts a server backed by an in-memory store and a fake drand beacon
+// running on a controllable clock. It returns the base URL of the server.
+func startServer(t *testing.T, opts ...server.Option) (string, *fake.Beacon) {
+	t.Helper()
 
 	// Set up logger
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
@@ -30,7 +48,14 @@ func TestIntegration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to create Badger store: %v", err)
 	}
-	defer store.Close()
+	t.Cleanup(func() { store.Close() })
+
+	// Keep large ciphertexts in a blob store, like the server does by default
+	blobs, err := storage.NewFSBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create blob store: %v", err)
+	}
+	notes := storage.NewSplitStore(store, blobs, 64<<10)
 
 	// Find an available port
 	listener, err := net.Listen("tcp", ":0")
@@ -40,10 +65,12 @@ func TestIntegration(t *testing.T) {
 	port := listener.Addr().(*net.TCPAddr).Port
 	listener.Close()
 
-	// Create the server in test mode
+	// Create the server with a fake drand beacon running on a controllable clock
 	addr := fmt.Sprintf(":%d", port)
 	baseDomain := fmt.Sprintf("http://localhost%s", addr)
-	srv := server.NewTestServer(store, logger, baseDomain, "../frontend")
+	beacon := fake.NewQuicknet()
+	locker := crypto.NewLocker(beacon, crypto.WithClock(beacon.Now))
+	srv := server.NewServer(notes, locker, logger, baseDomain, "../frontend", opts...)
 
 	// Start the server in a goroutine
 	go func() {
@@ -55,8 +82,52 @@ func TestIntegration(t *testing.T) {
 	// Wait for the server to start
 	time.Sleep(100 * time.Millisecond)
 
-	// Create a note with a short unlock time (5 minutes in the future)
-	unlockAt := time.Now().UTC().Add(5 * time.Minute)
+	return baseDomain, beacon
+}
+
+// createNote creates a note through the API and returns its URL
+func createNote(t *testing.T, baseURL, text string, unlockAt time.Time) string {
+	t.Helper()
+
+	return postNote(t, baseURL, api.CreateNoteRequest{
+		Text:     text,
+		UnlockAt: unlockAt.Format(time.RFC3339),
+	}).URL
+}
+
+// postNote creates a note through the API and returns the response
+func postNote(t *testing.T, baseURL string, req api.CreateNoteRequest) api.CreateNoteResponse {
+	t.Helper()
+
+	payloadBytes, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Failed to marshal payload: %v", err)
+	}
+
+	resp, err := http.Post(baseURL+"/api/note", "application/json", bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		t.Fatalf("Failed to create note: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+
+	var createResp api.CreateNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&createResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return createResp
+}
+
+func TestIntegration(t *testing.T) {
+	baseURL, beacon := startServer(t)
+	addr := strings.TrimPrefix(baseURL, "http://localhost")
+
+	// Create a note that unlocks 5 minutes in the future
+	unlockAt := beacon.Now().Add(5 * time.Minute)
 	noteText := "This is a test note for integration testing."
 
 	// Create the request payload
@@ -98,11 +169,29 @@ func TestIntegration(t *testing.T) {
 	}
 	noteURL := createResp.URL
 
-	// In test mode, we don't need to check for 403 status code
-	// because we're bypassing the time check
+	// Try to access the note before the unlock time
+	resp, err = http.Get(noteURL)
+	if err != nil {
+		t.Fatalf("Failed to get note before unlock time: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Check that we get a 403 (Forbidden) status code
+	if resp.StatusCode != http.StatusForbidden {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("Expected status code %d before unlock time, got %d: %s", http.StatusForbidden, resp.StatusCode, body)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+	if !strings.Contains(string(body), "Note Locked") || strings.Contains(string(body), noteText) {
+		t.Errorf("Expected the locked page without the note content. Got: %s", body)
+	}
 
-	// Wait until after the unlock time
-	time.Sleep(3 * time.Second)
+	// Move the beacon clock past the unlock time
+	beacon.Advance(5*time.Minute + beacon.Info().Period)
 
 	// Try to access the note after the unlock time
 	resp, err = http.Get(noteURL)
@@ -118,7 +207,7 @@ func TestIntegration(t *testing.T) {
 	}
 
 	// Check that the note content is correct
-	body, err := io.ReadAll(resp.Body)
+	body, err = io.ReadAll(resp.Body)
 	if err != nil {
 		t.Fatalf("Failed to read response body: %v", err)
 	}
@@ -129,3 +218,1427 @@ func TestIntegration(t *testing.T) {
 		t.Errorf("Note content not found in response. Got: %s", bodyStr)
 	}
 }
+
+func TestNoteAPI(t *testing.T) {
+	baseURL, beacon := startServer(t)
+
+	noteText := "This is a test note for the JSON API."
+	noteURL := createNote(t, baseURL, noteText, beacon.Now().Add(5*time.Minute))
+	apiURL := strings.Replace(noteURL, "/note/", "/api/note/", 1)
+
+	getNote := func(url string) (int, api.GetNoteResponse) {
+		t.Helper()
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("Failed to get note: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var note api.GetNoteResponse
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusLocked {
+			if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Expected a JSON response, got %s", ct)
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&note); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return resp.StatusCode, note
+	}
+
+	// A locked note reports its status without the text
+	status, note := getNote(apiURL)
+	if status != http.StatusLocked {
+		t.Fatalf("Expected status code %d before unlock time, got %d", http.StatusLocked, status)
+	}
+	if note.Status != api.NoteStatusLocked || note.Text != "" {
+		t.Errorf("Expected a locked note without text, got %+v", note)
+	}
+	if note.Round == 0 || note.RemainingSeconds <= 0 || note.RemainingSeconds > 5*60+3 {
+		t.Errorf("Unexpected round or remaining time: %+v", note)
+	}
+	if _, err := time.Parse(time.RFC3339, note.UnlockAt); err != nil {
+		t.Errorf("Expected unlock_at in RFC3339 format, got %q", note.UnlockAt)
+	}
+
+	// Move the beacon clock past the unlock time
+	beacon.Advance(5*time.Minute + beacon.Info().Period)
+
+	status, note = getNote(apiURL)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %d after unlock time, got %d", http.StatusOK, status)
+	}
+	if note.Status != api.NoteStatusUnlocked || note.Text != noteText || note.RemainingSeconds != 0 {
+		t.Errorf("Expected the unlocked note, got %+v", note)
+	}
+
+	// A missing note is not found
+	if status, _ := getNote(baseURL + "/api/note/missing/hash"); status != http.StatusNotFound {
+		t.Errorf("Expected status code %d for a missing note, got %d", http.StatusNotFound, status)
+	}
+}
+
+func TestClientSideEncryption(t *testing.T) {
+	baseURL, beacon := startServer(t)
+
+	// Get the chain parameters from the server
+	resp, err := http.Get(baseURL + "/api/chain")
+	if err != nil {
+		t.Fatalf("Failed to get chain info: %v", err)
+	}
+	defer resp.Body.Close()
+
+	info, err := drand.ParseChainInfo(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to parse chain info: %v", err)
+	}
+	if info.HashString() != beacon.Info().HashString() {
+		t.Fatalf("Unexpected chain hash. Got: %s, Want: %s", info.HashString(), beacon.Info().HashString())
+	}
+
+	// Encrypt the note locally
+	noteText := "This note was encrypted by the client."
+	round := info.RoundFor(beacon.Now().Add(5 * time.Minute))
+	ciphertext, _, err := crypto.Seal(info, []byte(noteText), round)
+	if err != nil {
+		t.Fatalf("Failed to seal note: %v", err)
+	}
+
+	post := func(req api.CreateNoteRequest) *http.Response {
+		t.Helper()
+		payloadBytes, err := json.Marshal(req)
+		if err != nil {
+			t.Fatalf("Failed to marshal payload: %v", err)
+		}
+		resp, err := http.Post(baseURL+"/api/note", "application/json", bytes.NewBuffer(payloadBytes))
+		if err != nil {
+			t.Fatalf("Failed to create note: %v", err)
+		}
+		return resp
+	}
+
+	// The server rejects malformed ciphertexts and past rounds
+	invalid := []api.CreateNoteRequest{
+		{Ciphertext: []byte("garbage"), Round: round},
+		{Ciphertext: ciphertext, Round: beacon.LatestRound()},
+		{Ciphertext: ciphertext, Round: round, Text: noteText},
+		{Ciphertext: ciphertext, Round: round, UnlockAt: beacon.Now().Format(time.RFC3339)},
+	}
+	for _, req := range invalid {
+		resp := post(req)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+
+	resp = post(api.CreateNoteRequest{Ciphertext: ciphertext, Round: round})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+
+	var createResp api.CreateNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&createResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	apiURL := strings.Replace(createResp.URL, "/note/", "/api/note/", 1)
+
+	// Once the round is produced, the server decrypts the note like its own
+	beacon.Advance(5*time.Minute + beacon.Info().Period)
+
+	resp, err = http.Get(apiURL)
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var note api.GetNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&note); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if note.Status != api.NoteStatusUnlocked || note.Text != noteText {
+		t.Errorf("Expected the unlocked note, got %+v", note)
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	baseURL, beacon := startServer(t)
+
+	// An armored file as written by tle --armor, uploaded without its round
+	noteText := "This note was encrypted with tle."
+	round := beacon.Info().RoundFor(beacon.Now().Add(5 * time.Minute))
+	ciphertext, _, err := crypto.Seal(beacon.Info(), []byte(noteText), round)
+	if err != nil {
+		t.Fatalf("Failed to seal note: %v", err)
+	}
+	armored, err := crypto.Armor(ciphertext)
+	if err != nil {
+		t.Fatalf("Failed to armor note: %v", err)
+	}
+
+	payloadBytes, err := json.Marshal(api.CreateNoteRequest{Ciphertext: armored})
+	if err != nil {
+		t.Fatalf("Failed to marshal payload: %v", err)
+	}
+	resp, err := http.Post(baseURL+"/api/note", "application/json", bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		t.Fatalf("Failed to create note: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+
+	var createResp api.CreateNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&createResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	apiURL := strings.Replace(createResp.URL, "/note/", "/api/note/", 1)
+
+	// The round was read from the tlock stanza
+	resp, err = http.Get(apiURL)
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	defer resp.Body.Close()
+	var note api.GetNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&note); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if note.Round != round {
+		t.Errorf("Unexpected round. Got: %d, Want: %d", note.Round, round)
+	}
+
+	// A locked note can be exported and decrypted with tle later
+	resp, err = http.Get(apiURL + "/export")
+	if err != nil {
+		t.Fatalf("Failed to export note: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Disposition"), ".age") {
+		t.Errorf("Unexpected Content-Disposition: %s", resp.Header.Get("Content-Disposition"))
+	}
+
+	exported, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read export: %v", err)
+	}
+	if !bytes.Equal(exported, armored) {
+		t.Errorf("Exported file differs from the uploaded one:\n%s", exported)
+	}
+
+	beacon.Advance(5*time.Minute + beacon.Info().Period)
+	locker := crypto.NewLocker(beacon, crypto.WithClock(beacon.Now))
+	plaintext, err := locker.Decrypt(exported, round)
+	if err != nil {
+		t.Fatalf("Failed to decrypt export: %v", err)
+	}
+	if string(plaintext) != noteText {
+		t.Errorf("Unexpected note. Got: %q, Want: %q", plaintext, noteText)
+	}
+}
+
+func TestFileUpload(t *testing.T) {
+	baseURL, beacon := startServer(t)
+
+	// upload posts a multipart form with the given fields, in order
+	upload := func(fields [][2]string) *http.Response {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for _, field := range fields {
+			var w io.Writer
+			var err error
+			if field[0] == "file" {
+				w, err = mw.CreateFormFile("file", "notes.txt")
+			} else {
+				w, err = mw.CreateFormField(field[0])
+			}
+			if err != nil {
+				t.Fatalf("Failed to create form field: %v", err)
+			}
+			io.WriteString(w, field[1])
+		}
+		mw.Close()
+
+		resp, err := http.Post(baseURL+"/api/file", mw.FormDataContentType(), &body)
+		if err != nil {
+			t.Fatalf("Failed to upload file: %v", err)
+		}
+		return resp
+	}
+
+	// A file spanning several encryption chunks
+	content := strings.Repeat("A line of a large file.\n", 20000)
+	unlockAt := beacon.Now().Add(5 * time.Minute).Format(time.RFC3339)
+
+	invalid := [][][2]string{
+		{{"file", content}},
+		{{"file", content}, {"unlock_at", unlockAt}},
+		{{"unlock_at", beacon.Now().Format(time.RFC3339)}, {"file", content}},
+		{{"unlock_at", unlockAt}},
+		// Options of text notes are rejected rather than ignored
+		{{"unlock_at", unlockAt}, {"fragment_key", "true"}, {"file", content}},
+		{{"unlock_at", unlockAt}, {"burn_after_reading", "maybe"}, {"file", content}},
+		{{"unlock_at", unlockAt}, {"max_views", "2"}, {"burn_after_reading", "true"}, {"file", content}},
+	}
+	for _, fields := range invalid {
+		resp := upload(fields)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+
+	resp := upload([][2]string{{"unlock_at", unlockAt}, {"file", content}})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+
+	var createResp api.CreateNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&createResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	apiURL := strings.Replace(createResp.URL, "/note/", "/api/note/", 1)
+	downloadURL := createResp.URL + "/download"
+
+	// The file and its name stay locked
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		t.Fatalf("Failed to download file: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusLocked {
+		t.Errorf("Expected status code %d, got %d", http.StatusLocked, resp.StatusCode)
+	}
+
+	beacon.Advance(5*time.Minute + beacon.Info().Period)
+
+	resp, err = http.Get(apiURL)
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var note api.GetNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&note); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := api.Attachment{Name: "notes.txt", Type: "text/plain; charset=utf-8", Size: int64(len(content))}
+	if note.Status != api.NoteStatusUnlocked || note.Attachment == nil || *note.Attachment != want {
+		t.Fatalf("Expected the unlocked attachment %+v, got %+v", want, note)
+	}
+	if note.Text != "" || note.DownloadURL != downloadURL {
+		t.Errorf("Expected only a download URL for a file, got %+v", note)
+	}
+
+	resp, err = http.Get(downloadURL)
+	if err != nil {
+		t.Fatalf("Failed to download file: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Disposition"); got != `attachment; filename=notes.txt` {
+		t.Errorf("Unexpected Content-Disposition: %s", got)
+	}
+	if got := resp.Header.Get("Content-Type"); got != want.Type {
+		t.Errorf("Unexpected Content-Type: %s", got)
+	}
+	downloaded, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(downloaded) != content {
+		t.Errorf("Downloaded file differs from the upload: got %d bytes, want %d", len(downloaded), len(content))
+	}
+
+	// Files burned after reading can be downloaded once
+	unlockAt = beacon.Now().Add(time.Minute).Format(time.RFC3339)
+	resp = upload([][2]string{{"unlock_at", unlockAt}, {"burn_after_reading", "true"}, {"file", content}})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&createResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	beacon.Advance(time.Minute + beacon.Info().Period)
+	for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+		resp, err := http.Get(createResp.URL + "/download")
+		if err != nil {
+			t.Fatalf("Failed to download file: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Expected status code %d, got %d", want, resp.StatusCode)
+		}
+	}
+}
+
+func TestFragmentKey(t *testing.T) {
+	baseURL, beacon := startServer(t)
+
+	// Encrypt the note like the browser does, with a key kept in the URL fragment
+	noteText := "Even the server operator cannot read this note."
+	key := make([]byte, 32)
+	nonce := make([]byte, 12)
+	rand.Read(key)
+	rand.Read(nonce)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("Failed to create AES cipher: %v", err)
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("Failed to create GCM: %v", err)
+	}
+	payload := base64.StdEncoding.EncodeToString(aesgcm.Seal(nonce, nonce, []byte(noteText), nil))
+
+	post := func(req api.CreateNoteRequest) *http.Response {
+		t.Helper()
+		payloadBytes, err := json.Marshal(req)
+		if err != nil {
+			t.Fatalf("Failed to marshal payload: %v", err)
+		}
+		resp, err := http.Post(baseURL+"/api/note", "application/json", bytes.NewBuffer(payloadBytes))
+		if err != nil {
+			t.Fatalf("Failed to create note: %v", err)
+		}
+		return resp
+	}
+
+	// The text must be an AES-GCM payload
+	unlockAt := beacon.Now().Add(5 * time.Minute).Format(time.RFC3339)
+	resp := post(api.CreateNoteRequest{Text: noteText, UnlockAt: unlockAt, FragmentKey: true})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for a plaintext note, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	resp = post(api.CreateNoteRequest{Text: payload, UnlockAt: unlockAt, FragmentKey: true})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+
+	var createResp api.CreateNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&createResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	beacon.Advance(5*time.Minute + beacon.Info().Period)
+
+	// The JSON API only returns the inner payload
+	resp, err = http.Get(strings.Replace(createResp.URL, "/note/", "/api/note/", 1))
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var note api.GetNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&note); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !note.FragmentKey || note.Text != payload {
+		t.Errorf("Expected the encrypted payload, got %+v", note)
+	}
+
+	// The note page decrypts in the browser and never contains the note
+	resp, err = http.Get(createResp.URL)
+	if err != nil {
+		t.Fatalf("Failed to get note page: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "fragmentDecrypt") || strings.Contains(string(body), payload) {
+		t.Errorf("Expected the decrypting page without the payload, got %d: %s", resp.StatusCode, body)
+	}
+}
+
+// deleteNote sends a DELETE request for a note with a token and returns the status code
+func deleteNote(t *testing.T, noteURL, token string) int {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodDelete, strings.Replace(noteURL, "/note/", "/api/note/", 1), nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to delete note: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// getStatus returns the status code of a GET request
+func getStatus(t *testing.T, url string) int {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to get %s: %v", url, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestDeleteNote(t *testing.T) {
+	baseURL, beacon := startServer(t)
+
+	created := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:     "This note is deleted by its creator.",
+		UnlockAt: beacon.Now().Add(5 * time.Minute).Format(time.RFC3339),
+	})
+	if created.DeleteToken == "" {
+		t.Fatalf("Expected a delete token, got %+v", created)
+	}
+	other := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:     "Another note.",
+		UnlockAt: beacon.Now().Add(5 * time.Minute).Format(time.RFC3339),
+	})
+
+	// Only the token of the note deletes it
+	if status := deleteNote(t, created.URL, ""); status != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d without a token, got %d", http.StatusUnauthorized, status)
+	}
+	if status := deleteNote(t, created.URL, other.DeleteToken); status != http.StatusForbidden {
+		t.Errorf("Expected status code %d with the token of another note, got %d", http.StatusForbidden, status)
+	}
+
+	// Notes can be deleted before they unlock
+	if status := deleteNote(t, created.URL, created.DeleteToken); status != http.StatusNoContent {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, status)
+	}
+	if status := getStatus(t, created.URL); status != http.StatusNotFound {
+		t.Errorf("Expected status code %d after delete, got %d", http.StatusNotFound, status)
+	}
+	if status := deleteNote(t, created.URL, created.DeleteToken); status != http.StatusNotFound {
+		t.Errorf("Expected status code %d when deleting twice, got %d", http.StatusNotFound, status)
+	}
+
+	if status := getStatus(t, other.URL); status != http.StatusForbidden {
+		t.Errorf("Expected the other note to be kept and locked, got %d", status)
+	}
+}
+
+func TestDeleteImportedCopy(t *testing.T) {
+	baseURL, beacon := startServer(t)
+
+	// A note large enough for the blob store, exported and imported again as a copy
+	noteText := strings.Repeat("This note is kept in the blob store. ", 4096)
+	created := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:     noteText,
+		UnlockAt: beacon.Now().Add(5 * time.Minute).Format(time.RFC3339),
+	})
+	apiURL := strings.Replace(created.URL, "/note/", "/api/note/", 1)
+
+	resp, err := http.Get(apiURL + "/export")
+	if err != nil {
+		t.Fatalf("Failed to export note: %v", err)
+	}
+	exported, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to export note: status %d, %v", resp.StatusCode, err)
+	}
+	imported := postNote(t, baseURL, api.CreateNoteRequest{Ciphertext: exported})
+
+	// Deleting the copy with its own token leaves the original intact
+	if status := deleteNote(t, imported.URL, imported.DeleteToken); status != http.StatusNoContent {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, status)
+	}
+
+	beacon.Advance(5*time.Minute + beacon.Info().Period)
+	status, note := getNoteAPI(t, apiURL)
+	if status != http.StatusOK {
+		t.Fatalf("Expected the original note to open, got status %d", status)
+	}
+	if note.Text != noteText {
+		t.Errorf("Unexpected text of %d bytes, want %d", len(note.Text), len(noteText))
+	}
+}
+
+func TestBurnAfterReading(t *testing.T) {
+	baseURL, beacon := startServer(t)
+
+	noteText := "This note can only be read once."
+	created := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:             noteText,
+		UnlockAt:         beacon.Now().Add(5 * time.Minute).Format(time.RFC3339),
+		BurnAfterReading: true,
+	})
+	apiURL := strings.Replace(created.URL, "/note/", "/api/note/", 1)
+
+	// Locked views and exports don't burn the note
+	for i := 0; i < 2; i++ {
+		if status := getStatus(t, apiURL); status != http.StatusLocked {
+			t.Fatalf("Expected status code %d before unlock time, got %d", http.StatusLocked, status)
+		}
+	}
+	if status := getStatus(t, apiURL+"/export"); status != http.StatusConflict {
+		t.Errorf("Expected status code %d for an export, got %d", http.StatusConflict, status)
+	}
+
+	beacon.Advance(5*time.Minute + beacon.Info().Period)
+
+	// The first read after unlock gets the note, the next ones don't
+	resp, err := http.Get(apiURL)
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var note api.GetNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&note); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || note.Text != noteText || note.ViewsLeft == nil || *note.ViewsLeft != 0 {
+		t.Errorf("Expected the note on the first read, got %d: %+v", resp.StatusCode, note)
+	}
+
+	if status := getStatus(t, apiURL); status != http.StatusNotFound {
+		t.Errorf("Expected status code %d on the second read, got %d", http.StatusNotFound, status)
+	}
+	if status := getStatus(t, created.URL); status != http.StatusNotFound {
+		t.Errorf("Expected status code %d for the note page, got %d", http.StatusNotFound, status)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	baseURL, beacon := startServer(t, server.WithRetention(24*time.Hour, 48*time.Hour))
+	unlockAt := beacon.Now().Add(5 * time.Minute).Truncate(time.Second)
+
+	tests := []struct {
+		retention string
+		want      time.Duration
+	}{
+		{"", 24 * time.Hour},
+		{"1h", time.Hour},
+		{"48h", 48 * time.Hour},
+	}
+	for _, tt := range tests {
+		created := postNote(t, baseURL, api.CreateNoteRequest{
+			Text:      "This note expires.",
+			UnlockAt:  unlockAt.Format(time.RFC3339),
+			Retention: tt.retention,
+		})
+		want := unlockAt.Add(tt.want).UTC().Format(time.RFC3339)
+		if created.ExpiresAt != want {
+			t.Errorf("Retention %q: expected expires_at %s, got %s", tt.retention, want, created.ExpiresAt)
+		}
+
+		// The expiry time is reported while the note is locked
+		resp, err := http.Get(strings.Replace(created.URL, "/note/", "/api/note/", 1))
+		if err != nil {
+			t.Fatalf("Failed to get note: %v", err)
+		}
+		var note api.GetNoteResponse
+		err = json.NewDecoder(resp.Body).Decode(&note)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if note.ExpiresAt != want {
+			t.Errorf("Retention %q: expected expires_at %s in the note, got %s", tt.retention, want, note.ExpiresAt)
+		}
+	}
+
+	// Retentions beyond the limit of the server are rejected
+	for _, retention := range []string{"72h", "-1h", "a week"} {
+		payload, _ := json.Marshal(api.CreateNoteRequest{
+			Text:      "This note expires.",
+			UnlockAt:  unlockAt.Format(time.RFC3339),
+			Retention: retention,
+		})
+		resp, err := http.Post(baseURL+"/api/note", "application/json", bytes.NewReader(payload))
+		if err != nil {
+			t.Fatalf("Failed to create note: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Retention %q: expected status code %d, got %d", retention, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
+
+func TestMaxViews(t *testing.T) {
+	baseURL, beacon := startServer(t)
+	unlockAt := beacon.Now().Add(5 * time.Minute).Format(time.RFC3339)
+
+	noteText := "This note can be read twice."
+	created := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:     noteText,
+		UnlockAt: unlockAt,
+		MaxViews: 2,
+	})
+	apiURL := strings.Replace(created.URL, "/note/", "/api/note/", 1)
+
+	// A file that can be downloaded once, large enough to be streamed from the blob store
+	content := strings.Repeat("This file can be downloaded once.\n", 4096)
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.WriteField("unlock_at", unlockAt)
+	mw.WriteField("max_views", "1")
+	part, _ := mw.CreateFormFile("file", "once.txt")
+	part.Write([]byte(content))
+	mw.Close()
+
+	resp, err := http.Post(baseURL+"/api/file", mw.FormDataContentType(), &form)
+	if err != nil {
+		t.Fatalf("Failed to upload file: %v", err)
+	}
+	var file api.CreateNoteResponse
+	err = json.NewDecoder(resp.Body).Decode(&file)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Failed to upload file: %d, %v", resp.StatusCode, err)
+	}
+
+	// Locked views don't count
+	for i := 0; i < 3; i++ {
+		if status := getStatus(t, apiURL); status != http.StatusLocked {
+			t.Fatalf("Expected status code %d before unlock time, got %d", http.StatusLocked, status)
+		}
+		if status := getStatus(t, file.URL+"/download"); status != http.StatusLocked {
+			t.Fatalf("Expected status code %d for a locked download, got %d", http.StatusLocked, status)
+		}
+	}
+
+	beacon.Advance(5*time.Minute + beacon.Info().Period)
+
+	for _, left := range []uint32{1, 0} {
+		resp, err := http.Get(apiURL)
+		if err != nil {
+			t.Fatalf("Failed to get note: %v", err)
+		}
+		var note api.GetNoteResponse
+		err = json.NewDecoder(resp.Body).Decode(&note)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if note.Text != noteText || note.ViewsLeft == nil || *note.ViewsLeft != left {
+			t.Errorf("Expected the note with %d views left, got %+v", left, note)
+		}
+	}
+	if status := getStatus(t, apiURL); status != http.StatusNotFound {
+		t.Errorf("Expected status code %d after the last view, got %d", http.StatusNotFound, status)
+	}
+
+	// The page of the file doesn't count, only its download
+	if status := getStatus(t, file.URL); status != http.StatusOK {
+		t.Errorf("Expected status code %d for the file page, got %d", http.StatusOK, status)
+	}
+	resp, err = http.Get(file.URL + "/download")
+	if err != nil {
+		t.Fatalf("Failed to download file: %v", err)
+	}
+	downloaded, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code %d for the download, got %d: %v", http.StatusOK, resp.StatusCode, err)
+	}
+	if string(downloaded) != content {
+		t.Errorf("Downloaded file differs: got %d bytes, want %d", len(downloaded), len(content))
+	}
+	if status := getStatus(t, file.URL+"/download"); status != http.StatusNotFound {
+		t.Errorf("Expected status code %d for a second download, got %d", http.StatusNotFound, status)
+	}
+
+	// burn_after_reading is a single view
+	payload, _ := json.Marshal(api.CreateNoteRequest{
+		Text:             noteText,
+		UnlockAt:         unlockAt,
+		MaxViews:         3,
+		BurnAfterReading: true,
+	})
+	resp, err = http.Post(baseURL+"/api/note", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Failed to create note: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for conflicting limits, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+// openNote posts a passphrase to the JSON API of a note and returns the response status and note
+func openNote(t *testing.T, apiURL, passphrase string) (int, api.GetNoteResponse) {
+	t.Helper()
+	payload, _ := json.Marshal(api.OpenNoteRequest{Passphrase: passphrase})
+	resp, err := http.Post(apiURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Failed to open note: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var note api.GetNoteResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&note); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	return resp.StatusCode, note
+}
+
+func TestPassphrase(t *testing.T) {
+	baseURL, beacon := startServer(t)
+
+	noteText := "This note needs a passphrase."
+	created := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:       noteText,
+		UnlockAt:   beacon.Now().Add(5 * time.Minute).Format(time.RFC3339),
+		Passphrase: "correct horse",
+	})
+	apiURL := strings.Replace(created.URL, "/note/", "/api/note/", 1)
+
+	// Attempts before unlock are refused without being counted
+	for i := 0; i < 6; i++ {
+		if status, _ := openNote(t, apiURL, "battery staple"); status != http.StatusLocked {
+			t.Fatalf("Expected status code %d before unlock time, got %d", http.StatusLocked, status)
+		}
+	}
+	if status := getStatus(t, apiURL+"/export"); status != http.StatusConflict {
+		t.Errorf("Expected status code %d when exporting, got %d", http.StatusConflict, status)
+	}
+
+	beacon.Advance(5*time.Minute + beacon.Info().Period)
+
+	// The unlocked note is only returned with its passphrase
+	resp, err := http.Get(apiURL)
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	var note api.GetNoteResponse
+	err = json.NewDecoder(resp.Body).Decode(&note)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to get note: %d, %v", resp.StatusCode, err)
+	}
+	if note.Status != api.NoteStatusUnlocked || !note.Passphrase || note.Text != "" {
+		t.Errorf("Expected an unlocked note without its text, got %+v", note)
+	}
+	if status := getStatus(t, created.URL+"/download"); status != http.StatusConflict {
+		t.Errorf("Expected status code %d when downloading, got %d", http.StatusConflict, status)
+	}
+
+	// The page asks for the passphrase, and posts it back
+	if status := getStatus(t, created.URL); status != http.StatusOK {
+		t.Errorf("Expected status code %d for the passphrase prompt, got %d", http.StatusOK, status)
+	}
+	resp, err = http.PostForm(created.URL, url.Values{"passphrase": {"battery staple"}})
+	if err != nil {
+		t.Fatalf("Failed to post passphrase: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status code %d for a wrong passphrase, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	// Wrong passphrases use up the attempts, after which even the right one is refused
+	for i := 1; i < 5; i++ {
+		if status, _ := openNote(t, apiURL, "battery staple"); status != http.StatusForbidden {
+			t.Fatalf("Expected status code %d for a wrong passphrase, got %d", http.StatusForbidden, status)
+		}
+	}
+	if status, _ := openNote(t, apiURL, "correct horse"); status != http.StatusTooManyRequests {
+		t.Fatalf("Expected status code %d after 5 attempts, got %d", http.StatusTooManyRequests, status)
+	}
+
+	// The attempts are available again after the window
+	beacon.Advance(15 * time.Minute)
+	status, note := openNote(t, apiURL, "correct horse")
+	if status != http.StatusOK || note.Text != noteText {
+		t.Fatalf("Expected the note with the right passphrase, got %d: %+v", status, note)
+	}
+
+	resp, err = http.PostForm(created.URL, url.Values{"passphrase": {"correct horse"}})
+	if err != nil {
+		t.Fatalf("Failed to post passphrase: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), noteText) {
+		t.Errorf("Expected the note page with the right passphrase, got %d", resp.StatusCode)
+	}
+
+	// Only text encrypted by the server can have a passphrase
+	payload, _ := json.Marshal(api.CreateNoteRequest{
+		Ciphertext: []byte("age-encryption.org/v1\n"),
+		Passphrase: "correct horse",
+	})
+	resp, err = http.Post(baseURL+"/api/note", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Failed to create note: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for a passphrase with a ciphertext, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestRecipients(t *testing.T) {
+	baseURL, beacon := startServer(t)
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+
+	noteText := "This note can be read by its recipient right away."
+	created := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:       noteText,
+		UnlockAt:   beacon.Now().Add(5 * time.Minute).Format(time.RFC3339),
+		Recipients: []string{identity.Recipient().String()},
+	})
+	apiURL := strings.Replace(created.URL, "/note/", "/api/note/", 1)
+
+	// Everyone else waits for the unlock time
+	if status := getStatus(t, apiURL); status != http.StatusLocked {
+		t.Errorf("Expected status code %d before unlock time, got %d", http.StatusLocked, status)
+	}
+
+	// The recipient decrypts the export with their identity
+	resp, err := http.Get(apiURL + "/export")
+	if err != nil {
+		t.Fatalf("Failed to export note: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d when exporting, got %d", http.StatusOK, resp.StatusCode)
+	}
+	r, err := age.Decrypt(armor.NewReader(resp.Body), identity)
+	if err != nil {
+		t.Fatalf("Failed to decrypt the export as the recipient: %v", err)
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil || string(plaintext) != noteText {
+		t.Errorf("Unexpected plaintext %q: %v", plaintext, err)
+	}
+
+	// Recipients can't be combined with limits enforced by the server
+	for _, req := range []api.CreateNoteRequest{
+		{Recipients: []string{"age1invalid"}},
+		{Recipients: []string{identity.Recipient().String()}, MaxViews: 1},
+		{Recipients: []string{identity.Recipient().String()}, Passphrase: "correct horse"},
+	} {
+		req.Text = noteText
+		req.UnlockAt = beacon.Now().Add(5 * time.Minute).Format(time.RFC3339)
+		payload, _ := json.Marshal(req)
+		resp, err := http.Post(baseURL+"/api/note", "application/json", bytes.NewReader(payload))
+		if err != nil {
+			t.Fatalf("Failed to create note: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %+v, got %d", http.StatusBadRequest, req, resp.StatusCode)
+		}
+	}
+}
+
+// approveNote posts an approval token for a note and returns the status code and response
+func approveNote(t *testing.T, noteURL, token string) (int, api.ApproveNoteResponse) {
+	t.Helper()
+
+	apiURL := strings.Replace(noteURL, "/note/", "/api/note/", 1)
+	req, err := http.NewRequest(http.MethodPost, apiURL+"/approve", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to approve note: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var approved api.ApproveNoteResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&approved); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	return resp.StatusCode, approved
+}
+
+// getNoteAPI reads a note from the JSON API and returns the response status and note
+func getNoteAPI(t *testing.T, apiURL string) (int, api.GetNoteResponse) {
+	t.Helper()
+
+	resp, err := http.Get(apiURL)
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var note api.GetNoteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&note); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp.StatusCode, note
+}
+
+func TestApprovals(t *testing.T) {
+	baseURL, beacon := startServer(t)
+
+	noteText := "This note needs two approvals out of three."
+	created := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:      noteText,
+		UnlockAt:  beacon.Now().Add(5 * time.Minute).Format(time.RFC3339),
+		Approvers: 3,
+		Approvals: 2,
+	})
+	if len(created.ApprovalTokens) != 3 {
+		t.Fatalf("Expected 3 approval tokens, got %d", len(created.ApprovalTokens))
+	}
+	apiURL := strings.Replace(created.URL, "/note/", "/api/note/", 1)
+
+	// Approvals before unlock are kept, but the note stays locked
+	if status, approved := approveNote(t, created.URL, created.ApprovalTokens[0]); status != http.StatusOK || approved.Approvals != 1 || approved.ApprovalsRequired != 2 {
+		t.Fatalf("Expected the first approval, got %d: %+v", status, approved)
+	}
+	status, note := getNoteAPI(t, apiURL)
+	if status != http.StatusLocked || note.Status != api.NoteStatusLocked || note.ApprovalsRequired != 2 {
+		t.Errorf("Expected a locked note before unlock time, got %d: %+v", status, note)
+	}
+	if status := getStatus(t, apiURL+"/export"); status != http.StatusConflict {
+		t.Errorf("Expected status code %d when exporting, got %d", http.StatusConflict, status)
+	}
+
+	beacon.Advance(5*time.Minute + beacon.Info().Period)
+
+	// One approval isn't enough once unlocked
+	status, note = getNoteAPI(t, apiURL)
+	if status != http.StatusLocked || note.Status != api.NoteStatusAwaitingApproval || note.Approvals != 1 || note.Text != "" {
+		t.Errorf("Expected a note awaiting approvals, got %d: %+v", status, note)
+	}
+	if status := getStatus(t, created.URL); status != http.StatusForbidden {
+		t.Errorf("Expected status code %d for the page awaiting approvals, got %d", http.StatusForbidden, status)
+	}
+	if status := getStatus(t, created.URL+"/download"); status != http.StatusLocked {
+		t.Errorf("Expected status code %d when downloading, got %d", http.StatusLocked, status)
+	}
+
+	// Only approval tokens approve the note, not the delete token
+	for _, token := range []string{"invalid", created.DeleteToken} {
+		if status, _ := approveNote(t, created.URL, token); status != http.StatusForbidden {
+			t.Errorf("Expected status code %d for an invalid token, got %d", http.StatusForbidden, status)
+		}
+	}
+
+	// Approving twice is not an error, and doesn't count twice
+	if status, approved := approveNote(t, created.URL, created.ApprovalTokens[0]); status != http.StatusOK || approved.Approvals != 1 {
+		t.Fatalf("Expected the same approval, got %d: %+v", status, approved)
+	}
+	if status, approved := approveNote(t, created.URL, created.ApprovalTokens[2]); status != http.StatusOK || approved.Approvals != 2 {
+		t.Fatalf("Expected the second approval, got %d: %+v", status, approved)
+	}
+
+	status, note = getNoteAPI(t, apiURL)
+	if status != http.StatusOK || note.Status != api.NoteStatusUnlocked || note.Text != noteText {
+		t.Errorf("Expected the approved note, got %d: %+v", status, note)
+	}
+	if status := getStatus(t, created.URL); status != http.StatusOK {
+		t.Errorf("Expected status code %d for the approved page, got %d", http.StatusOK, status)
+	}
+
+	// Notes without approvers can't be approved
+	plain := createNote(t, baseURL, noteText, beacon.Now().Add(5*time.Minute))
+	if status, _ := approveNote(t, plain, created.ApprovalTokens[1]); status != http.StatusConflict {
+		t.Errorf("Expected status code %d for a note without approvers, got %d", http.StatusConflict, status)
+	}
+
+	// Approvers are bounded and can't be combined with layers the server doesn't hold
+	for _, req := range []api.CreateNoteRequest{
+		{Approvals: 1},
+		{Approvers: 2, Approvals: 3},
+		{Approvers: 17},
+		{Approvers: 2, Passphrase: "correct horse"},
+	} {
+		req.Text = noteText
+		req.UnlockAt = beacon.Now().Add(5 * time.Minute).Format(time.RFC3339)
+		payload, _ := json.Marshal(req)
+		resp, err := http.Post(baseURL+"/api/note", "application/json", bytes.NewReader(payload))
+		if err != nil {
+			t.Fatalf("Failed to create note: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %+v, got %d", http.StatusBadRequest, req, resp.StatusCode)
+		}
+	}
+}
+
+// checkin posts a check-in for a note with a token and returns the status code and response
+func checkin(t *testing.T, noteURL, token string) (int, api.LockNoteResponse) {
+	t.Helper()
+
+	apiURL := strings.Replace(noteURL, "/note/", "/api/note/", 1)
+	req, err := http.NewRequest(http.MethodPost, apiURL+"/checkin", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to check in: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var checked api.LockNoteResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&checked); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	return resp.StatusCode, checked
+}
+
+func TestCheckin(t *testing.T) {
+	baseURL, beacon := startServer(t, server.WithCheckinScan(10*time.Millisecond))
+
+	noteText := "Read this if I stop checking in."
+	created := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:            noteText,
+		UnlockAt:        beacon.Now().Add(10 * time.Minute).Format(time.RFC3339),
+		CheckinInterval: "10m",
+		Retention:       "1h",
+	})
+	apiURL := strings.Replace(created.URL, "/note/", "/api/note/", 1)
+
+	// Each check-in moves the unlock time and the expiry to the interval after it
+	beacon.Advance(8 * time.Minute)
+	status, checked := checkin(t, created.URL, created.DeleteToken)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %d for a check-in, got %d", http.StatusOK, status)
+	}
+	unlockAt, err := time.Parse(time.RFC3339, checked.UnlockAt)
+	if err != nil || !unlockAt.Equal(beacon.Now().Add(10*time.Minute).Truncate(time.Second)) {
+		t.Errorf("Expected the note to unlock 10 minutes after the check-in, got %s", checked.UnlockAt)
+	}
+	expiresAt, err := time.Parse(time.RFC3339, checked.ExpiresAt)
+	if err != nil || !expiresAt.Equal(unlockAt.Add(time.Hour)) {
+		t.Errorf("Expected the note to expire an hour after unlock, got %s", checked.ExpiresAt)
+	}
+
+	// Past the first deadline, the note is still locked
+	beacon.Advance(8 * time.Minute)
+	status, note := getNoteAPI(t, apiURL)
+	if status != http.StatusLocked || note.Round != checked.Round || note.UnlockAt != checked.UnlockAt {
+		t.Errorf("Expected the note locked to the new round, got %d: %+v", status, note)
+	}
+	if status := getStatus(t, apiURL+"/export"); status != http.StatusConflict {
+		t.Errorf("Expected status code %d when exporting, got %d", http.StatusConflict, status)
+	}
+	for _, token := range []string{"invalid", ""} {
+		if status, _ := checkin(t, created.URL, token); status == http.StatusOK {
+			t.Errorf("Expected a check-in with token %q to fail", token)
+		}
+	}
+
+	// Once the owner misses a check-in, the note unlocks and can't be locked again
+	beacon.Advance(2*time.Minute + beacon.Info().Period)
+	status, note = getNoteAPI(t, apiURL)
+	if status != http.StatusOK || note.Text != noteText {
+		t.Errorf("Expected the unlocked note, got %d: %+v", status, note)
+	}
+	if status, _ := checkin(t, created.URL, created.DeleteToken); status != http.StatusConflict {
+		t.Errorf("Expected status code %d for a check-in after unlock, got %d", http.StatusConflict, status)
+	}
+
+	// The scheduler then releases the note: it drops its owner copy, so it can be exported,
+	// and its owner can't extend it either
+	deadline := time.Now().Add(5 * time.Second)
+	for getStatus(t, apiURL+"/export") != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the note released by the scheduler")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	extendAt := beacon.Now().Add(time.Hour).Format(time.RFC3339)
+	if status, _ := extendNote(t, created.URL, created.DeleteToken, api.ExtendNoteRequest{UnlockAt: extendAt}); status != http.StatusConflict {
+		t.Errorf("Expected status code %d when extending a released note, got %d", http.StatusConflict, status)
+	}
+
+	// A check-in never moves the unlock time of an extended note earlier
+	extended := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:            noteText,
+		UnlockAt:        beacon.Now().Add(10 * time.Minute).Format(time.RFC3339),
+		CheckinInterval: "10m",
+	})
+	status, locked := extendNote(t, extended.URL, extended.DeleteToken, api.ExtendNoteRequest{UnlockAt: extendAt})
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %d when extending the note, got %d", http.StatusOK, status)
+	}
+	if status, _ := checkin(t, locked.URL, extended.DeleteToken); status != http.StatusConflict {
+		t.Errorf("Expected status code %d for a check-in of an extended note, got %d", http.StatusConflict, status)
+	}
+	if status, note := getNoteAPI(t, strings.Replace(locked.URL, "/note/", "/api/note/", 1)); status != http.StatusLocked || note.UnlockAt != extendAt {
+		t.Errorf("Expected the note still locked until %s, got %d: %+v", extendAt, status, note)
+	}
+
+	// Notes without a check-in interval don't take check-ins
+	plain := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:     noteText,
+		UnlockAt: beacon.Now().Add(5 * time.Minute).Format(time.RFC3339),
+	})
+	if status, _ := checkin(t, plain.URL, plain.DeleteToken); status != http.StatusConflict {
+		t.Errorf("Expected status code %d for a note without check-ins, got %d", http.StatusConflict, status)
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	for _, req := range []api.CreateNoteRequest{
+		{CheckinInterval: "soon"},
+		{CheckinInterval: "10s"},
+		{CheckinInterval: "10m", Recipients: []string{identity.Recipient().String()}},
+	} {
+		req.Text = noteText
+		req.UnlockAt = beacon.Now().Add(5 * time.Minute).Format(time.RFC3339)
+		payload, _ := json.Marshal(req)
+		resp, err := http.Post(baseURL+"/api/note", "application/json", bytes.NewReader(payload))
+		if err != nil {
+			t.Fatalf("Failed to create note: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %+v, got %d", http.StatusBadRequest, req, resp.StatusCode)
+		}
+	}
+}
+
+// extendNote sends a PATCH request for a note with a token and returns the status code and response
+func extendNote(t *testing.T, noteURL, token string, body api.ExtendNoteRequest) (int, api.LockNoteResponse) {
+	t.Helper()
+
+	payload, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPatch, strings.Replace(noteURL, "/note/", "/api/note/", 1), bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to extend note: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var locked api.LockNoteResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&locked); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	return resp.StatusCode, locked
+}
+
+func TestExtendNote(t *testing.T) {
+	baseURL, beacon := startServer(t)
+	info := beacon.Info()
+
+	seal := func(text string, unlockAt time.Time) ([]byte, uint64) {
+		t.Helper()
+		round := info.RoundFor(unlockAt)
+		ciphertext, _, err := crypto.Seal(info, []byte(text), round)
+		if err != nil {
+			t.Fatalf("Failed to seal note: %v", err)
+		}
+		return ciphertext, round
+	}
+
+	// A note encrypted by the client is extended with a new ciphertext
+	noteText := "This note was encrypted by the client."
+	ciphertext, round := seal(noteText, beacon.Now().Add(5*time.Minute))
+	created := postNote(t, baseURL, api.CreateNoteRequest{Ciphertext: ciphertext, Round: round, Retention: "1h"})
+	apiURL := strings.Replace(created.URL, "/note/", "/api/note/", 1)
+
+	later, laterRound := seal(noteText, beacon.Now().Add(15*time.Minute))
+	if status, _ := extendNote(t, created.URL, "", api.ExtendNoteRequest{Ciphertext: later}); status != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d without a token, got %d", http.StatusUnauthorized, status)
+	}
+	if status, _ := extendNote(t, created.URL, "invalid", api.ExtendNoteRequest{Ciphertext: later}); status != http.StatusForbidden {
+		t.Errorf("Expected status code %d for an invalid token, got %d", http.StatusForbidden, status)
+	}
+	earlier, _ := seal(noteText, beacon.Now().Add(2*time.Minute))
+	if status, _ := extendNote(t, created.URL, created.DeleteToken, api.ExtendNoteRequest{Ciphertext: earlier}); status != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an earlier round, got %d", http.StatusBadRequest, status)
+	}
+	// The round of the request must be the one the ciphertext is locked to
+	if status, _ := extendNote(t, created.URL, created.DeleteToken, api.ExtendNoteRequest{Ciphertext: earlier, Round: laterRound}); status != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for a ciphertext locked to an earlier round than claimed, got %d", http.StatusBadRequest, status)
+	}
+
+	// Without an owner copy, the server can't encrypt the note again itself
+	unlockAt := beacon.Now().Add(15 * time.Minute).Format(time.RFC3339)
+	if status, _ := extendNote(t, created.URL, created.DeleteToken, api.ExtendNoteRequest{UnlockAt: unlockAt}); status != http.StatusConflict {
+		t.Errorf("Expected status code %d without a ciphertext, got %d", http.StatusConflict, status)
+	}
+
+	status, locked := extendNote(t, created.URL, created.DeleteToken, api.ExtendNoteRequest{Ciphertext: later})
+	if status != http.StatusOK || locked.Round != laterRound {
+		t.Fatalf("Expected the note extended to round %d, got %d: %+v", laterRound, status, locked)
+	}
+
+	// The note moves to the hash of its new ciphertext
+	sum := sha256.Sum256(later)
+	if want := strings.TrimSuffix(created.URL, path.Base(created.URL)) + hex.EncodeToString(sum[:]); locked.URL != want {
+		t.Errorf("Expected the new link %s, got %s", want, locked.URL)
+	}
+	resp, err := http.Get(apiURL)
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code %d for the previous link, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+	if status, _ := extendNote(t, created.URL, created.DeleteToken, api.ExtendNoteRequest{Ciphertext: later}); status != http.StatusNotFound {
+		t.Errorf("Expected status code %d when extending the previous link, got %d", http.StatusNotFound, status)
+	}
+	created.URL = locked.URL
+	apiURL = strings.Replace(created.URL, "/note/", "/api/note/", 1)
+	expiresAt, err := time.Parse(time.RFC3339, locked.ExpiresAt)
+	if err != nil || !expiresAt.Equal(info.TimeOfRound(laterRound).Add(time.Hour)) {
+		t.Errorf("Expected the note to expire an hour after the new unlock time, got %s", locked.ExpiresAt)
+	}
+
+	beacon.Advance(10 * time.Minute)
+	if status, note := getNoteAPI(t, apiURL); status != http.StatusLocked || note.Round != laterRound {
+		t.Errorf("Expected the note locked to round %d past its first unlock time, got %d: %+v", laterRound, status, note)
+	}
+	beacon.Advance(5*time.Minute + info.Period)
+	if status, note := getNoteAPI(t, apiURL); status != http.StatusOK || note.Text != noteText {
+		t.Errorf("Expected the note unlocked at its new time, got %d: %+v", status, note)
+	}
+
+	// Unlocked notes can be locked again
+	relocked, relockedRound := seal(noteText, beacon.Now().Add(5*time.Minute))
+	status, locked = extendNote(t, created.URL, created.DeleteToken, api.ExtendNoteRequest{Ciphertext: relocked})
+	if status != http.StatusOK {
+		t.Errorf("Expected status code %d when locking the note again, got %d", http.StatusOK, status)
+	}
+	apiURL = strings.Replace(locked.URL, "/note/", "/api/note/", 1)
+	if status, note := getNoteAPI(t, apiURL); status != http.StatusLocked || note.Round != relockedRound {
+		t.Errorf("Expected the note locked again, got %d: %+v", status, note)
+	}
+
+	// Notes with check-ins are encrypted again by the server from their owner copy
+	switchNote := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:            noteText,
+		UnlockAt:        beacon.Now().Add(10 * time.Minute).Format(time.RFC3339),
+		CheckinInterval: "10m",
+	})
+	unlockAt = beacon.Now().Add(time.Hour).Format(time.RFC3339)
+	status, locked = extendNote(t, switchNote.URL, switchNote.DeleteToken, api.ExtendNoteRequest{UnlockAt: unlockAt})
+	if status != http.StatusOK || locked.UnlockAt != unlockAt {
+		t.Errorf("Expected the note extended to %s, got %d: %+v", unlockAt, status, locked)
+	}
+	switchNote.URL = locked.URL
+	earlierAt := beacon.Now().Add(30 * time.Minute).Format(time.RFC3339)
+	if status, _ := extendNote(t, switchNote.URL, switchNote.DeleteToken, api.ExtendNoteRequest{UnlockAt: earlierAt}); status != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an earlier unlock time, got %d", http.StatusBadRequest, status)
+	}
+	if status, _ := extendNote(t, switchNote.URL, switchNote.DeleteToken, api.ExtendNoteRequest{Ciphertext: later}); status != http.StatusConflict {
+		t.Errorf("Expected status code %d for a ciphertext of a note with check-ins, got %d", http.StatusConflict, status)
+	}
+	beacon.Advance(30 * time.Minute)
+	if status, _ := getNoteAPI(t, strings.Replace(switchNote.URL, "/note/", "/api/note/", 1)); status != http.StatusLocked {
+		t.Errorf("Expected the extended note still locked, got %d", status)
+	}
+
+	// Notes encrypted by the server are extended from their owner copy, with their passphrase
+	// and approval layers kept, and can't be replaced by the client
+	protected := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:       noteText,
+		UnlockAt:   beacon.Now().Add(5 * time.Minute).Format(time.RFC3339),
+		Passphrase: "correct horse",
+	})
+	approved := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:      noteText,
+		UnlockAt:  beacon.Now().Add(5 * time.Minute).Format(time.RFC3339),
+		Approvers: 1,
+	})
+	ciphertext, _ = seal(noteText, beacon.Now().Add(time.Hour))
+	unlockAt = beacon.Now().Add(20 * time.Minute).Format(time.RFC3339)
+	for _, created := range []*api.CreateNoteResponse{&protected, &approved} {
+		if status, _ := extendNote(t, created.URL, created.DeleteToken, api.ExtendNoteRequest{Ciphertext: ciphertext}); status != http.StatusConflict {
+			t.Errorf("Expected status code %d for a ciphertext of a note encrypted by the server, got %d", http.StatusConflict, status)
+		}
+		status, locked := extendNote(t, created.URL, created.DeleteToken, api.ExtendNoteRequest{UnlockAt: unlockAt})
+		if status != http.StatusOK || locked.UnlockAt != unlockAt {
+			t.Fatalf("Expected the note extended to %s, got %d: %+v", unlockAt, status, locked)
+		}
+		created.URL = locked.URL
+	}
+
+	beacon.Advance(10 * time.Minute)
+	protectedAPI := strings.Replace(protected.URL, "/note/", "/api/note/", 1)
+	if status, note := getNoteAPI(t, protectedAPI); status != http.StatusLocked || note.Status != api.NoteStatusLocked {
+		t.Errorf("Expected the note with a passphrase locked past its first unlock time, got %d: %+v", status, note)
+	}
+	beacon.Advance(10*time.Minute + info.Period)
+	if status, _ := openNote(t, protectedAPI, "wrong"); status != http.StatusForbidden {
+		t.Errorf("Expected status code %d for a wrong passphrase, got %d", http.StatusForbidden, status)
+	}
+	if status, note := openNote(t, protectedAPI, "correct horse"); status != http.StatusOK || note.Text != noteText {
+		t.Errorf("Expected the extended note opened with its passphrase, got %d: %+v", status, note)
+	}
+	approvedAPI := strings.Replace(approved.URL, "/note/", "/api/note/", 1)
+	if status, note := getNoteAPI(t, approvedAPI); status != http.StatusLocked || note.Status != api.NoteStatusAwaitingApproval {
+		t.Errorf("Expected the extended note awaiting approval, got %d: %+v", status, note)
+	}
+	if status, _ := approveNote(t, approved.URL, approved.ApprovalTokens[0]); status != http.StatusOK {
+		t.Errorf("Expected status code %d when approving the extended note, got %d", http.StatusOK, status)
+	}
+	if status, note := getNoteAPI(t, approvedAPI); status != http.StatusOK || note.Text != noteText {
+		t.Errorf("Expected the extended note once approved, got %d: %+v", status, note)
+	}
+
+	// Notes encrypted by the server to recipients have no owner copy: the client encrypts them
+	// again, to their recipients too
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	shared := postNote(t, baseURL, api.CreateNoteRequest{
+		Text:       noteText,
+		UnlockAt:   beacon.Now().Add(5 * time.Minute).Format(time.RFC3339),
+		Recipients: []string{identity.Recipient().String()},
+	})
+	unlockAt = beacon.Now().Add(time.Hour).Format(time.RFC3339)
+	if status, _ := extendNote(t, shared.URL, shared.DeleteToken, api.ExtendNoteRequest{UnlockAt: unlockAt}); status != http.StatusConflict {
+		t.Errorf("Expected status code %d for a note with recipients without a ciphertext, got %d", http.StatusConflict, status)
+	}
+	ciphertext, _ = seal(noteText, beacon.Now().Add(time.Hour))
+	if status, _ := extendNote(t, shared.URL, shared.DeleteToken, api.ExtendNoteRequest{Ciphertext: ciphertext}); status != http.StatusOK {
+		t.Errorf("Expected status code %d for a ciphertext of a note with recipients, got %d", http.StatusOK, status)
+	}
+}
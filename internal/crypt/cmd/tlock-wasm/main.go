@@ -0,0 +1,99 @@
+//go:build js && wasm
+
+// Command tlock-wasm exposes timelock encryption to JavaScript, so that the browser
+// can encrypt notes locally and upload only the ciphertext.
+//
+// It registers a global tlock object with a single function:
+//
+//	tlock.encrypt(chainInfoJSON, plaintext, unlockAt[, recipients]) -> {ciphertext, round, hash, error}
+//
+// chainInfoJSON is the drand chain info, plaintext a string or Uint8Array and unlockAt
+// an RFC3339 time. recipients is an optional array of age or base64 X25519 public keys
+// that can decrypt the note at any time. The ciphertext is base64 encoded and the hash hex encoded.
+// On failure only error is set.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"syscall/js"
+	"time"
+
+	"github.com/korjavin/drand-poc/internal/crypt/crypto"
+	"github.com/korjavin/drand-poc/internal/crypt/drand"
+)
+
+func main() {
+	js.Global().Set("tlock", js.ValueOf(map[string]any{
+		"encrypt": js.FuncOf(encrypt),
+	}))
+
+	// Keep the exported functions alive
+	select {}
+}
+
+// encrypt implements tlock.encrypt
+func encrypt(this js.Value, args []js.Value) any {
+	result, err := encryptArgs(args)
+	if err != nil {
+		return js.ValueOf(map[string]any{"error": err.Error()})
+	}
+	return js.ValueOf(result)
+}
+
+// encryptArgs parses the arguments of tlock.encrypt and seals the plaintext
+func encryptArgs(args []js.Value) (map[string]any, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return nil, fmt.Errorf("expected 3 or 4 arguments, got %d", len(args))
+	}
+
+	// The chain hash is verified against the parameters
+	info, err := drand.ParseChainInfo(strings.NewReader(args[0].String()))
+	if err != nil {
+		return nil, err
+	}
+
+	var plaintext []byte
+	if args[1].Type() == js.TypeString {
+		plaintext = []byte(args[1].String())
+	} else {
+		plaintext = make([]byte, args[1].Get("length").Int())
+		js.CopyBytesToGo(plaintext, args[1])
+	}
+
+	unlockAt, err := time.Parse(time.RFC3339, args[2].String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid unlock time: %w", err)
+	}
+
+	// Calculate the first round produced at or after the unlock time
+	round := info.RoundFor(unlockAt)
+	if round <= info.RoundAt(time.Now()) {
+		return nil, fmt.Errorf("unlock time must be in the future")
+	}
+
+	var keys []string
+	if len(args) == 4 && !args[3].IsUndefined() && !args[3].IsNull() {
+		for i := 0; i < args[3].Length(); i++ {
+			keys = append(keys, args[3].Index(i).String())
+		}
+	}
+	recipients, err := crypto.ParseRecipients(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, hash, err := crypto.Seal(info, plaintext, round, recipients...)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"ciphertext": base64.StdEncoding.EncodeToString(ciphertext),
+		// Rounds fit in a JavaScript number for millions of years
+		"round": float64(round),
+		"hash":  hex.EncodeToString(hash),
+	}, nil
+}
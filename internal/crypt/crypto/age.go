@@ -0,0 +1,315 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/korjavin/drand-poc/internal/crypt/drand"
+)
+
+// StanzaType is the type of the age recipient stanza of timelocked files.
+// Its arguments are the round and the chain hash, as written by the tle tool:
+//
+//	-> tlock {round} {chainhash}
+const StanzaType = "tlock"
+
+// ageFileKeySize is the length of the age file key wrapped in the tlock stanza
+const ageFileKeySize = 16
+
+// ageHeader is the first line of a binary age file
+const ageHeader = "age-encryption.org/v1\n"
+
+// Format identifies the encoding of a ciphertext
+type Format byte
+
+// Ciphertext formats. Legacy blobs start with a compressed curve point, whose first byte
+// always has its top bit set, so they can never be mistaken for an age file.
+const (
+	// FormatLegacy is [wrapped key][nonce][AES-GCM], written before the age format
+	FormatLegacy Format = 0
+
+	// FormatAge is an age file with a tlock stanza, compatible with the tle tool
+	FormatAge Format = 1
+)
+
+// DetectFormat returns the format of a binary or armored ciphertext
+func DetectFormat(ciphertext []byte) Format {
+	if bytes.HasPrefix(ciphertext, []byte(ageHeader)) || isArmored(ciphertext) {
+		return FormatAge
+	}
+	return FormatLegacy
+}
+
+// isArmored reports whether the data is an ASCII-armored age file
+func isArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte(armor.Header))
+}
+
+// Armor encodes a binary age file in the ASCII armor format
+func Armor(ciphertext []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := ArmorStream(&buf, bytes.NewReader(ciphertext)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ArmorStream encodes a binary age file read from src in the ASCII armor format to dst
+func ArmorStream(dst io.Writer, src io.Reader) error {
+	w := armor.NewWriter(dst)
+	if _, err := io.Copy(w, src); err != nil {
+		return fmt.Errorf("failed to armor ciphertext: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to armor ciphertext: %w", err)
+	}
+	return nil
+}
+
+// Dearmor decodes an ASCII-armored age file. Other ciphertexts are returned unchanged.
+func Dearmor(data []byte) ([]byte, error) {
+	if !isArmored(data) {
+		return data, nil
+	}
+
+	ciphertext, err := io.ReadAll(armor.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+	return ciphertext, nil
+}
+
+// ParseHeader returns the round and chain hash of the tlock stanza of an age file
+func ParseHeader(ciphertext []byte) (round uint64, chainHash string, err error) {
+	args, _, err := readStanza(ciphertext)
+	if err != nil {
+		return 0, "", err
+	}
+	return parseStanzaArgs(args)
+}
+
+// readStanza returns the arguments and body of the tlock stanza of an age file
+func readStanza(ciphertext []byte) (args []string, body []byte, err error) {
+	ciphertext, err = Dearmor(ciphertext)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := bufio.NewReader(bytes.NewReader(ciphertext))
+	line, err := r.ReadString('\n')
+	if err != nil || line != ageHeader {
+		return nil, nil, fmt.Errorf("%w: not an age file", ErrInvalidCiphertext)
+	}
+
+	// The header ends with the MAC line
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: truncated age header", ErrInvalidCiphertext)
+		}
+		if strings.HasPrefix(line, "---") {
+			return nil, nil, fmt.Errorf("%w: no %s stanza", ErrInvalidCiphertext, StanzaType)
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(line, "->"))
+		if !strings.HasPrefix(line, "-> ") || len(fields) == 0 || fields[0] != StanzaType {
+			continue
+		}
+
+		// The body is wrapped at 64 columns and ends with a shorter line
+		var encoded strings.Builder
+		for {
+			bodyLine, err := r.ReadString('\n')
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: truncated %s stanza", ErrInvalidCiphertext, StanzaType)
+			}
+			bodyLine = strings.TrimSuffix(bodyLine, "\n")
+			encoded.WriteString(bodyLine)
+			if len(bodyLine) < 64 {
+				break
+			}
+		}
+
+		body, err := base64.RawStdEncoding.Strict().DecodeString(encoded.String())
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: invalid %s stanza body", ErrInvalidCiphertext, StanzaType)
+		}
+		return fields[1:], body, nil
+	}
+}
+
+// parseStanzaArgs parses the round and chain hash arguments of a tlock stanza
+func parseStanzaArgs(args []string) (round uint64, chainHash string, err error) {
+	if len(args) != 2 {
+		return 0, "", fmt.Errorf("%w: %s stanza has %d arguments", ErrInvalidCiphertext, StanzaType, len(args))
+	}
+
+	round, err = strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("%w: invalid round %q", ErrInvalidCiphertext, args[0])
+	}
+	return round, args[1], nil
+}
+
+// tlockRecipient wraps the age file key towards a round of the chain
+type tlockRecipient struct {
+	info  *drand.ChainInfo
+	round uint64
+}
+
+// Wrap implements the age.Recipient interface
+func (r *tlockRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	body, err := wrapKey(r.info.Scheme, r.info.PublicKey, r.round, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*age.Stanza{{
+		Type: StanzaType,
+		Args: []string{strconv.FormatUint(r.round, 10), r.info.HashString()},
+		Body: body,
+	}}, nil
+}
+
+// tlockIdentity unwraps the age file key with the signature of a round
+type tlockIdentity struct {
+	info      *drand.ChainInfo
+	round     uint64
+	signature []byte
+}
+
+// Unwrap implements the age.Identity interface
+func (i *tlockIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.Type != StanzaType {
+			continue
+		}
+
+		round, chainHash, err := parseStanzaArgs(s.Args)
+		if err != nil {
+			return nil, err
+		}
+		if round != i.round || chainHash != i.info.HashString() {
+			continue
+		}
+
+		if len(s.Body) != wrappedKeySize(i.info.Scheme, ageFileKeySize) {
+			return nil, fmt.Errorf("%w: invalid %s stanza body length %d", ErrInvalidCiphertext, StanzaType, len(s.Body))
+		}
+		return unwrapKey(i.info.Scheme, i.signature, s.Body)
+	}
+
+	return nil, fmt.Errorf("%w: no %s stanza for round %d of chain %s", age.ErrIncorrectIdentity, StanzaType, i.round, i.info.HashString())
+}
+
+// sealAge encrypts the plaintext into a binary age file timelocked to the round,
+// which the recipients can also decrypt at any time
+func sealAge(info *drand.ChainInfo, plaintext []byte, round uint64, recipients ...age.Recipient) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := sealAgeStream(info, &buf, bytes.NewReader(plaintext), round, recipients...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sealAgeStream encrypts src into a binary age file timelocked to the round, written to dst.
+// The file key is also wrapped for the recipients, after the tlock stanza.
+func sealAgeStream(info *drand.ChainInfo, dst io.Writer, src io.Reader, round uint64, recipients ...age.Recipient) error {
+	// Fail early with the same error as the other formats for unusable schemes
+	if _, err := pairingSuite(info.Scheme); err != nil {
+		return err
+	}
+
+	all := append([]age.Recipient{&tlockRecipient{info: info, round: round}}, recipients...)
+	w, err := age.Encrypt(dst, all...)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		return fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return nil
+}
+
+// openAge decrypts a binary or armored age file with the signature of the round
+func openAge(info *drand.ChainInfo, ciphertext []byte, round uint64, signature []byte) ([]byte, error) {
+	r, err := openAgeStream(info, bytes.NewReader(ciphertext), round, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+// openAgeStream returns a reader of the plaintext of a binary or armored age file
+// read from src, decrypted with the signature of the round
+func openAgeStream(info *drand.ChainInfo, src io.Reader, round uint64, signature []byte) (io.Reader, error) {
+	// Armored files may start with whitespace, so peek past it
+	br := bufio.NewReader(src)
+	for {
+		b, err := br.Peek(1)
+		if err != nil || !strings.ContainsRune(" \t\r\n", rune(b[0])) {
+			break
+		}
+		br.Discard(1)
+	}
+
+	var r io.Reader = br
+	if prefix, _ := br.Peek(len(armor.Header)); string(prefix) == armor.Header {
+		r = armor.NewReader(br)
+	} else if prefix, _ := br.Peek(len(ageHeader)); string(prefix) != ageHeader {
+		return nil, ErrLegacyFormat
+	}
+
+	plaintext, err := age.Decrypt(r, &tlockIdentity{info: info, round: round, signature: signature})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+// validateAge checks the header of an age file produced by sealAge for the round.
+// The MAC and payload can only be checked with the file key, once the round is produced.
+func validateAge(info *drand.ChainInfo, ciphertext []byte, round uint64) error {
+	args, body, err := readStanza(ciphertext)
+	if err != nil {
+		return err
+	}
+
+	stanzaRound, chainHash, err := parseStanzaArgs(args)
+	if err != nil {
+		return err
+	}
+	if chainHash != info.HashString() {
+		return fmt.Errorf("%w: encrypted for chain %s", ErrInvalidCiphertext, chainHash)
+	}
+	if stanzaRound != round {
+		return fmt.Errorf("%w: encrypted for round %d, not %d", ErrInvalidCiphertext, stanzaRound, round)
+	}
+
+	if len(body) != wrappedKeySize(info.Scheme, ageFileKeySize) {
+		return fmt.Errorf("%w: invalid %s stanza body length %d", ErrInvalidCiphertext, StanzaType, len(body))
+	}
+
+	// The wrapped key starts with a point on the key group
+	u := info.Scheme.KeyGroup.Point()
+	if err := u.UnmarshalBinary(body[:info.Scheme.KeyGroup.PointLen()]); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	dcrypto "github.com/drand/drand/crypto"
+)
+
+func TestSealWritesTlockStanza(t *testing.T) {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSUnchainedSwapped())
+	info := beacon.chainInfo(time.Now(), 3*time.Second)
+	round := uint64(1234)
+
+	ciphertext, _, err := Seal(info, []byte("This is a secret message"), round)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	// The header is the one written by tle
+	header := fmt.Sprintf("age-encryption.org/v1\n-> tlock %d %s\n", round, info.HashString())
+	if !bytes.HasPrefix(ciphertext, []byte(header)) {
+		t.Errorf("Unexpected header: %q", ciphertext[:len(header)])
+	}
+
+	parsedRound, chainHash, err := ParseHeader(ciphertext)
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	if parsedRound != round || chainHash != info.HashString() {
+		t.Errorf("Unexpected stanza. Got: %d %s, Want: %d %s", parsedRound, chainHash, round, info.HashString())
+	}
+
+	// The stanza body holds the 16-byte age file key wrapped like by tle
+	_, body, err := readStanza(ciphertext)
+	if err != nil {
+		t.Fatalf("readStanza failed: %v", err)
+	}
+	if len(body) != info.Scheme.KeyGroup.PointLen()+2*ageFileKeySize {
+		t.Errorf("Unexpected stanza body length: %d", len(body))
+	}
+}
+
+func TestArmor(t *testing.T) {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSUnchainedG1())
+	info := beacon.chainInfo(time.Now().Add(-time.Minute), time.Second)
+	plaintext := []byte("This is a secret message")
+	round := uint64(1)
+
+	ciphertext, _, err := Seal(info, plaintext, round)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	armored, err := Armor(ciphertext)
+	if err != nil {
+		t.Fatalf("Armor failed: %v", err)
+	}
+	if !strings.HasPrefix(string(armored), "-----BEGIN AGE ENCRYPTED FILE-----\n") {
+		t.Errorf("Unexpected armored file: %s", armored)
+	}
+	if DetectFormat(armored) != FormatAge {
+		t.Errorf("Expected an armored file to be detected as age")
+	}
+
+	dearmored, err := Dearmor(armored)
+	if err != nil {
+		t.Fatalf("Dearmor failed: %v", err)
+	}
+	if !bytes.Equal(dearmored, ciphertext) {
+		t.Errorf("Dearmored file doesn't match the original")
+	}
+
+	// Armored files are decrypted directly
+	locker := NewLocker(&mockClient{info: info, signature: beacon.sign(t, round)})
+	decrypted, err := locker.Decrypt(armored, round)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("Decrypted text doesn't match original. Got: %s, Want: %s", decrypted, plaintext)
+	}
+}
+
+func TestParseHeaderErrors(t *testing.T) {
+	tests := map[string]string{
+		"not age":        "hello",
+		"no stanza":      "age-encryption.org/v1\n-> X25519 abc\nAAAA\n--- mac\n",
+		"bad round":      "age-encryption.org/v1\n-> tlock soon abc\nAAAA\n--- mac\n",
+		"missing hash":   "age-encryption.org/v1\n-> tlock 1\nAAAA\n--- mac\n",
+		"truncated":      "age-encryption.org/v1\n-> tlock 1 abc\n",
+		"invalid body":   "age-encryption.org/v1\n-> tlock 1 abc\n!!!!\n--- mac\n",
+		"invalid armor":  "-----BEGIN AGE ENCRYPTED FILE-----\n!!!!\n-----END AGE ENCRYPTED FILE-----\n",
+		"no header line": "",
+	}
+
+	for name, ciphertext := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := ParseHeader([]byte(ciphertext)); !errors.Is(err, ErrInvalidCiphertext) {
+				t.Errorf("Expected ErrInvalidCiphertext, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSUnchainedG1())
+	info := beacon.chainInfo(time.Now().Add(-time.Minute), time.Second)
+	plaintext := []byte("This is a secret message")
+	round := uint64(1)
+
+	legacy, err := sealLegacy(plaintext, info.Scheme, info.PublicKey, round)
+	if err != nil {
+		t.Fatalf("sealLegacy failed: %v", err)
+	}
+	if DetectFormat(legacy) != FormatLegacy {
+		t.Fatalf("Expected a legacy ciphertext to be detected as legacy")
+	}
+
+	locker := NewLocker(&mockClient{info: info, signature: beacon.sign(t, round)})
+
+	// Legacy ciphertexts are still decrypted
+	if decrypted, err := locker.Decrypt(legacy, round); err != nil || !bytes.Equal(plaintext, decrypted) {
+		t.Fatalf("Failed to decrypt legacy ciphertext: %s, %v", decrypted, err)
+	}
+
+	migrated, err := locker.Migrate(legacy, round)
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if DetectFormat(migrated) != FormatAge {
+		t.Errorf("Expected the migrated ciphertext to be an age file")
+	}
+	if parsedRound, _, err := ParseHeader(migrated); err != nil || parsedRound != round {
+		t.Errorf("Expected the migrated ciphertext to be locked to round %d, got %d: %v", round, parsedRound, err)
+	}
+
+	decrypted, err := locker.Decrypt(migrated, round)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("Decrypted text doesn't match original. Got: %s, Want: %s", decrypted, plaintext)
+	}
+
+	// Age files are left unchanged
+	if again, err := locker.Migrate(migrated, round); err != nil || !bytes.Equal(again, migrated) {
+		t.Errorf("Expected an age file to be unchanged by Migrate, got error: %v", err)
+	}
+
+	// Locked legacy ciphertexts cannot be migrated yet
+	future := info.RoundAt(time.Now()) + 100
+	locked, err := sealLegacy(plaintext, info.Scheme, info.PublicKey, future)
+	if err != nil {
+		t.Fatalf("sealLegacy failed: %v", err)
+	}
+	if _, err := locker.Migrate(locked, future); err != ErrTooEarly {
+		t.Errorf("Expected ErrTooEarly, got: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Notes that need approvals are encrypted with a random data key before they are timelocked.
+// The data key is split 2-of-2 into a time share, sealed with the note under the timelock,
+// and an approval key, itself split among the approvers. Opening the note needs both the
+// round signature and the threshold of approver shares.
+
+// dataKeySize is the length of the AES-256 data key of notes that need approvals
+const dataKeySize = 32
+
+// timeShareSize is the length of the time share at the start of a payload of SealApproval
+const timeShareSize = 1 + dataKeySize
+
+// shareKeyContext separates the keys that wrap approver shares from other uses of the secrets
+const shareKeyContext = "drand-note approver share\x00"
+
+// SealApproval encrypts the plaintext with a random data key, and returns the sealed payload,
+// which is meant to be timelocked, and the shares of n approvers, any threshold of which open it
+func SealApproval(plaintext []byte, n, threshold int) (sealed []byte, shares [][]byte, err error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	halves, err := SplitSecret(key, 2, 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	shares, err = SplitSecret(halves[1][1:], n, threshold)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ciphertext, err := sealGCM(key, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(halves[0], ciphertext...), shares, nil
+}
+
+// OpenApproval decrypts a payload of SealApproval with at least the threshold of approver shares
+func OpenApproval(sealed []byte, shares [][]byte) ([]byte, error) {
+	if len(sealed) < timeShareSize {
+		return nil, fmt.Errorf("%w: approval payload too short", ErrInvalidCiphertext)
+	}
+
+	approvalKey, err := CombineShares(shares)
+	if err != nil {
+		return nil, err
+	}
+	key, err := CombineShares([][]byte{sealed[:timeShareSize], append([]byte{2}, approvalKey...)})
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := openGCM(key, sealed[timeShareSize:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: not enough approver shares", ErrInvalidShares)
+	}
+	return plaintext, nil
+}
+
+// WrapShare encrypts an approver share with a key derived from a high-entropy secret of the
+// approver, e.g. their approval token, so that the share can only be released with it
+func WrapShare(share []byte, secret string) ([]byte, error) {
+	key := sha256.Sum256([]byte(shareKeyContext + secret))
+	return sealGCM(key[:], share)
+}
+
+// UnwrapShare decrypts a share of WrapShare. It fails with ErrInvalidShares for other secrets.
+func UnwrapShare(wrapped []byte, secret string) ([]byte, error) {
+	key := sha256.Sum256([]byte(shareKeyContext + secret))
+	share, err := openGCM(key[:], wrapped)
+	if err != nil {
+		return nil, ErrInvalidShares
+	}
+	return share, nil
+}
+
+// sealGCM encrypts with AES-GCM and returns [nonce][ciphertext]
+func sealGCM(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+
+	nonce := make([]byte, aesgcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return aesgcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openGCM decrypts a payload of sealGCM
+func openGCM(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aesgcm.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	return aesgcm.Open(nil, sealed[:aesgcm.NonceSize()], sealed[aesgcm.NonceSize():], nil)
+}
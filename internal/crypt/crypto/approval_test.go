@@ -0,0 +1,65 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	dcrypto "github.com/drand/drand/crypto"
+)
+
+func TestApprovalUnderTimelock(t *testing.T) {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSUnchainedG1())
+	info := beacon.chainInfo(time.Now().Add(-time.Minute), time.Second)
+	plaintext := []byte("This is a secret message")
+	round := uint64(1)
+
+	sealed, shares, err := SealApproval(plaintext, 3, 2)
+	if err != nil {
+		t.Fatalf("SealApproval failed: %v", err)
+	}
+	ciphertext, _, err := Seal(info, sealed, round)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	// The approver shares alone are useless without the time share in the timelock
+	opened, err := openAge(info, ciphertext, round, beacon.sign(t, round))
+	if err != nil {
+		t.Fatalf("openAge failed: %v", err)
+	}
+
+	for _, approved := range [][][]byte{{shares[0], shares[1]}, {shares[2], shares[0]}, shares} {
+		decrypted, err := OpenApproval(opened, approved)
+		if err != nil {
+			t.Fatalf("OpenApproval with %d shares failed: %v", len(approved), err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("Decrypted text doesn't match. Got: %s, Want: %s", decrypted, plaintext)
+		}
+	}
+
+	if _, err := OpenApproval(opened, shares[1:2]); !errors.Is(err, ErrInvalidShares) {
+		t.Errorf("Expected ErrInvalidShares below the threshold, got %v", err)
+	}
+}
+
+func TestWrapShare(t *testing.T) {
+	share := []byte{1, 2, 3, 4}
+	wrapped, err := WrapShare(share, "approval token")
+	if err != nil {
+		t.Fatalf("WrapShare failed: %v", err)
+	}
+	if bytes.Contains(wrapped, share) {
+		t.Error("The wrapped share contains the share")
+	}
+
+	unwrapped, err := UnwrapShare(wrapped, "approval token")
+	if err != nil || !bytes.Equal(unwrapped, share) {
+		t.Errorf("UnwrapShare returned %v, %v", unwrapped, err)
+	}
+	if _, err := UnwrapShare(wrapped, "other token"); !errors.Is(err, ErrInvalidShares) {
+		t.Errorf("Expected ErrInvalidShares for another token, got %v", err)
+	}
+}
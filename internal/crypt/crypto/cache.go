@@ -0,0 +1,122 @@
+package crypto
+
+import (
+	"fmt"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/korjavin/drand-poc/internal/crypt/drand"
+)
+
+// SignatureStore persists verified beacon signatures
+type SignatureStore interface {
+	// LoadSignature returns the stored signature of a round, if any
+	LoadSignature(chainHash string, round uint64) ([]byte, bool, error)
+
+	// SaveSignature stores the signature of a round
+	SaveSignature(chainHash string, round uint64, signature []byte) error
+}
+
+// CachingClient is a Client that caches verified beacon signatures.
+// Signatures of a round never change, so cached entries never expire.
+// Concurrent fetches of the same round are collapsed into a single request.
+// Signatures are verified as unchained beacons, like the Locker does.
+type CachingClient struct {
+	client Client
+	info   *drand.ChainInfo
+	cache  *lru.Cache
+	store  SignatureStore // Optional persistent cache
+
+	mu       sync.Mutex
+	inflight map[uint64]*fetchCall
+}
+
+// fetchCall is an in-flight fetch of a round
+type fetchCall struct {
+	done      chan struct{}
+	signature []byte
+	err       error
+}
+
+// NewCachingClient wraps a client with an in-memory LRU cache of the given size.
+// If store is not nil, verified signatures are also persisted in it.
+func NewCachingClient(client Client, size int, store SignatureStore) (*CachingClient, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create beacon cache: %w", err)
+	}
+
+	return &CachingClient{
+		client:   client,
+		info:     client.Info(),
+		cache:    cache,
+		store:    store,
+		inflight: make(map[uint64]*fetchCall),
+	}, nil
+}
+
+// Info returns the parameters of the chain
+func (c *CachingClient) Info() *drand.ChainInfo {
+	return c.info
+}
+
+// FetchSignature returns the verified signature of a round, from the cache if possible
+func (c *CachingClient) FetchSignature(round uint64) ([]byte, error) {
+	if sig, ok := c.cache.Get(round); ok {
+		return sig.([]byte), nil
+	}
+
+	c.mu.Lock()
+	if call, ok := c.inflight[round]; ok {
+		// Another caller is already fetching this round
+		c.mu.Unlock()
+		<-call.done
+		return call.signature, call.err
+	}
+	call := &fetchCall{done: make(chan struct{})}
+	c.inflight[round] = call
+	c.mu.Unlock()
+
+	call.signature, call.err = c.fetch(round)
+	if call.err == nil {
+		c.cache.Add(round, call.signature)
+	}
+
+	c.mu.Lock()
+	delete(c.inflight, round)
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.signature, call.err
+}
+
+// fetch gets a round from the persistent store or the underlying client
+func (c *CachingClient) fetch(round uint64) ([]byte, error) {
+	if c.store != nil {
+		sig, ok, err := c.store.LoadSignature(c.info.HashString(), round)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load cached signature: %w", err)
+		}
+		// Stored signatures are verified again in case the store was tampered with
+		if ok && c.info.VerifyBeacon(round, sig, nil) == nil {
+			return sig, nil
+		}
+	}
+
+	sig, err := c.client.FetchSignature(round)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only verified signatures are cached
+	if err := c.info.VerifyBeacon(round, sig, nil); err != nil {
+		return nil, err
+	}
+
+	if c.store != nil {
+		// A failure to persist is not fatal: the signature is still cached in memory
+		_ = c.store.SaveSignature(c.info.HashString(), round, sig)
+	}
+
+	return sig, nil
+}
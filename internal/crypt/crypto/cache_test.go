@@ -0,0 +1,176 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dcrypto "github.com/drand/drand/crypto"
+	"github.com/korjavin/drand-poc/internal/crypt/drand"
+)
+
+// countingClient is a Client that signs rounds with a test beacon and counts the fetches
+type countingClient struct {
+	beacon  *testBeacon
+	info    *drand.ChainInfo
+	t       *testing.T
+	fetches atomic.Int32
+	release chan struct{} // If not nil, fetches block until it is closed
+	forge   bool          // Return the signature of the next round
+}
+
+func (c *countingClient) Info() *drand.ChainInfo {
+	return c.info
+}
+
+func (c *countingClient) FetchSignature(round uint64) ([]byte, error) {
+	c.fetches.Add(1)
+	if c.release != nil {
+		<-c.release
+	}
+	if c.forge {
+		return c.beacon.sign(c.t, round+1), nil
+	}
+	return c.beacon.sign(c.t, round), nil
+}
+
+// mapSignatureStore is an in-memory SignatureStore
+type mapSignatureStore struct {
+	mu   sync.Mutex
+	sigs map[string][]byte
+}
+
+func (s *mapSignatureStore) LoadSignature(chainHash string, round uint64) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sig, ok := s.sigs[fmt.Sprintf("%s:%d", chainHash, round)]
+	return sig, ok, nil
+}
+
+func (s *mapSignatureStore) SaveSignature(chainHash string, round uint64, signature []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sigs[fmt.Sprintf("%s:%d", chainHash, round)] = signature
+	return nil
+}
+
+func newCountingClient(t *testing.T) *countingClient {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSUnchainedG1())
+	return &countingClient{
+		beacon: beacon,
+		info:   drand.NewChainInfo(beacon.public, time.Second, time.Now().Unix(), beacon.scheme),
+		t:      t,
+	}
+}
+
+func TestCachingClientCachesSignatures(t *testing.T) {
+	client := newCountingClient(t)
+	cache, err := NewCachingClient(client, 16, nil)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		sig, err := cache.FetchSignature(42)
+		if err != nil {
+			t.Fatalf("FetchSignature failed: %v", err)
+		}
+		if !bytes.Equal(sig, client.beacon.sign(t, 42)) {
+			t.Errorf("Unexpected signature: %x", sig)
+		}
+	}
+
+	if n := client.fetches.Load(); n != 1 {
+		t.Errorf("Expected 1 fetch, got %d", n)
+	}
+}
+
+func TestCachingClientCollapsesConcurrentFetches(t *testing.T) {
+	client := newCountingClient(t)
+	client.release = make(chan struct{})
+	cache, err := NewCachingClient(client, 16, nil)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.FetchSignature(42)
+			errs <- err
+		}()
+	}
+
+	// Let the goroutines pile up on the in-flight fetch
+	time.Sleep(50 * time.Millisecond)
+	close(client.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("FetchSignature failed: %v", err)
+		}
+	}
+
+	if n := client.fetches.Load(); n != 1 {
+		t.Errorf("Expected 1 fetch, got %d", n)
+	}
+}
+
+func TestCachingClientPersistsSignatures(t *testing.T) {
+	client := newCountingClient(t)
+	store := &mapSignatureStore{sigs: make(map[string][]byte)}
+
+	cache, err := NewCachingClient(client, 16, store)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	if _, err := cache.FetchSignature(42); err != nil {
+		t.Fatalf("FetchSignature failed: %v", err)
+	}
+
+	// A new cache, e.g. after a restart, serves the round from the store
+	cache, err = NewCachingClient(client, 16, store)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	if _, err := cache.FetchSignature(42); err != nil {
+		t.Fatalf("FetchSignature failed: %v", err)
+	}
+
+	if n := client.fetches.Load(); n != 1 {
+		t.Errorf("Expected 1 fetch, got %d", n)
+	}
+}
+
+func TestCachingClientDoesNotCacheInvalidBeacons(t *testing.T) {
+	client := newCountingClient(t)
+	client.forge = true
+	store := &mapSignatureStore{sigs: make(map[string][]byte)}
+
+	cache, err := NewCachingClient(client, 16, store)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.FetchSignature(42); !errors.Is(err, drand.ErrInvalidBeacon) {
+			t.Fatalf("Expected ErrInvalidBeacon, got: %v", err)
+		}
+	}
+
+	if n := client.fetches.Load(); n != 2 {
+		t.Errorf("Expected 2 fetches, got %d", n)
+	}
+	if len(store.sigs) != 0 {
+		t.Errorf("Expected no persisted signatures, got %d", len(store.sigs))
+	}
+}
@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/drand/drand/chain"
+	dcrypto "github.com/drand/drand/crypto"
+	"github.com/drand/kyber"
+	bls "github.com/drand/kyber-bls12381"
+	"github.com/drand/kyber/encrypt/ibe"
+	"github.com/drand/kyber/pairing"
+)
+
+// ErrUnsupportedScheme is returned for drand schemes that cannot be used for timelock encryption
+var ErrUnsupportedScheme = errors.New("drand scheme does not support timelock encryption")
+
+// Hash-to-curve domain separation tags used by the drand schemes
+var (
+	dstG1 = []byte("BLS_SIG_BLS12381G1_XMD:SHA-256_SSWU_RO_NUL_")
+	dstG2 = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_NUL_")
+)
+
+// pairingSuite returns the pairing suite matching the hash-to-curve setup of the scheme.
+// Only unchained schemes can be used: chained beacons sign the previous signature,
+// so the message for a future round is not known in advance.
+func pairingSuite(scheme *dcrypto.Scheme) (pairing.Suite, error) {
+	switch scheme.Name {
+	case dcrypto.UnchainedSchemeID, dcrypto.SigsOnG1ID:
+		return bls.NewBLS12381SuiteWithDST(dstG1, dstG2), nil
+	case dcrypto.ShortSigSchemeID:
+		return bls.NewBLS12381SuiteWithDST(dstG2, dstG2), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedScheme, scheme.Name)
+	}
+}
+
+// roundIdentity returns the message signed by the network for the given round,
+// which is used as the IBE identity
+func roundIdentity(scheme *dcrypto.Scheme, round uint64) []byte {
+	return scheme.DigestBeacon(&chain.Beacon{Round: round})
+}
+
+// wrapKey encrypts a symmetric key towards the given round using Boneh-Franklin IBE,
+// with the chain public key as the master key.
+// Format: [U (point on the key group)][V][W], where V and W are as long as the key.
+// This is the stanza body written by the tle tool.
+func wrapKey(scheme *dcrypto.Scheme, publicKey kyber.Point, round uint64, key []byte) ([]byte, error) {
+	suite, err := pairingSuite(scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	if publicKey.Equal(publicKey.Clone().Null()) {
+		return nil, errors.New("invalid public key")
+	}
+
+	id := roundIdentity(scheme, round)
+
+	var ct *ibe.Ciphertext
+	if scheme.KeyGroup.String() == suite.G1().String() {
+		// Public key on G1, signatures on G2
+		ct, err = ibe.EncryptCCAonG1(suite, publicKey, id, key)
+	} else {
+		// Public key on G2, signatures on G1
+		ct, err = ibe.EncryptCCAonG2(suite, publicKey, id, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt key: %w", err)
+	}
+
+	u, err := ct.U.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ciphertext point: %w", err)
+	}
+
+	wrapped := make([]byte, 0, len(u)+len(ct.V)+len(ct.W))
+	wrapped = append(wrapped, u...)
+	wrapped = append(wrapped, ct.V...)
+	wrapped = append(wrapped, ct.W...)
+	return wrapped, nil
+}
+
+// wrappedKeySize returns the length of a key of keySize bytes wrapped with wrapKey for the scheme
+func wrappedKeySize(scheme *dcrypto.Scheme, keySize int) int {
+	return scheme.KeyGroup.PointLen() + 2*keySize
+}
+
+// unwrapKey decrypts a key wrapped with wrapKey using the round signature,
+// which is the IBE private key for the round identity
+func unwrapKey(scheme *dcrypto.Scheme, signature []byte, wrapped []byte) ([]byte, error) {
+	suite, err := pairingSuite(scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	pointLen := scheme.KeyGroup.PointLen()
+	keySize := (len(wrapped) - pointLen) / 2
+	if keySize <= 0 || len(wrapped) != wrappedKeySize(scheme, keySize) {
+		return nil, fmt.Errorf("invalid wrapped key length: %d", len(wrapped))
+	}
+
+	u := scheme.KeyGroup.Point()
+	if err := u.UnmarshalBinary(wrapped[:pointLen]); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ciphertext point: %w", err)
+	}
+
+	sig := scheme.SigGroup.Point()
+	if err := sig.UnmarshalBinary(signature); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal signature: %w", err)
+	}
+
+	ct := &ibe.Ciphertext{
+		U: u,
+		V: wrapped[pointLen : pointLen+keySize],
+		W: wrapped[pointLen+keySize:],
+	}
+
+	var key []byte
+	if scheme.KeyGroup.String() == suite.G1().String() {
+		key, err = ibe.DecryptCCAonG1(suite, sig, ct)
+	} else {
+		key, err = ibe.DecryptCCAonG2(suite, sig, ct)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key: %w", err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,106 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	dcrypto "github.com/drand/drand/crypto"
+	"github.com/drand/kyber"
+)
+
+// Legacy ciphertexts were written before the age format:
+// [wrapped AES-256 key][nonce (12 bytes)][AES-GCM ciphertext]
+// They are still decrypted, and can be converted with Locker.Migrate.
+const (
+	legacyKeySize   = 32
+	legacyNonceSize = 12
+)
+
+// sealLegacy encrypts the plaintext with a random AES-256 key and wraps that key
+// towards the given round, so it can only be recovered with the round signature
+func sealLegacy(plaintext []byte, scheme *dcrypto.Scheme, publicKey kyber.Point, round uint64) ([]byte, error) {
+	// Generate a random key for AES encryption
+	key := make([]byte, legacyKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate random key: %w", err)
+	}
+
+	// Wrap the key with identity-based encryption towards the round
+	wrappedKey, err := wrapKey(scheme, publicKey, round, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Encrypt the plaintext with the random key
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	// Generate a random nonce
+	nonce := make([]byte, legacyNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	// Create a GCM cipher mode
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	// Encrypt the plaintext
+	cipherData := aesgcm.Seal(nil, nonce, plaintext, nil)
+
+	// Combine the wrapped key, nonce, and ciphertext into a single byte slice
+	// Format: [wrapped key][nonce (12 bytes)][ciphertext]
+	combined := make([]byte, len(wrappedKey)+len(nonce)+len(cipherData))
+	copy(combined, wrappedKey)
+	copy(combined[len(wrappedKey):], nonce)
+	copy(combined[len(wrappedKey)+len(nonce):], cipherData)
+
+	return combined, nil
+}
+
+// openLegacy decrypts a ciphertext produced by sealLegacy using the round signature
+func openLegacy(ciphertext []byte, scheme *dcrypto.Scheme, signature []byte) ([]byte, error) {
+	// Extract the wrapped key, nonce, and encrypted data from the ciphertext
+	keySize := wrappedKeySize(scheme, legacyKeySize)
+	if len(ciphertext) < keySize+legacyNonceSize { // wrapped key + nonce minimum
+		return nil, fmt.Errorf("%w: too short", ErrInvalidCiphertext)
+	}
+
+	wrappedKey := ciphertext[:keySize]
+	nonce := ciphertext[keySize : keySize+legacyNonceSize]
+	encryptedData := ciphertext[keySize+legacyNonceSize:]
+
+	// Unwrap the key with the round signature
+	// This ensures that the key can only be derived after the round has been signed
+	key, err := unwrapKey(scheme, signature, wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a new AES cipher using the key
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	// Create a GCM cipher mode
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	// Decrypt the data
+	plaintext, err := aesgcm.Open(nil, nonce, encryptedData, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	return plaintext, nil
+}
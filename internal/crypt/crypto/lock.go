@@ -1,159 +1,181 @@
 package crypto
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
 	"crypto/sha256"
 	"errors"
 	"fmt"
-	"io"
 	"time"
 
+	"filippo.io/age"
 	"github.com/korjavin/drand-poc/internal/crypt/drand"
 )
 
 // ErrTooEarly is returned when trying to decrypt a message before its unlock time
 var ErrTooEarly = errors.New("too early to decrypt")
 
+// ErrBeaconUnavailable is returned when the signature of a produced round can't be fetched,
+// e.g. when the drand endpoints can't be reached or haven't published it yet: a later attempt may succeed
+var ErrBeaconUnavailable = errors.New("beacon unavailable")
+
+// ErrInvalidCiphertext is returned for ciphertexts that are not well-formed for the chain
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
+// ErrLegacyFormat is returned when a client submits a ciphertext in the legacy format,
+// and by the streaming functions, which only support the age format
+var ErrLegacyFormat = errors.New("legacy ciphertext format is no longer accepted")
+
 // Client is the drand client interface
 type Client interface {
-	FetchRandomness(round uint64) ([]byte, error)
+	// Info returns the parameters of the chain
+	Info() *drand.ChainInfo
+
+	// FetchSignature returns the beacon signature for the given round
+	FetchSignature(round uint64) ([]byte, error)
 }
 
-// DefaultClient is the default drand client
-var DefaultClient Client
+// Locker encrypts and decrypts messages timelocked to the rounds of a drand chain
+type Locker struct {
+	client Client
+	info   *drand.ChainInfo
+	now    func() time.Time
+}
 
-// Initialize the default client
-func init() {
-	var err error
-	client, err := drand.NewClient()
-	if err != nil {
-		// In a real application, we might want to handle this error differently
-		panic(fmt.Sprintf("failed to initialize drand client: %v", err))
+// LockerOption configures a Locker
+type LockerOption func(*Locker)
+
+// WithClock sets the function the Locker uses to get the current time
+func WithClock(now func() time.Time) LockerOption {
+	return func(l *Locker) {
+		l.now = now
 	}
-	DefaultClient = client
 }
 
-// Encrypt encrypts the plaintext so it can only be decrypted after the specified time
-func Encrypt(plaintext []byte, unlockAt time.Time) (ciphertext []byte, hash []byte, round uint64, err error) {
-	// Calculate the round number for the unlock time
-	// The League of Entropy's drand network produces a new random value every 30 seconds
-	// We need to calculate which round will be available at the unlock time
+// NewLocker creates a new Locker using the given drand client
+func NewLocker(client Client, opts ...LockerOption) *Locker {
+	l := &Locker{
+		client: client,
+		info:   client.Info(),
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
 
-	// Current time in seconds since epoch
-	now := time.Now().UTC().Unix()
-	// Unlock time in seconds since epoch
-	unlockTime := unlockAt.UTC().Unix()
+// Info returns the parameters of the chain used by the Locker
+func (l *Locker) Info() *drand.ChainInfo {
+	return l.info
+}
 
-	// The genesis time of the drand network (July 1, 2020)
-	genesisTime := int64(1595431050)
-	// The period between rounds in seconds
-	period := int64(30)
+// Now returns the current time of the Locker clock
+func (l *Locker) Now() time.Time {
+	return l.now()
+}
 
-	// Calculate the current round
-	currentRound := uint64((now - genesisTime) / period)
-	// Calculate the unlock round
-	round = uint64((unlockTime - genesisTime) / period)
+// Encrypt encrypts the plaintext so it can only be decrypted after the specified time,
+// except by the recipients, who can decrypt it at any time with their age identity
+func (l *Locker) Encrypt(plaintext []byte, unlockAt time.Time, recipients ...age.Recipient) (ciphertext []byte, hash []byte, round uint64, err error) {
+	// Calculate the first round produced at or after the unlock time
+	round = l.info.RoundFor(unlockAt)
 
 	// Ensure the unlock round is in the future
-	if round <= currentRound {
+	if round <= l.info.RoundAt(l.now()) {
 		return nil, nil, 0, fmt.Errorf("unlock time must be in the future")
 	}
 
-	// Generate a random key for AES encryption
-	key := make([]byte, 32) // AES-256 requires a 32-byte key
-	if _, err := io.ReadFull(rand.Reader, key); err != nil {
-		return nil, nil, 0, fmt.Errorf("failed to generate random key: %w", err)
-	}
-
-	// Encrypt the plaintext with the random key
-	block, err := aes.NewCipher(key)
+	ciphertext, hash, err = Seal(l.info, plaintext, round, recipients...)
 	if err != nil {
-		return nil, nil, 0, fmt.Errorf("failed to create AES cipher: %w", err)
+		return nil, nil, 0, err
 	}
 
-	// Generate a random nonce
-	nonce := make([]byte, 12) // GCM mode typically uses a 12-byte nonce
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, nil, 0, fmt.Errorf("failed to generate nonce: %w", err)
-	}
+	return ciphertext, hash, round, nil
+}
 
-	// Create a GCM cipher mode
-	aesgcm, err := cipher.NewGCM(block)
+// Seal encrypts the plaintext towards a round of the chain and returns the ciphertext with its hash.
+// The ciphertext is a binary age file with a tlock stanza, which the tle tool can decrypt.
+// It needs no drand client, so clients can encrypt locally and upload only the ciphertext.
+// The recipients can decrypt it at any time with their age identity.
+func Seal(info *drand.ChainInfo, plaintext []byte, round uint64, recipients ...age.Recipient) (ciphertext []byte, hash []byte, err error) {
+	ciphertext, err = sealAge(info, plaintext, round, recipients...)
 	if err != nil {
-		return nil, nil, 0, fmt.Errorf("failed to create GCM: %w", err)
+		return nil, nil, err
 	}
 
-	// Encrypt the plaintext
-	cipherData := aesgcm.Seal(nil, nonce, plaintext, nil)
-
-	// Combine the key, nonce, and ciphertext into a single byte slice
-	// Format: [key (32 bytes)][nonce (12 bytes)][ciphertext]
-	combined := make([]byte, len(key)+len(nonce)+len(cipherData))
-	copy(combined, key)
-	copy(combined[len(key):], nonce)
-	copy(combined[len(key)+len(nonce):], cipherData)
-
 	// Calculate the SHA-256 hash of the combined data
-	h := sha256.Sum256(combined)
+	h := sha256.Sum256(ciphertext)
 
-	return combined, h[:], round, nil
+	return ciphertext, h[:], nil
 }
 
-// Decrypt decrypts the ciphertext if the current time is after the unlock time
-func Decrypt(ciphertext []byte, round uint64) ([]byte, error) {
-	// Check if the current time is after the unlock time
-	now := time.Now().UTC()
-	genesisTime := time.Unix(1595431050, 0).UTC()
-	period := 30 * time.Second
+// Validate checks that a binary age file encrypted by a client is well-formed for the chain
+// and locked to a future round, and returns its hash. It cannot check that the file key
+// was actually encrypted towards that round: this is only known once the round is produced.
+func (l *Locker) Validate(ciphertext []byte, round uint64) (hash []byte, err error) {
+	if round <= l.info.RoundAt(l.now()) {
+		return nil, fmt.Errorf("unlock round must be in the future")
+	}
 
-	// Calculate the unlock time based on the round
-	unlockTime := genesisTime.Add(time.Duration(round) * period)
+	if DetectFormat(ciphertext) != FormatAge {
+		return nil, ErrLegacyFormat
+	}
 
-	if now.Before(unlockTime) {
-		return nil, ErrTooEarly
+	if err := validateAge(l.info, ciphertext, round); err != nil {
+		return nil, err
 	}
 
-	// Fetch the randomness for the specified round
-	randomness, err := DefaultClient.FetchRandomness(round)
+	h := sha256.Sum256(ciphertext)
+	return h[:], nil
+}
+
+// Decrypt decrypts the ciphertext if the current time is after the unlock time.
+// Both age files, binary or armored, and legacy ciphertexts are supported.
+func (l *Locker) Decrypt(ciphertext []byte, round uint64) ([]byte, error) {
+	signature, err := l.signature(round)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch randomness: %w", err)
+		return nil, err
 	}
 
-	// Extract the key, nonce, and encrypted data from the ciphertext
-	if len(ciphertext) < 44 { // 32 (key) + 12 (nonce) bytes minimum
-		return nil, fmt.Errorf("invalid ciphertext: too short")
+	if DetectFormat(ciphertext) == FormatLegacy {
+		return openLegacy(ciphertext, l.info.Scheme, signature)
 	}
+	return openAge(l.info, ciphertext, round, signature)
+}
 
-	key := ciphertext[:32]
-	nonce := ciphertext[32:44]
-	encryptedData := ciphertext[44:]
-
-	// XOR the key with the randomness to get the actual decryption key
-	// This ensures that the key can only be derived after the randomness is available
-	actualKey := make([]byte, 32)
-	for i := 0; i < 32; i++ {
-		actualKey[i] = key[i] ^ randomness[i%len(randomness)]
+// signature returns the verified signature of a round, ErrTooEarly if it was not produced yet,
+// or ErrBeaconUnavailable if it can't be fetched
+func (l *Locker) signature(round uint64) ([]byte, error) {
+	// Check if the round has been produced yet
+	if l.now().Before(l.info.TimeOfRound(round)) {
+		return nil, ErrTooEarly
 	}
 
-	// Create a new AES cipher using the actual key
-	block, err := aes.NewCipher(actualKey)
+	// Fetch the signature for the specified round
+	signature, err := l.client.FetchSignature(round)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrBeaconUnavailable, err)
 	}
 
-	// Create a GCM cipher mode
-	aesgcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	// Verify the signature, so that a misbehaving client cannot make us decrypt garbage
+	if err := l.info.VerifyBeacon(round, signature, nil); err != nil {
+		return nil, err
+	}
+
+	return signature, nil
+}
+
+// Migrate converts a legacy ciphertext to the age format, locked to the same round.
+// The round must have been produced, as the legacy ciphertext has to be decrypted first.
+// Ciphertexts in the age format are returned unchanged.
+func (l *Locker) Migrate(ciphertext []byte, round uint64) ([]byte, error) {
+	if DetectFormat(ciphertext) != FormatLegacy {
+		return ciphertext, nil
 	}
 
-	// Decrypt the data
-	plaintext, err := aesgcm.Open(nil, nonce, encryptedData, nil)
+	plaintext, err := l.Decrypt(ciphertext, round)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt: %w", err)
+		return nil, err
 	}
 
-	return plaintext, nil
+	return sealAge(l.info, plaintext, round)
 }
@@ -5,18 +5,59 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"errors"
 	"io"
 	"testing"
 	"time"
+
+	"github.com/drand/drand/chain"
+	dcrypto "github.com/drand/drand/crypto"
+	"github.com/drand/kyber"
+	"github.com/drand/kyber/util/random"
+	"github.com/korjavin/drand-poc/internal/crypt/drand"
 )
 
 // mockClient is a mock implementation of the Client interface for testing
 type mockClient struct {
-	randomness []byte
+	info      *drand.ChainInfo
+	signature []byte
+}
+
+func (m *mockClient) Info() *drand.ChainInfo {
+	return m.info
+}
+
+func (m *mockClient) FetchSignature(round uint64) ([]byte, error) {
+	return m.signature, nil
+}
+
+// testBeacon is a locally generated beacon keypair for testing
+type testBeacon struct {
+	scheme  *dcrypto.Scheme
+	private kyber.Scalar
+	public  kyber.Point
 }
 
-func (m *mockClient) FetchRandomness(round uint64) ([]byte, error) {
-	return m.randomness, nil
+func newTestBeacon(t *testing.T, scheme *dcrypto.Scheme) *testBeacon {
+	t.Helper()
+	private := scheme.KeyGroup.Scalar().Pick(random.New())
+	public := scheme.KeyGroup.Point().Mul(private, nil)
+	return &testBeacon{scheme: scheme, private: private, public: public}
+}
+
+// chainInfo returns the chain info of a local chain run by the beacon
+func (b *testBeacon) chainInfo(genesis time.Time, period time.Duration) *drand.ChainInfo {
+	return drand.NewChainInfo(b.public, period, genesis.Unix(), b.scheme)
+}
+
+// sign returns the beacon signature for the given round
+func (b *testBeacon) sign(t *testing.T, round uint64) []byte {
+	t.Helper()
+	sig, err := b.scheme.AuthScheme.Sign(b.private, b.scheme.DigestBeacon(&chain.Beacon{Round: round}))
+	if err != nil {
+		t.Fatalf("Failed to sign round: %v", err)
+	}
+	return sig
 }
 
 func TestSimpleEncryptDecrypt(t *testing.T) {
@@ -63,23 +104,16 @@ func TestSimpleEncryptDecrypt(t *testing.T) {
 }
 
 func TestEncryptWithDrand(t *testing.T) {
-	// Save the original client and restore it after the test
-	originalClient := DefaultClient
-	defer func() { DefaultClient = originalClient }()
-
-	// Create a mock client with fixed randomness
-	mockRandomness := make([]byte, 32)
-	for i := range mockRandomness {
-		mockRandomness[i] = byte(i)
-	}
-	DefaultClient = &mockClient{randomness: mockRandomness}
+	// Create a mock client with a fixed signature
+	info := drand.Quicknet()
+	locker := NewLocker(&mockClient{info: info, signature: make([]byte, 48)})
 
 	// Test data
 	plaintext := []byte("This is a secret message")
 
 	// Encrypt with a future time
 	unlockAt := time.Now().UTC().Add(10 * time.Minute)
-	_, hash, round, err := Encrypt(plaintext, unlockAt)
+	_, hash, round, err := locker.Encrypt(plaintext, unlockAt)
 	if err != nil {
 		t.Fatalf("Encrypt failed: %v", err)
 	}
@@ -90,41 +124,251 @@ func TestEncryptWithDrand(t *testing.T) {
 	}
 
 	// Verify the round is in the future
-	currentTime := time.Now().UTC()
-	genesisTime := time.Unix(1595431050, 0).UTC()
-	period := 30 * time.Second
-	currentRound := uint64(currentTime.Sub(genesisTime) / period)
-
+	currentRound := info.RoundAt(time.Now())
 	if round <= currentRound {
 		t.Errorf("Expected round to be in the future. Got: %d, Current: %d", round, currentRound)
 	}
+
+	// Verify the round is not produced before the unlock time
+	if info.TimeOfRound(round).Before(unlockAt.Truncate(time.Second)) {
+		t.Errorf("Expected round %d to be produced after %v, got %v", round, unlockAt, info.TimeOfRound(round))
+	}
 }
 
 func TestDecryptTooEarly(t *testing.T) {
-	// Save the original client and restore it after the test
-	originalClient := DefaultClient
-	defer func() { DefaultClient = originalClient }()
-
-	// Create a mock client with fixed randomness
-	mockRandomness := make([]byte, 32)
-	for i := range mockRandomness {
-		mockRandomness[i] = byte(i)
-	}
-	DefaultClient = &mockClient{randomness: mockRandomness}
+	// Create a mock client with a fixed signature
+	locker := NewLocker(&mockClient{info: drand.Quicknet(), signature: make([]byte, 48)})
 
 	// Test data
 	plaintext := []byte("This is a secret message")
 
 	// Encrypt with a future time
 	unlockAt := time.Now().UTC().Add(10 * time.Minute) // Far in the future
-	cipher, _, round, err := Encrypt(plaintext, unlockAt)
+	cipher, _, round, err := locker.Encrypt(plaintext, unlockAt)
 	if err != nil {
 		t.Fatalf("Encrypt failed: %v", err)
 	}
 
 	// Try to decrypt before the unlock time
-	_, err = Decrypt(cipher, round)
+	_, err = locker.Decrypt(cipher, round)
 	if err != ErrTooEarly {
 		t.Errorf("Expected ErrTooEarly, got: %v", err)
 	}
 }
+
+func TestSealOpenWithLocalBeacon(t *testing.T) {
+	schemes := []*dcrypto.Scheme{
+		dcrypto.NewPedersenBLSUnchainedG1(),
+		dcrypto.NewPedersenBLSUnchained(),
+		dcrypto.NewPedersenBLSUnchainedSwapped(),
+	}
+
+	for _, scheme := range schemes {
+		beacon := newTestBeacon(t, scheme)
+		info := beacon.chainInfo(time.Now(), time.Second)
+		plaintext := []byte("This is a secret message")
+		round := uint64(1000)
+
+		formats := map[string]struct {
+			seal func() ([]byte, error)
+			open func(ciphertext, signature []byte) ([]byte, error)
+		}{
+			"age": {
+				seal: func() ([]byte, error) { return sealAge(info, plaintext, round) },
+				open: func(ciphertext, signature []byte) ([]byte, error) {
+					return openAge(info, ciphertext, round, signature)
+				},
+			},
+			"legacy": {
+				seal: func() ([]byte, error) { return sealLegacy(plaintext, scheme, beacon.public, round) },
+				open: func(ciphertext, signature []byte) ([]byte, error) {
+					return openLegacy(ciphertext, scheme, signature)
+				},
+			},
+		}
+
+		for name, format := range formats {
+			t.Run(scheme.Name+"/"+name, func(t *testing.T) {
+				ciphertext, err := format.seal()
+				if err != nil {
+					t.Fatalf("seal failed: %v", err)
+				}
+
+				// The ciphertext must not be decryptable with another round's signature
+				if _, err := format.open(ciphertext, beacon.sign(t, round+1)); err == nil {
+					t.Errorf("Expected open to fail with the signature of another round")
+				}
+
+				decrypted, err := format.open(ciphertext, beacon.sign(t, round))
+				if err != nil {
+					t.Fatalf("open failed: %v", err)
+				}
+
+				if !bytes.Equal(plaintext, decrypted) {
+					t.Errorf("Decrypted text doesn't match original. Got: %s, Want: %s", decrypted, plaintext)
+				}
+			})
+		}
+	}
+}
+
+func TestSealRejectsChainedScheme(t *testing.T) {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSChained())
+	info := beacon.chainInfo(time.Now(), time.Second)
+
+	_, _, err := Seal(info, []byte("secret"), 1000)
+	if !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("Expected ErrUnsupportedScheme, got: %v", err)
+	}
+}
+
+func TestDecryptWithBeacon(t *testing.T) {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSUnchainedG1())
+	info := beacon.chainInfo(time.Now().Add(-time.Minute), time.Second)
+	plaintext := []byte("This is a secret message")
+
+	// Seal towards a round that has already been produced
+	round := uint64(1)
+	ciphertext, _, err := Seal(info, plaintext, round)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	locker := NewLocker(&mockClient{info: info, signature: beacon.sign(t, round)})
+
+	decrypted, err := locker.Decrypt(ciphertext, round)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("Decrypted text doesn't match original. Got: %s, Want: %s", decrypted, plaintext)
+	}
+}
+
+func TestEncryptDecryptWithLocalChain(t *testing.T) {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSUnchainedG1())
+	info := beacon.chainInfo(time.Now(), time.Second)
+	plaintext := []byte("This is a secret message")
+
+	client := &mockClient{info: info}
+	locker := NewLocker(client)
+
+	ciphertext, _, round, err := locker.Encrypt(plaintext, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	client.signature = beacon.sign(t, round)
+
+	if _, err := locker.Decrypt(ciphertext, round); err != ErrTooEarly {
+		t.Fatalf("Expected ErrTooEarly, got: %v", err)
+	}
+
+	// The same chain viewed two hours later: the round has been produced
+	later := NewLocker(client, WithClock(func() time.Time { return time.Now().Add(2 * time.Hour) }))
+	decrypted, err := later.Decrypt(ciphertext, round)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("Decrypted text doesn't match original. Got: %s, Want: %s", decrypted, plaintext)
+	}
+}
+
+func TestDecryptRejectsInvalidBeacon(t *testing.T) {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSUnchainedG1())
+	info := beacon.chainInfo(time.Now().Add(-time.Minute), time.Second)
+
+	round := uint64(1)
+	ciphertext, _, err := Seal(info, []byte("This is a secret message"), round)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	// The client returns the signature of another round
+	locker := NewLocker(&mockClient{info: info, signature: beacon.sign(t, round+1)})
+
+	if _, err := locker.Decrypt(ciphertext, round); !errors.Is(err, drand.ErrInvalidBeacon) {
+		t.Errorf("Expected ErrInvalidBeacon, got: %v", err)
+	}
+}
+
+func TestSealThenDecrypt(t *testing.T) {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSUnchainedG1())
+	info := beacon.chainInfo(time.Now().Add(-time.Minute), time.Second)
+	plaintext := []byte("This is a secret message")
+
+	// A client seals the note locally, knowing only the chain info
+	round := info.RoundAt(time.Now()) + 10
+	ciphertext, hash, err := Seal(info, plaintext, round)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	locker := NewLocker(&mockClient{info: info})
+	validated, err := locker.Validate(ciphertext, round)
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if !bytes.Equal(hash, validated) {
+		t.Errorf("Unexpected hash. Got: %x, Want: %x", validated, hash)
+	}
+
+	// Once the round is produced, the server decrypts it like its own notes
+	later := NewLocker(&mockClient{info: info, signature: beacon.sign(t, round)}, WithClock(func() time.Time {
+		return info.TimeOfRound(round)
+	}))
+	decrypted, err := later.Decrypt(ciphertext, round)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("Decrypted text doesn't match original. Got: %s, Want: %s", decrypted, plaintext)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSUnchainedG1())
+	info := beacon.chainInfo(time.Now().Add(-time.Minute), time.Second)
+	locker := NewLocker(&mockClient{info: info})
+
+	round := info.RoundAt(time.Now()) + 10
+	ciphertext, _, err := Seal(info, []byte("This is a secret message"), round)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	// A round that has already been produced would make the note readable right away
+	if _, err := locker.Validate(ciphertext, info.RoundAt(time.Now())); err == nil {
+		t.Errorf("Expected an error for a past round")
+	}
+
+	// The stanza must match the chain and the round
+	other := newTestBeacon(t, info.Scheme).chainInfo(time.Unix(info.GenesisTime, 0), info.Period)
+	otherChain, _, err := Seal(other, []byte("This is a secret message"), round)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if _, err := locker.Validate(otherChain, round); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("Expected ErrInvalidCiphertext for another chain, got: %v", err)
+	}
+	if _, err := locker.Validate(ciphertext, round+1); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("Expected ErrInvalidCiphertext for another round, got: %v", err)
+	}
+
+	// A truncated header
+	if _, err := locker.Validate(ciphertext[:len(ageHeader)+10], round); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("Expected ErrInvalidCiphertext for a truncated header, got: %v", err)
+	}
+
+	// New notes cannot use the legacy format
+	legacy, err := sealLegacy([]byte("This is a secret message"), info.Scheme, info.PublicKey, round)
+	if err != nil {
+		t.Fatalf("sealLegacy failed: %v", err)
+	}
+	if _, err := locker.Validate(legacy, round); !errors.Is(err, ErrLegacyFormat) {
+		t.Errorf("Expected ErrLegacyFormat, got: %v", err)
+	}
+}
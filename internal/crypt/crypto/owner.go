@@ -0,0 +1,31 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// A timelock can't be shortened or extended: moving the unlock time of a note means encrypting its
+// payload again to another round, before the current one is reached. Notes that allow it keep an
+// owner copy of their payload, encrypted with a secret that only their owner holds, so the payload
+// can be timelocked again when the owner presents the secret.
+
+// ownerKeyContext separates the keys of owner copies from the keys of approver shares
+const ownerKeyContext = "drand-note owner copy\x00"
+
+// SealOwner encrypts the payload of a note with a key derived from a high-entropy secret of its
+// owner, e.g. their delete token
+func SealOwner(payload []byte, secret string) ([]byte, error) {
+	key := sha256.Sum256([]byte(ownerKeyContext + secret))
+	return sealGCM(key[:], payload)
+}
+
+// OpenOwner decrypts an owner copy of SealOwner. It fails with ErrInvalidCiphertext for other secrets.
+func OpenOwner(sealed []byte, secret string) ([]byte, error) {
+	key := sha256.Sum256([]byte(ownerKeyContext + secret))
+	payload, err := openGCM(key[:], sealed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: wrong owner secret", ErrInvalidCiphertext)
+	}
+	return payload, nil
+}
@@ -0,0 +1,31 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSealOwner(t *testing.T) {
+	payload := []byte("timelocked payload")
+	sealed, err := SealOwner(payload, "delete token")
+	if err != nil {
+		t.Fatalf("SealOwner failed: %v", err)
+	}
+	if bytes.Contains(sealed, payload) {
+		t.Error("The owner copy contains the payload")
+	}
+
+	opened, err := OpenOwner(sealed, "delete token")
+	if err != nil || !bytes.Equal(opened, payload) {
+		t.Errorf("OpenOwner returned %q, %v", opened, err)
+	}
+	if _, err := OpenOwner(sealed, "other token"); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("Expected ErrInvalidCiphertext for another token, got %v", err)
+	}
+
+	// An owner copy is not an approver share of the same secret
+	if _, err := UnwrapShare(sealed, "delete token"); err == nil {
+		t.Error("Expected UnwrapShare to fail for an owner copy")
+	}
+}
@@ -0,0 +1,67 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"filippo.io/age"
+)
+
+// ErrWrongPassphrase is returned when a passphrase doesn't decrypt a payload
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+// passphraseWorkFactor is the log2 of the scrypt cost parameter of passphrase-protected
+// payloads. age defaults to 18, which takes 256 MiB and a second per attempt: too much
+// for a server deriving keys for its clients. 15 takes 32 MiB and about 100ms.
+const passphraseWorkFactor = 15
+
+// SealPassphrase encrypts the plaintext into a binary age file with a key derived from
+// the passphrase with scrypt. The result is meant to be timelocked in turn.
+func SealPassphrase(plaintext []byte, passphrase string) ([]byte, error) {
+	r, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	r.SetWorkFactor(passphraseWorkFactor)
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// OpenPassphrase decrypts a payload of SealPassphrase. It returns ErrWrongPassphrase
+// if the passphrase doesn't match.
+func OpenPassphrase(ciphertext []byte, passphrase string) ([]byte, error) {
+	id, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	// Payloads are only written by SealPassphrase, so higher costs are never legitimate
+	id.SetMaxWorkFactor(passphraseWorkFactor)
+
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), id)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, ErrWrongPassphrase
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,53 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	dcrypto "github.com/drand/drand/crypto"
+)
+
+func TestPassphraseUnderTimelock(t *testing.T) {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSUnchainedG1())
+	info := beacon.chainInfo(time.Now().Add(-time.Minute), time.Second)
+	plaintext := []byte("This is a secret message")
+	round := uint64(1)
+
+	inner, err := SealPassphrase(plaintext, "correct horse")
+	if err != nil {
+		t.Fatalf("SealPassphrase failed: %v", err)
+	}
+	ciphertext, _, err := Seal(info, inner, round)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	opened, err := openAge(info, ciphertext, round, beacon.sign(t, round))
+	if err != nil {
+		t.Fatalf("openAge failed: %v", err)
+	}
+	if !bytes.Equal(opened, inner) {
+		t.Fatal("The timelock layer doesn't hold the passphrase layer")
+	}
+
+	if _, err := OpenPassphrase(opened, "battery staple"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+	if _, err := OpenPassphrase(opened, ""); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase for an empty passphrase, got %v", err)
+	}
+
+	decrypted, err := OpenPassphrase(opened, "correct horse")
+	if err != nil {
+		t.Fatalf("OpenPassphrase failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Decrypted text doesn't match. Got: %s, Want: %s", decrypted, plaintext)
+	}
+
+	if _, err := OpenPassphrase(plaintext, "correct horse"); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("Expected ErrInvalidCiphertext for a payload without passphrase, got %v", err)
+	}
+}
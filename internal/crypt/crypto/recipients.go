@@ -0,0 +1,112 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// Notes can also be encrypted to age recipients: the age file key is then wrapped both in the
+// tlock stanza and in an X25519 stanza per recipient, so each recipient can decrypt the file with
+// their age identity at any time, e.g. with `age -d -i key.txt`, while anyone else waits for the round.
+
+// Recipient is an age recipient, e.g. returned by ParseRecipient
+type Recipient = age.Recipient
+
+// x25519KeySize is the length of a raw X25519 public key
+const x25519KeySize = 32
+
+// ParseRecipient parses the public key of a recipient: an age recipient ("age1..."),
+// or a raw X25519 public key encoded in base64
+func ParseRecipient(key string) (Recipient, error) {
+	key = strings.TrimSpace(key)
+	if !strings.HasPrefix(key, "age1") {
+		raw, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(raw) != x25519KeySize {
+			return nil, fmt.Errorf("invalid recipient %q: not an age recipient or a base64 X25519 key", key)
+		}
+		key = bech32Encode("age", raw)
+	}
+
+	r, err := age.ParseX25519Recipient(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", key, err)
+	}
+	return r, nil
+}
+
+// ParseRecipients parses the public keys of several recipients with ParseRecipient
+func ParseRecipients(keys []string) ([]Recipient, error) {
+	recipients := make([]Recipient, 0, len(keys))
+	for _, key := range keys {
+		r, err := ParseRecipient(key)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, nil
+}
+
+// bech32Charset is the alphabet of the data part of bech32 strings
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Encode encodes data as a lowercase bech32 string (BIP 173), the encoding of age keys.
+// age keys are longer than the 90 characters allowed by BIP 173, and age doesn't enforce the limit.
+func bech32Encode(hrp string, data []byte) string {
+	// Regroup the bytes into 5-bit values, padding the last one with zeros
+	var values []byte
+	acc, bits := 0, 0
+	for _, b := range data {
+		acc = acc<<8 | int(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			values = append(values, byte(acc>>bits&31))
+		}
+	}
+	if bits > 0 {
+		values = append(values, byte(acc<<(5-bits)&31))
+	}
+
+	// The checksum covers the expanded human-readable part and the values
+	var expanded []byte
+	for _, c := range []byte(hrp) {
+		expanded = append(expanded, c>>5)
+	}
+	expanded = append(expanded, 0)
+	for _, c := range []byte(hrp) {
+		expanded = append(expanded, c&31)
+	}
+	expanded = append(expanded, values...)
+	mod := bech32Polymod(append(expanded, 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(mod>>(5*(5-i))&31))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range values {
+		sb.WriteByte(bech32Charset[v])
+	}
+	return sb.String()
+}
+
+// bech32Polymod computes the BCH checksum of bech32
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if top>>i&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
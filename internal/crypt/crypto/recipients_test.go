@@ -0,0 +1,96 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	dcrypto "github.com/drand/drand/crypto"
+)
+
+func TestSealWithRecipients(t *testing.T) {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSUnchainedG1())
+	info := beacon.chainInfo(time.Now().Add(-time.Minute), time.Second)
+	plaintext := []byte("This is a secret message")
+	round := uint64(1)
+
+	alice, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	bob, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	recipients, err := ParseRecipients([]string{alice.Recipient().String(), bob.Recipient().String()})
+	if err != nil {
+		t.Fatalf("ParseRecipients failed: %v", err)
+	}
+
+	ciphertext, _, err := Seal(info, plaintext, round, recipients...)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if err := validateAge(info, ciphertext, round); err != nil {
+		t.Errorf("validateAge failed: %v", err)
+	}
+
+	// Each recipient decrypts the file with their identity, without the round signature
+	for _, id := range []*age.X25519Identity{alice, bob} {
+		r, err := age.Decrypt(bytes.NewReader(ciphertext), id)
+		if err != nil {
+			t.Fatalf("Failed to decrypt as a recipient: %v", err)
+		}
+		decrypted, _ := io.ReadAll(r)
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("Decrypted text doesn't match. Got: %s, Want: %s", decrypted, plaintext)
+		}
+	}
+
+	// Others decrypt it with the signature
+	decrypted, err := openAge(info, ciphertext, round, beacon.sign(t, round))
+	if err != nil {
+		t.Fatalf("openAge failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Decrypted text doesn't match. Got: %s, Want: %s", decrypted, plaintext)
+	}
+
+	stranger, _ := age.GenerateX25519Identity()
+	if _, err := age.Decrypt(bytes.NewReader(ciphertext), stranger); err == nil {
+		t.Error("Expected other identities to fail")
+	}
+}
+
+func TestParseRecipientRawKey(t *testing.T) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	r, err := ParseRecipient(base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()))
+	if err != nil {
+		t.Fatalf("ParseRecipient failed: %v", err)
+	}
+
+	// The matching age identity is the private scalar in uppercase bech32
+	id, err := age.ParseX25519Identity(strings.ToUpper(bech32Encode("age-secret-key-", key.Bytes())))
+	if err != nil {
+		t.Fatalf("Failed to parse identity: %v", err)
+	}
+	if got := r.(*age.X25519Recipient).String(); got != id.Recipient().String() {
+		t.Errorf("Unexpected recipient. Got: %s, Want: %s", got, id.Recipient())
+	}
+
+	for _, invalid := range []string{"", "age1invalid", "c2hvcnQ=", "ssh-ed25519 AAAA"} {
+		if _, err := ParseRecipient(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
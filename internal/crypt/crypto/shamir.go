@@ -0,0 +1,99 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Shamir's secret sharing over GF(2^8), byte by byte: each byte of the secret is the constant
+// term of a random polynomial of degree threshold-1, and share i holds the values of the
+// polynomials at x = i+1. A share is [x][y for each byte of the secret].
+
+// ErrInvalidShares is returned when shares can't be combined
+var ErrInvalidShares = errors.New("invalid shares")
+
+// SplitSecret splits a secret into n shares, any threshold of which recover it
+func SplitSecret(secret []byte, n, threshold int) ([][]byte, error) {
+	if threshold < 1 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("invalid threshold %d of %d shares", threshold, n)
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, 1+len(secret))
+		shares[i][0] = byte(i + 1)
+	}
+
+	coeffs := make([]byte, threshold)
+	for b, s := range secret {
+		coeffs[0] = s
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, fmt.Errorf("failed to split secret: %w", err)
+		}
+
+		// Horner's method, from the highest degree
+		for _, share := range shares {
+			x, y := share[0], byte(0)
+			for d := threshold - 1; d >= 0; d-- {
+				y = gfMul(y, x) ^ coeffs[d]
+			}
+			share[1+b] = y
+		}
+	}
+	return shares, nil
+}
+
+// CombineShares recovers a secret from at least its threshold of shares.
+// Fewer shares silently recover a wrong secret, so the secret must be authenticated.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 || len(shares[0]) < 2 {
+		return nil, ErrInvalidShares
+	}
+	size := len(shares[0]) - 1
+	for i, share := range shares {
+		if len(share) != size+1 || share[0] == 0 {
+			return nil, ErrInvalidShares
+		}
+		for _, other := range shares[:i] {
+			if other[0] == share[0] {
+				return nil, fmt.Errorf("%w: duplicate share %d", ErrInvalidShares, share[0])
+			}
+		}
+	}
+
+	// Lagrange interpolation at x = 0, where subtraction is XOR
+	secret := make([]byte, size)
+	for i, share := range shares {
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = gfMul(basis, gfMul(other[0], gfInv(other[0]^share[0])))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(share[1+b], basis)
+		}
+	}
+	return secret, nil
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial, without branching on the operands
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		a = a<<1 ^ 0x1b&-(a>>7)
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the multiplicative inverse of a non-zero element: a^254
+func gfInv(a byte) byte {
+	r := byte(1)
+	for i := 0; i < 254; i++ {
+		r = gfMul(r, a)
+	}
+	return r
+}
@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSplitCombineSecret(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	for _, tt := range []struct{ n, threshold int }{{1, 1}, {2, 2}, {3, 1}, {3, 2}, {5, 3}, {255, 10}} {
+		shares, err := SplitSecret(secret, tt.n, tt.threshold)
+		if err != nil {
+			t.Fatalf("SplitSecret(%d, %d) failed: %v", tt.n, tt.threshold, err)
+		}
+		if len(shares) != tt.n {
+			t.Fatalf("Expected %d shares, got %d", tt.n, len(shares))
+		}
+
+		// Any threshold of shares recovers the secret, here the last ones
+		combined, err := CombineShares(shares[tt.n-tt.threshold:])
+		if err != nil {
+			t.Fatalf("CombineShares failed: %v", err)
+		}
+		if !bytes.Equal(combined, secret) {
+			t.Errorf("%d of %d shares: recovered %x, want %x", tt.threshold, tt.n, combined, secret)
+		}
+
+		// Fewer shares don't
+		if tt.threshold > 1 {
+			combined, err := CombineShares(shares[:tt.threshold-1])
+			if err != nil {
+				t.Fatalf("CombineShares failed: %v", err)
+			}
+			if bytes.Equal(combined, secret) {
+				t.Errorf("%d of %d shares recovered the secret below the threshold", tt.threshold-1, tt.n)
+			}
+		}
+	}
+}
+
+func TestSplitCombineSecretErrors(t *testing.T) {
+	for _, tt := range []struct{ n, threshold int }{{2, 0}, {2, 3}, {256, 2}} {
+		if _, err := SplitSecret([]byte("secret"), tt.n, tt.threshold); err == nil {
+			t.Errorf("Expected an error for %d of %d shares", tt.threshold, tt.n)
+		}
+	}
+
+	shares, _ := SplitSecret([]byte("secret"), 3, 2)
+	for name, invalid := range map[string][][]byte{
+		"none":      nil,
+		"duplicate": {shares[0], shares[0]},
+		"length":    {shares[0], shares[1][:3]},
+		"zero x":    {append([]byte{0}, shares[0][1:]...), shares[1]},
+	} {
+		if _, err := CombineShares(invalid); !errors.Is(err, ErrInvalidShares) {
+			t.Errorf("%s: expected ErrInvalidShares, got %v", name, err)
+		}
+	}
+}
+
+func TestGFInverse(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if p := gfMul(byte(a), gfInv(byte(a))); p != 1 {
+			t.Fatalf("%d * inverse = %d", a, p)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"time"
+
+	"filippo.io/age"
+	"github.com/korjavin/drand-poc/internal/crypt/drand"
+)
+
+// The streaming functions write and read the same age files as Seal and Decrypt.
+// The payload of an age file is encrypted with age's STREAM construction:
+// ChaCha20-Poly1305 over 64 KiB chunks, each authenticated on its own and the last one
+// flagged, so large files are processed in constant memory and truncation is detected.
+
+// EncryptStream encrypts src to dst so it can only be decrypted after the specified time.
+// It returns the hash of the written ciphertext and the round it is locked to.
+// The recipients can decrypt it at any time with their age identity.
+func (l *Locker) EncryptStream(dst io.Writer, src io.Reader, unlockAt time.Time, recipients ...age.Recipient) (hash []byte, round uint64, err error) {
+	// Calculate the first round produced at or after the unlock time
+	round = l.info.RoundFor(unlockAt)
+
+	// Ensure the unlock round is in the future
+	if round <= l.info.RoundAt(l.now()) {
+		return nil, 0, fmt.Errorf("unlock time must be in the future")
+	}
+
+	hash, err = SealStream(l.info, dst, src, round, recipients...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return hash, round, nil
+}
+
+// SealStream encrypts src towards a round of the chain into a binary age file written to dst,
+// and returns the hash of the file. Like Seal, it needs no drand client.
+func SealStream(info *drand.ChainInfo, dst io.Writer, src io.Reader, round uint64, recipients ...age.Recipient) (hash []byte, err error) {
+	h := sha256.New()
+	if err := sealAgeStream(info, io.MultiWriter(dst, h), src, round, recipients...); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// DecryptStream returns a reader of the plaintext of a binary or armored age file read from src,
+// if the current time is after the unlock time. Reads fail if a chunk was tampered with
+// or the file is truncated, and no unauthenticated plaintext is ever returned.
+// Legacy ciphertexts are not supported and return ErrLegacyFormat.
+func (l *Locker) DecryptStream(src io.Reader, round uint64) (io.Reader, error) {
+	signature, err := l.signature(round)
+	if err != nil {
+		return nil, err
+	}
+
+	return openAgeStream(l.info, src, round, signature)
+}
@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	dcrypto "github.com/drand/drand/crypto"
+)
+
+func TestEncryptDecryptStream(t *testing.T) {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSUnchainedG1())
+	info := beacon.chainInfo(time.Now(), time.Second)
+
+	// Several STREAM chunks, the last one partial
+	plaintext := make([]byte, 3*64*1024+123)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatalf("Failed to generate plaintext: %v", err)
+	}
+
+	client := &mockClient{info: info}
+	locker := NewLocker(client)
+
+	var ciphertext bytes.Buffer
+	hash, round, err := locker.EncryptStream(&ciphertext, bytes.NewReader(plaintext), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+
+	sum := sha256.Sum256(ciphertext.Bytes())
+	if !bytes.Equal(hash, sum[:]) {
+		t.Errorf("Hash doesn't match the ciphertext")
+	}
+
+	client.signature = beacon.sign(t, round)
+	if _, err := locker.DecryptStream(bytes.NewReader(ciphertext.Bytes()), round); err != ErrTooEarly {
+		t.Fatalf("Expected ErrTooEarly, got: %v", err)
+	}
+
+	later := NewLocker(client, WithClock(func() time.Time { return time.Now().Add(2 * time.Hour) }))
+	r, err := later.DecryptStream(bytes.NewReader(ciphertext.Bytes()), round)
+	if err != nil {
+		t.Fatalf("DecryptStream failed: %v", err)
+	}
+	decrypted, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read plaintext: %v", err)
+	}
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("Decrypted stream doesn't match original")
+	}
+
+	// Streams are regular age files
+	if decrypted, err := later.Decrypt(ciphertext.Bytes(), round); err != nil || !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("Failed to decrypt the stream with Decrypt: %v", err)
+	}
+}
+
+func TestDecryptStreamRejectsTampering(t *testing.T) {
+	beacon := newTestBeacon(t, dcrypto.NewPedersenBLSUnchainedG1())
+	info := beacon.chainInfo(time.Now().Add(-time.Minute), time.Second)
+	round := uint64(1)
+	locker := NewLocker(&mockClient{info: info, signature: beacon.sign(t, round)})
+
+	plaintext := bytes.Repeat([]byte("chunk"), 40*1024)
+	var buf bytes.Buffer
+	if _, err := SealStream(info, &buf, bytes.NewReader(plaintext), round); err != nil {
+		t.Fatalf("SealStream failed: %v", err)
+	}
+	ciphertext := buf.Bytes()
+
+	tampered := bytes.Clone(ciphertext)
+	tampered[len(tampered)-100] ^= 1
+
+	tests := map[string][]byte{
+		"tampered":  tampered,
+		"truncated": ciphertext[:len(ciphertext)-64*1024],
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := locker.DecryptStream(bytes.NewReader(data), round)
+			if err != nil {
+				t.Fatalf("DecryptStream failed: %v", err)
+			}
+			if _, err := io.ReadAll(r); err == nil {
+				t.Errorf("Expected an error reading a %s stream", name)
+			}
+		})
+	}
+
+	// Armored files are streamed as well
+	armored, err := Armor(ciphertext)
+	if err != nil {
+		t.Fatalf("Armor failed: %v", err)
+	}
+	r, err := locker.DecryptStream(bytes.NewReader(armored), round)
+	if err != nil {
+		t.Fatalf("DecryptStream failed: %v", err)
+	}
+	if decrypted, err := io.ReadAll(r); err != nil || !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("Failed to decrypt an armored stream: %v", err)
+	}
+
+	legacy, err := sealLegacy(plaintext, info.Scheme, info.PublicKey, round)
+	if err != nil {
+		t.Fatalf("sealLegacy failed: %v", err)
+	}
+	if _, err := locker.DecryptStream(bytes.NewReader(legacy), round); !errors.Is(err, ErrLegacyFormat) {
+		t.Errorf("Expected ErrLegacyFormat, got: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package drand
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/drand/drand/chain"
+	dcrypto "github.com/drand/drand/crypto"
+	"github.com/drand/kyber"
+)
+
+// ErrInvalidBeacon is returned when a beacon does not verify against the chain
+var ErrInvalidBeacon = errors.New("invalid beacon")
+
+// quicknetInfo is the /info document of the drand quicknet chain
+const quicknetInfo = `{
+	"public_key": "83cf0f2896adee7eb8b5f01fcad3912212c437e0073e911fb90022d3e760183c8c4b450b6a0a6c3ac6a5776a2d1064510d1fec758c921cc22b0e17e63aaf4bcb5ed66304de9cf809bd274ca73bab4af5a6e9c76a4bc09e76eae8991ef5ece45a",
+	"period": 3,
+	"genesis_time": 1692803367,
+	"hash": "52db9ba70e0cc0f6eaf7803dd07447a1f5477735fd3f661792ba94600c84e971",
+	"groupHash": "f477d5c89f21a17c863a7f937c6a6d15859414d2be09cd448d4279af331c5d3e",
+	"schemeID": "bls-unchained-g1-rfc9380",
+	"metadata": {"beaconID": "quicknet"}
+}`
+
+// ChainInfo holds the public parameters of a drand chain
+type ChainInfo struct {
+	PublicKey   kyber.Point     // Group public key
+	Period      time.Duration   // Time between rounds
+	GenesisTime int64           // Time of round 1 in seconds since epoch
+	Scheme      *dcrypto.Scheme // Signature scheme
+	Hash        []byte          // Chain hash
+	GenesisSeed []byte          // Group hash, part of the chain hash
+	ID          string          // Beacon ID, part of the chain hash
+}
+
+// chainInfoJSON is the JSON representation of the chain info served on /info
+type chainInfoJSON struct {
+	PublicKey   string `json:"public_key"`
+	Period      uint32 `json:"period"`
+	GenesisTime int64  `json:"genesis_time"`
+	Hash        string `json:"hash"`
+	GroupHash   string `json:"groupHash"`
+	SchemeID    string `json:"schemeID"`
+	Metadata    struct {
+		BeaconID string `json:"beaconID"`
+	} `json:"metadata"`
+}
+
+// NewChainInfo creates the chain info of a chain with the given parameters
+func NewChainInfo(publicKey kyber.Point, period time.Duration, genesisTime int64, scheme *dcrypto.Scheme) *ChainInfo {
+	info := &ChainInfo{
+		PublicKey:   publicKey,
+		Period:      period,
+		GenesisTime: genesisTime,
+		Scheme:      scheme,
+	}
+	info.Hash = info.drandInfo().Hash()
+	return info
+}
+
+// Quicknet returns the chain info of the drand quicknet chain
+func Quicknet() *ChainInfo {
+	info, err := ParseChainInfo(strings.NewReader(quicknetInfo))
+	if err != nil {
+		panic(fmt.Sprintf("invalid quicknet chain info: %v", err))
+	}
+	return info
+}
+
+// ParseChainInfo parses a chain info JSON document.
+// If the document contains a hash, it must match the hash of the parameters.
+func ParseChainInfo(r io.Reader) (*ChainInfo, error) {
+	var raw chainInfoJSON
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to decode chain info: %w", err)
+	}
+
+	scheme, err := dcrypto.GetSchemeByIDWithDefault(raw.SchemeID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chain info: %w", err)
+	}
+
+	if raw.Period == 0 {
+		return nil, fmt.Errorf("invalid chain info: period must be positive")
+	}
+
+	pubKeyBytes, err := hex.DecodeString(raw.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chain info: failed to decode public key: %w", err)
+	}
+	publicKey := scheme.KeyGroup.Point()
+	if err := publicKey.UnmarshalBinary(pubKeyBytes); err != nil {
+		return nil, fmt.Errorf("invalid chain info: failed to unmarshal public key: %w", err)
+	}
+
+	genesisSeed, err := hex.DecodeString(raw.GroupHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chain info: failed to decode group hash: %w", err)
+	}
+
+	info := &ChainInfo{
+		PublicKey:   publicKey,
+		Period:      time.Duration(raw.Period) * time.Second,
+		GenesisTime: raw.GenesisTime,
+		Scheme:      scheme,
+		GenesisSeed: genesisSeed,
+		ID:          raw.Metadata.BeaconID,
+	}
+	info.Hash = info.drandInfo().Hash()
+
+	if raw.Hash != "" {
+		declared, err := hex.DecodeString(raw.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chain info: failed to decode hash: %w", err)
+		}
+		if !bytes.Equal(declared, info.Hash) {
+			return nil, fmt.Errorf("invalid chain info: hash mismatch: declared %x, computed %x", declared, info.Hash)
+		}
+	}
+
+	return info, nil
+}
+
+// LoadChainInfo reads a chain info JSON document from a file
+func LoadChainInfo(path string) (*ChainInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chain info: %w", err)
+	}
+	defer f.Close()
+
+	return ParseChainInfo(f)
+}
+
+// FetchChainInfo fetches the chain info from the /info endpoint of a drand HTTP relay.
+// The returned info is checked against the chain hash, if one is given.
+func FetchChainInfo(ctx context.Context, url, chainHash string) (*ChainInfo, error) {
+	endpoint := strings.TrimSuffix(url, "/") + "/info"
+	if chainHash != "" {
+		endpoint = fmt.Sprintf("%s/%s/info", strings.TrimSuffix(url, "/"), chainHash)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chain info: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch chain info: unexpected status %d", resp.StatusCode)
+	}
+
+	info, err := ParseChainInfo(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if chainHash != "" && info.HashString() != chainHash {
+		return nil, fmt.Errorf("chain hash mismatch: want %s, got %s", chainHash, info.HashString())
+	}
+
+	return info, nil
+}
+
+// MarshalJSON encodes the chain info in the format served on /info
+func (c *ChainInfo) MarshalJSON() ([]byte, error) {
+	pubKeyBytes, err := c.PublicKey.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %w", err)
+	}
+
+	raw := chainInfoJSON{
+		PublicKey:   hex.EncodeToString(pubKeyBytes),
+		Period:      uint32(c.Period.Seconds()),
+		GenesisTime: c.GenesisTime,
+		Hash:        c.HashString(),
+		GroupHash:   hex.EncodeToString(c.GenesisSeed),
+		SchemeID:    c.Scheme.Name,
+	}
+	raw.Metadata.BeaconID = c.ID
+
+	return json.Marshal(raw)
+}
+
+// HashString returns the hex-encoded chain hash
+func (c *ChainInfo) HashString() string {
+	return hex.EncodeToString(c.Hash)
+}
+
+// RoundAt returns the latest round produced at the given time
+func (c *ChainInfo) RoundAt(t time.Time) uint64 {
+	return chain.CurrentRound(t.Unix(), c.Period, c.GenesisTime)
+}
+
+// RoundFor returns the first round produced at or after the given time
+func (c *ChainInfo) RoundFor(t time.Time) uint64 {
+	round := c.RoundAt(t)
+	if c.TimeOfRound(round).Before(t) {
+		round++
+	}
+	return round
+}
+
+// TimeOfRound returns the time at which the given round is produced
+func (c *ChainInfo) TimeOfRound(round uint64) time.Time {
+	return time.Unix(chain.TimeOfRound(c.Period, c.GenesisTime, round), 0).UTC()
+}
+
+// VerifyBeacon checks the signature of a round against the chain public key.
+// The previous signature is only used by chained schemes.
+func (c *ChainInfo) VerifyBeacon(round uint64, signature, previousSignature []byte) error {
+	beacon := &chain.Beacon{
+		Round:       round,
+		Signature:   signature,
+		PreviousSig: previousSignature,
+	}
+	if err := c.Scheme.VerifyBeacon(beacon, c.PublicKey); err != nil {
+		return fmt.Errorf("%w: round %d: %v", ErrInvalidBeacon, round, err)
+	}
+	return nil
+}
+
+// drandInfo converts the chain info to the drand library representation
+func (c *ChainInfo) drandInfo() *chain.Info {
+	return &chain.Info{
+		PublicKey:   c.PublicKey,
+		ID:          c.ID,
+		Period:      c.Period,
+		Scheme:      c.Scheme.Name,
+		GenesisTime: c.GenesisTime,
+		GenesisSeed: c.GenesisSeed,
+	}
+}
@@ -0,0 +1,111 @@
+package drand
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuicknet(t *testing.T) {
+	info := Quicknet()
+
+	if info.HashString() != DefaultChainHash {
+		t.Errorf("Unexpected chain hash. Got: %s, Want: %s", info.HashString(), DefaultChainHash)
+	}
+	if info.Period != 3*time.Second {
+		t.Errorf("Unexpected period. Got: %v, Want: %v", info.Period, 3*time.Second)
+	}
+	if info.Scheme.Name != "bls-unchained-g1-rfc9380" {
+		t.Errorf("Unexpected scheme. Got: %s", info.Scheme.Name)
+	}
+}
+
+func TestParseChainInfoHashMismatch(t *testing.T) {
+	doc := strings.Replace(quicknetInfo, `"period": 3`, `"period": 30`, 1)
+
+	if _, err := ParseChainInfo(strings.NewReader(doc)); err == nil {
+		t.Errorf("Expected an error for a chain info with a mismatching hash")
+	}
+}
+
+func TestLoadChainInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "info.json")
+	if err := os.WriteFile(path, []byte(quicknetInfo), 0644); err != nil {
+		t.Fatalf("Failed to write chain info: %v", err)
+	}
+
+	info, err := LoadChainInfo(path)
+	if err != nil {
+		t.Fatalf("Failed to load chain info: %v", err)
+	}
+
+	if info.HashString() != DefaultChainHash {
+		t.Errorf("Unexpected chain hash. Got: %s, Want: %s", info.HashString(), DefaultChainHash)
+	}
+}
+
+func TestChainInfoJSONRoundTrip(t *testing.T) {
+	data, err := json.Marshal(Quicknet())
+	if err != nil {
+		t.Fatalf("Failed to marshal chain info: %v", err)
+	}
+
+	info, err := ParseChainInfo(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to parse chain info: %v", err)
+	}
+
+	if info.HashString() != DefaultChainHash {
+		t.Errorf("Unexpected chain hash. Got: %s, Want: %s", info.HashString(), DefaultChainHash)
+	}
+}
+
+func TestFetchChainInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+DefaultChainHash+"/info" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(quicknetInfo))
+	}))
+	defer server.Close()
+
+	info, err := FetchChainInfo(context.Background(), server.URL, DefaultChainHash)
+	if err != nil {
+		t.Fatalf("Failed to fetch chain info: %v", err)
+	}
+	if info.HashString() != DefaultChainHash {
+		t.Errorf("Unexpected chain hash. Got: %s, Want: %s", info.HashString(), DefaultChainHash)
+	}
+
+	// A chain with another hash is not served
+	if _, err := FetchChainInfo(context.Background(), server.URL, strings.Repeat("00", 32)); err == nil {
+		t.Errorf("Expected an error when fetching an unknown chain")
+	}
+}
+
+func TestRounds(t *testing.T) {
+	info := Quicknet()
+	genesis := time.Unix(info.GenesisTime, 0)
+
+	if round := info.RoundAt(genesis); round != 1 {
+		t.Errorf("Expected round 1 at genesis, got %d", round)
+	}
+	if round := info.RoundAt(genesis.Add(4 * time.Second)); round != 2 {
+		t.Errorf("Expected round 2 four seconds after genesis, got %d", round)
+	}
+	if round := info.RoundFor(genesis.Add(4 * time.Second)); round != 3 {
+		t.Errorf("Expected round 3 to be the first round after four seconds, got %d", round)
+	}
+	if ts := info.TimeOfRound(3); !ts.Equal(genesis.Add(6 * time.Second)) {
+		t.Errorf("Unexpected time of round 3: %v", ts)
+	}
+}
@@ -1,59 +1,127 @@
 package drand
 
 import (
+	"bytes"
 	"context"
-	"encoding/hex"
 	"fmt"
 	"time"
 
 	"github.com/drand/drand/client"
 	"github.com/drand/drand/client/http"
+	dcrypto "github.com/drand/drand/crypto"
 )
 
-// DefaultChainHash is the hash of the drand chain info
-const DefaultChainHash = "8990e7a9aaed2ffed73dbd7092123d6f289930540d7651336225dc172e51b2ce"
+// DefaultChainHash is the hash of the drand quicknet chain info
+const DefaultChainHash = "52db9ba70e0cc0f6eaf7803dd07447a1f5477735fd3f661792ba94600c84e971"
+
+// DefaultURLs are the public HTTP endpoints of the League of Entropy
+var DefaultURLs = []string{
+	"https://api.drand.sh",
+	"https://drand.cloudflare.com",
+}
 
 // Client is a wrapper around drand client
 type Client struct {
 	client client.Client
+	info   *ChainInfo
 }
 
-// NewClient creates a new drand client
-func NewClient() (*Client, error) {
-	// Use the public HTTP endpoints
-	urls := []string{
-		"https://api.drand.sh",
-		"https://drand.cloudflare.com",
+// NewClient creates a new drand client for the given chain and HTTP endpoints
+func NewClient(info *ChainInfo, urls ...string) (*Client, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no drand endpoints specified")
 	}
 
-	// Decode the chain hash from hex
-	chainHash, err := hex.DecodeString(DefaultChainHash)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode chain hash: %w", err)
+	// Create an HTTP client for each endpoint, using the known chain info
+	// so that no request is made before the first fetch
+	drandInfo := info.drandInfo()
+	sources := make([]client.Client, 0, len(urls))
+	for _, url := range urls {
+		c, err := http.NewWithInfo(url, drandInfo, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create drand HTTP client for %s: %w", url, err)
+		}
+		sources = append(sources, c)
 	}
 
 	// Create a new drand client with HTTP clients
 	c, err := client.New(
-		client.From(http.ForURLs(urls, chainHash)...),
-		client.WithChainHash(chainHash),
+		client.From(sources...),
+		client.WithChainInfo(drandInfo),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create drand client: %w", err)
 	}
 
-	return &Client{client: c}, nil
+	return &Client{client: c, info: info}, nil
+}
+
+// Info returns the chain info of the client
+func (c *Client) Info() *ChainInfo {
+	return c.info
 }
 
 // FetchRandomness fetches randomness for a specific round
 func (c *Client) FetchRandomness(round uint64) ([]byte, error) {
+	result, err := c.fetch(round)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch randomness: %w", err)
+	}
+
+	return result.Randomness(), nil
+}
+
+// FetchSignature fetches the beacon signature for a specific round
+func (c *Client) FetchSignature(round uint64) ([]byte, error) {
+	result, err := c.fetch(round)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signature: %w", err)
+	}
+
+	return result.Signature(), nil
+}
+
+// chainedResult is implemented by results that carry the previous signature
+type chainedResult interface {
+	PreviousSignature() []byte
+}
+
+// previousSignature returns the previous signature carried by a result, if any
+func previousSignature(result client.Result) []byte {
+	switch r := result.(type) {
+	case *client.RandomData:
+		return r.PreviousSignature
+	case chainedResult:
+		return r.PreviousSignature()
+	default:
+		return nil
+	}
+}
+
+// fetch gets the beacon for a specific round and verifies it against the chain
+func (c *Client) fetch(round uint64) (client.Result, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Get the randomness for the specified round
+	// Get the beacon for the specified round
 	result, err := c.client.Get(ctx, round)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch randomness: %w", err)
+		return nil, err
 	}
 
-	return result.Randomness(), nil
+	if result.Round() != round {
+		return nil, fmt.Errorf("%w: got round %d, want %d", ErrInvalidBeacon, result.Round(), round)
+	}
+
+	// Verify the signature against the chain public key
+	if err := c.info.VerifyBeacon(round, result.Signature(), previousSignature(result)); err != nil {
+		return nil, err
+	}
+
+	// The randomness must be derived from the verified signature
+	if !bytes.Equal(result.Randomness(), dcrypto.RandomnessFromSignature(result.Signature())) {
+		return nil, fmt.Errorf("%w: round %d: randomness does not match signature", ErrInvalidBeacon, round)
+	}
+
+	return result, nil
 }
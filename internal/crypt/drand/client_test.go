@@ -1,36 +1,65 @@
 package drand
 
 import (
+	"bytes"
 	"context"
-	"encoding/hex"
-	"net/http"
-	"net/http/httptest"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/drand/drand/chain"
 	"github.com/drand/drand/client"
+	dcrypto "github.com/drand/drand/crypto"
+	"github.com/drand/kyber"
+	"github.com/drand/kyber/util/random"
 )
 
-func TestFetchRandomness(t *testing.T) {
-	// Create a mock HTTP server
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Mock response with a fixed randomness value
-		mockResponse := `{
-			"round": 1234,
-			"randomness": "7b00000000000000000000000000000000000000000000000000000000000000",
-			"signature": "mock-signature",
-			"previous_signature": "mock-previous-signature"
-		}`
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(mockResponse))
+// testChain is a locally generated chain keypair for testing
+type testChain struct {
+	info    *ChainInfo
+	private kyber.Scalar
+}
+
+func newTestChain(t *testing.T, scheme *dcrypto.Scheme) *testChain {
+	t.Helper()
+	private := scheme.KeyGroup.Scalar().Pick(random.New())
+	public := scheme.KeyGroup.Point().Mul(private, nil)
+	return &testChain{
+		info:    NewChainInfo(public, 3*time.Second, time.Now().Unix(), scheme),
+		private: private,
+	}
+}
+
+// beacon returns a correctly signed beacon for the given round
+func (c *testChain) beacon(t *testing.T, round uint64, previousSignature []byte) *mockRandomness {
+	t.Helper()
+	scheme := c.info.Scheme
+	if scheme.Name != dcrypto.DefaultSchemeID {
+		previousSignature = nil
+	}
+	sig, err := scheme.AuthScheme.Sign(c.private, scheme.DigestBeacon(&chain.Beacon{
+		Round:       round,
+		PreviousSig: previousSignature,
 	}))
-	defer server.Close()
+	if err != nil {
+		t.Fatalf("Failed to sign round: %v", err)
+	}
+	return &mockRandomness{
+		round:             round,
+		randomness:        dcrypto.RandomnessFromSignature(sig),
+		signature:         sig,
+		previousSignature: previousSignature,
+	}
+}
+
+func TestFetchRandomness(t *testing.T) {
+	tc := newTestChain(t, dcrypto.NewPedersenBLSUnchainedG1())
+	beacon := tc.beacon(t, 1234, nil)
 
-	// Create a test client that uses our mock server
+	// Create a test client that uses our mock drand client
 	testClient := &Client{
-		client: &mockDrandClient{},
+		client: &mockDrandClient{result: beacon},
+		info:   tc.info,
 	}
 
 	// Test fetching randomness
@@ -39,28 +68,118 @@ func TestFetchRandomness(t *testing.T) {
 		t.Fatalf("Failed to fetch randomness: %v", err)
 	}
 
-	// Expected randomness (hex decoded from the mock response)
-	expectedHex := "7b00000000000000000000000000000000000000000000000000000000000000"
-	expected, err := hex.DecodeString(expectedHex)
+	// The randomness is the hash of the signature
+	if !bytes.Equal(randomness, dcrypto.RandomnessFromSignature(beacon.signature)) {
+		t.Errorf("Unexpected randomness. Got: %x, Want: %x", randomness, beacon.randomness)
+	}
+}
+
+func TestFetchSignature(t *testing.T) {
+	tc := newTestChain(t, dcrypto.NewPedersenBLSUnchainedG1())
+	beacon := tc.beacon(t, 1234, nil)
+
+	testClient := &Client{
+		client: &mockDrandClient{result: beacon},
+		info:   tc.info,
+	}
+
+	signature, err := testClient.FetchSignature(1234)
 	if err != nil {
-		t.Fatalf("Failed to decode expected hex: %v", err)
+		t.Fatalf("Failed to fetch signature: %v", err)
 	}
 
-	// Compare the result
-	if hex.EncodeToString(randomness) != expectedHex {
-		t.Errorf("Unexpected randomness. Got: %x, Want: %x", randomness, expected)
+	if !bytes.Equal(signature, beacon.signature) {
+		t.Errorf("Unexpected signature. Got: %x, Want: %x", signature, beacon.signature)
+	}
+}
+
+func TestFetchVerifiesBeacons(t *testing.T) {
+	schemes := []*dcrypto.Scheme{
+		dcrypto.NewPedersenBLSChained(),
+		dcrypto.NewPedersenBLSUnchained(),
+		dcrypto.NewPedersenBLSUnchainedG1(),
+		dcrypto.NewPedersenBLSUnchainedSwapped(),
+	}
+
+	for _, scheme := range schemes {
+		t.Run(scheme.Name, func(t *testing.T) {
+			tc := newTestChain(t, scheme)
+			other := newTestChain(t, scheme)
+			previous := tc.beacon(t, 1233, nil).signature
+
+			tests := []struct {
+				name   string
+				result *mockRandomness
+				valid  bool
+			}{
+				{
+					name:   "valid",
+					result: tc.beacon(t, 1234, previous),
+					valid:  true,
+				},
+				{
+					name:   "signed by another key",
+					result: other.beacon(t, 1234, previous),
+				},
+				{
+					name:   "other round",
+					result: tc.beacon(t, 1235, previous),
+				},
+				{
+					name: "signature of another round",
+					result: func() *mockRandomness {
+						b := tc.beacon(t, 1234, previous)
+						b.signature = tc.beacon(t, 1235, previous).signature
+						b.randomness = dcrypto.RandomnessFromSignature(b.signature)
+						return b
+					}(),
+				},
+				{
+					name: "forged randomness",
+					result: func() *mockRandomness {
+						b := tc.beacon(t, 1234, previous)
+						b.randomness = make([]byte, 32)
+						return b
+					}(),
+				},
+				{
+					name: "garbage signature",
+					result: func() *mockRandomness {
+						b := tc.beacon(t, 1234, previous)
+						b.signature = []byte("mock-signature")
+						return b
+					}(),
+				},
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					testClient := &Client{
+						client: &mockDrandClient{result: tt.result},
+						info:   tc.info,
+					}
+
+					_, err := testClient.FetchRandomness(1234)
+					if tt.valid && err != nil {
+						t.Fatalf("Expected a valid beacon, got: %v", err)
+					}
+					if !tt.valid && !errors.Is(err, ErrInvalidBeacon) {
+						t.Fatalf("Expected ErrInvalidBeacon, got: %v", err)
+					}
+				})
+			}
+		})
 	}
 }
 
 // mockDrandClient is a simple mock implementation of the drand client.Client interface
-type mockDrandClient struct{}
+type mockDrandClient struct {
+	result *mockRandomness
+}
 
 // Get implements the client.Client interface
 func (m *mockDrandClient) Get(ctx context.Context, round uint64) (client.Result, error) {
-	// Create a mock response
-	return &mockRandomness{
-		randomness: []byte{0x7b, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
-	}, nil
+	return m.result, nil
 }
 
 // Watch implements the client.Client interface
@@ -85,7 +204,10 @@ func (m *mockDrandClient) Close() error {
 
 // mockRandomness implements the client.Result interface
 type mockRandomness struct {
-	randomness []byte
+	round             uint64
+	randomness        []byte
+	signature         []byte
+	previousSignature []byte
 }
 
 // Randomness returns the mock randomness
@@ -93,17 +215,17 @@ func (m *mockRandomness) Randomness() []byte {
 	return m.randomness
 }
 
-// Round returns a mock round number
+// Round returns the mock round number
 func (m *mockRandomness) Round() uint64 {
-	return 1234
+	return m.round
 }
 
-// Signature returns a mock signature
+// Signature returns the mock signature
 func (m *mockRandomness) Signature() []byte {
-	return []byte("mock-signature")
+	return m.signature
 }
 
-// PreviousSignature returns a mock previous signature
+// PreviousSignature returns the mock previous signature
 func (m *mockRandomness) PreviousSignature() []byte {
-	return []byte("mock-previous-signature")
+	return m.previousSignature
 }
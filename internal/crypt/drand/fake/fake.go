@@ -0,0 +1,270 @@
+// Package fake provides an in-process drand beacon for tests and offline development.
+package fake
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/drand/drand/chain"
+	dcrypto "github.com/drand/drand/crypto"
+	"github.com/drand/kyber"
+	"github.com/drand/kyber/util/random"
+	"github.com/korjavin/drand-poc/internal/crypt/drand"
+)
+
+// ErrFutureRound is returned when a round has not been produced yet
+var ErrFutureRound = errors.New("round not produced yet")
+
+// Beacon is a single-node drand beacon running on a controllable clock.
+// The clock is frozen until Run is called. It implements the crypto.Client interface.
+type Beacon struct {
+	mu      sync.Mutex
+	info    *drand.ChainInfo
+	private kyber.Scalar
+	now     time.Time         // Current time while the clock is frozen
+	running bool              // Whether the clock follows the real time
+	offset  time.Duration     // Offset from the real time while the clock is running
+	sigs    map[uint64][]byte // Signatures of chained rounds, which depend on each other
+}
+
+// Result is a beacon as served on /public/{round}
+type Result struct {
+	Round             uint64 `json:"round"`
+	Randomness        string `json:"randomness"`
+	Signature         string `json:"signature"`
+	PreviousSignature string `json:"previous_signature,omitempty"`
+}
+
+// New creates a beacon for the given scheme and period with a freshly generated keypair.
+// The clock starts at the current time, ten rounds after genesis.
+func New(schemeID string, period time.Duration) (*Beacon, error) {
+	scheme, err := dcrypto.SchemeFromName(schemeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if period < time.Second || period%time.Second != 0 {
+		return nil, fmt.Errorf("period must be a whole number of seconds, got %v", period)
+	}
+
+	now := time.Now().UTC()
+	genesis := now.Add(-10 * period).Unix()
+
+	private := scheme.KeyGroup.Scalar().Pick(random.New())
+	public := scheme.KeyGroup.Point().Mul(private, nil)
+
+	return &Beacon{
+		info:    drand.NewChainInfo(public, period, genesis, scheme),
+		private: private,
+		now:     now,
+		sigs:    make(map[uint64][]byte),
+	}, nil
+}
+
+// NewQuicknet creates a beacon with the scheme and period of the drand quicknet chain
+func NewQuicknet() *Beacon {
+	b, err := New(dcrypto.SigsOnG1ID, 3*time.Second)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create fake beacon: %v", err))
+	}
+	return b
+}
+
+// Info returns the parameters of the chain
+func (b *Beacon) Info() *drand.ChainInfo {
+	return b.info
+}
+
+// Now returns the current time of the beacon clock
+func (b *Beacon) Now() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.running {
+		return time.Now().UTC().Add(b.offset)
+	}
+	return b.now
+}
+
+// Advance moves the beacon clock forward
+func (b *Beacon) Advance(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.running {
+		b.offset += d
+		return
+	}
+	b.now = b.now.Add(d)
+}
+
+// Set sets the beacon clock to the given time
+func (b *Beacon) Set(t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.running {
+		b.offset = t.Sub(time.Now())
+		return
+	}
+	b.now = t
+}
+
+// Run makes the beacon clock follow the real time from its current value,
+// so that rounds are produced as on a live network
+func (b *Beacon) Run() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.running {
+		b.offset = b.now.Sub(time.Now())
+		b.running = true
+	}
+}
+
+// LatestRound returns the latest round produced at the current time of the beacon clock
+func (b *Beacon) LatestRound() uint64 {
+	return b.info.RoundAt(b.Now())
+}
+
+// FetchSignature returns the signature of a round that has already been produced
+func (b *Beacon) FetchSignature(round uint64) ([]byte, error) {
+	result, err := b.Get(round)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(result.Signature)
+}
+
+// Get returns a produced round, or the latest round if round is 0
+func (b *Beacon) Get(round uint64) (Result, error) {
+	latest := b.LatestRound()
+	if round == 0 {
+		round = latest
+	}
+	if round > latest {
+		return Result{}, fmt.Errorf("%w: %d", ErrFutureRound, round)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sig, err := b.sign(round)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{
+		Round:      round,
+		Randomness: hex.EncodeToString(dcrypto.RandomnessFromSignature(sig)),
+		Signature:  hex.EncodeToString(sig),
+	}
+	if b.chained() {
+		prev, err := b.sign(round - 1)
+		if err != nil {
+			return Result{}, err
+		}
+		result.PreviousSignature = hex.EncodeToString(prev)
+	}
+
+	return result, nil
+}
+
+// chained reports whether each round signature depends on the previous one
+func (b *Beacon) chained() bool {
+	return b.info.Scheme.Name == dcrypto.DefaultSchemeID
+}
+
+// sign returns the signature of a round. Must be called with the lock held.
+func (b *Beacon) sign(round uint64) ([]byte, error) {
+	if !b.chained() {
+		return b.info.Scheme.AuthScheme.Sign(b.private, b.info.Scheme.DigestBeacon(&chain.Beacon{Round: round}))
+	}
+
+	// Round 0 carries the genesis seed, which is the previous signature of round 1
+	if round == 0 {
+		return b.info.GenesisSeed, nil
+	}
+	if sig, ok := b.sigs[round]; ok {
+		return sig, nil
+	}
+
+	prev, err := b.sign(round - 1)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := b.info.Scheme.AuthScheme.Sign(b.private, b.info.Scheme.DigestBeacon(&chain.Beacon{
+		Round:       round,
+		PreviousSig: prev,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	b.sigs[round] = sig
+	return sig, nil
+}
+
+// Handler returns an HTTP handler serving the drand HTTP API for the chain.
+// Routes are served both at the root and under the chain hash prefix.
+func (b *Beacon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, prefix := range []string{"", "/" + b.info.HashString()} {
+		mux.HandleFunc("GET "+prefix+"/info", b.handleInfo)
+		mux.HandleFunc("GET "+prefix+"/public/latest", b.handleLatest)
+		mux.HandleFunc("GET "+prefix+"/public/{round}", b.handleRound)
+	}
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+// StartServer starts an httptest server serving the beacon. The caller must close it.
+func (b *Beacon) StartServer() *httptest.Server {
+	return httptest.NewServer(b.Handler())
+}
+
+// handleInfo serves the chain info
+func (b *Beacon) handleInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, b.info)
+}
+
+// handleLatest serves the latest round
+func (b *Beacon) handleLatest(w http.ResponseWriter, r *http.Request) {
+	b.serveRound(w, 0)
+}
+
+// handleRound serves a specific round
+func (b *Beacon) handleRound(w http.ResponseWriter, r *http.Request) {
+	round, err := strconv.ParseUint(r.PathValue("round"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid round", http.StatusBadRequest)
+		return
+	}
+	b.serveRound(w, round)
+}
+
+// serveRound writes a round, or 404 if it has not been produced yet
+func (b *Beacon) serveRound(w http.ResponseWriter, round uint64) {
+	result, err := b.Get(round)
+	if err != nil {
+		if errors.Is(err, ErrFutureRound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, result)
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,115 @@
+package fake_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	dcrypto "github.com/drand/drand/crypto"
+	"github.com/korjavin/drand-poc/internal/crypt/crypto"
+	"github.com/korjavin/drand-poc/internal/crypt/drand"
+	"github.com/korjavin/drand-poc/internal/crypt/drand/fake"
+)
+
+// The fake beacon can be used directly as the client of a Locker
+var _ crypto.Client = (*fake.Beacon)(nil)
+
+func TestFetchSignatureFutureRound(t *testing.T) {
+	beacon := fake.NewQuicknet()
+	next := beacon.LatestRound() + 1
+
+	if _, err := beacon.FetchSignature(next); !errors.Is(err, fake.ErrFutureRound) {
+		t.Fatalf("Expected ErrFutureRound, got: %v", err)
+	}
+
+	beacon.Advance(beacon.Info().Period)
+
+	if _, err := beacon.FetchSignature(next); err != nil {
+		t.Fatalf("Failed to fetch signature after advancing the clock: %v", err)
+	}
+}
+
+func TestLockerWithFakeBeacon(t *testing.T) {
+	beacon := fake.NewQuicknet()
+	locker := crypto.NewLocker(beacon, crypto.WithClock(beacon.Now))
+	plaintext := []byte("This is a secret message")
+
+	ciphertext, _, round, err := locker.Encrypt(plaintext, beacon.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	if _, err := locker.Decrypt(ciphertext, round); err != crypto.ErrTooEarly {
+		t.Fatalf("Expected ErrTooEarly, got: %v", err)
+	}
+
+	beacon.Advance(time.Hour + beacon.Info().Period)
+
+	decrypted, err := locker.Decrypt(ciphertext, round)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("Decrypted text doesn't match original. Got: %s, Want: %s", decrypted, plaintext)
+	}
+}
+
+func TestServeOverHTTP(t *testing.T) {
+	for _, schemeID := range []string{dcrypto.SigsOnG1ID, dcrypto.UnchainedSchemeID, dcrypto.DefaultSchemeID} {
+		t.Run(schemeID, func(t *testing.T) {
+			beacon, err := fake.New(schemeID, time.Second)
+			if err != nil {
+				t.Fatalf("Failed to create beacon: %v", err)
+			}
+
+			server := beacon.StartServer()
+			defer server.Close()
+
+			// The chain info served on /info matches the beacon
+			info, err := drand.FetchChainInfo(t.Context(), server.URL, beacon.Info().HashString())
+			if err != nil {
+				t.Fatalf("Failed to fetch chain info: %v", err)
+			}
+
+			client, err := drand.NewClient(info, server.URL)
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+
+			round := beacon.LatestRound()
+			got, err := client.FetchSignature(round)
+			if err != nil {
+				t.Fatalf("Failed to fetch signature over HTTP: %v", err)
+			}
+
+			want, err := beacon.FetchSignature(round)
+			if err != nil {
+				t.Fatalf("Failed to fetch signature: %v", err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("Unexpected signature. Got: %x, Want: %x", got, want)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	beacon, err := fake.New(dcrypto.SigsOnG1ID, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create beacon: %v", err)
+	}
+
+	frozen := beacon.Now()
+	time.Sleep(10 * time.Millisecond)
+	if !beacon.Now().Equal(frozen) {
+		t.Fatalf("Expected the clock to be frozen")
+	}
+
+	beacon.Run()
+	time.Sleep(10 * time.Millisecond)
+	if !beacon.Now().After(frozen) {
+		t.Errorf("Expected the clock to follow the real time after Run")
+	}
+}
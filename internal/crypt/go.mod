@@ -2,18 +2,21 @@ module github.com/korjavin/drand-poc/internal/crypt
 
 go 1.24.2
 
-require github.com/drand/drand v1.5.10
+require (
+	filippo.io/age v1.2.1
+	github.com/drand/drand v1.5.10
+	github.com/drand/kyber v1.2.0
+	github.com/drand/kyber-bls12381 v0.3.1
+	github.com/hashicorp/golang-lru v0.5.4
+)
 
 require (
 	github.com/BurntSushi/toml v1.3.0 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
-	github.com/drand/kyber v1.2.0 // indirect
-	github.com/drand/kyber-bls12381 v0.3.1 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/hashicorp/errwrap v1.1.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
-	github.com/hashicorp/golang-lru v0.5.4 // indirect
 	github.com/kilic/bls12-381 v0.1.0 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
 	github.com/nikkolasg/hexjson v0.1.0 // indirect
@@ -23,10 +26,10 @@ require (
 	github.com/prometheus/procfs v0.11.1 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	go.uber.org/zap v1.25.0 // indirect
-	golang.org/x/crypto v0.12.0 // indirect
-	golang.org/x/net v0.14.0 // indirect
-	golang.org/x/sys v0.11.0 // indirect
-	golang.org/x/text v0.12.0 // indirect
+	golang.org/x/crypto v0.24.0 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20230530153820-e85fd2cbaebc // indirect
 	google.golang.org/grpc v1.55.0 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/korjavin/drand-poc/api"
+	"github.com/korjavin/drand-poc/internal/crypt/crypto"
+	"github.com/korjavin/drand-poc/storage"
+)
+
+// maxApprovers is the largest number of approvers of a note
+const maxApprovers = 16
+
+// errNotApproved is returned when an unlocked note is read before enough approvers released their share
+var errNotApproved = errors.New("waiting for approvals")
+
+// approvalThreshold returns the number of approvals a new note needs, 0 for none
+func approvalThreshold(req api.CreateNoteRequest) (uint32, error) {
+	switch {
+	case req.Approvers == 0 && req.Approvals == 0:
+		return 0, nil
+	case req.Approvers == 0:
+		return 0, errors.New("approvals needs approvers")
+	case req.Approvers > maxApprovers:
+		return 0, fmt.Errorf("a note has at most %d approvers", maxApprovers)
+	case req.Approvals > req.Approvers:
+		return 0, fmt.Errorf("approvals can't exceed the %d approvers", req.Approvers)
+	case len(req.Ciphertext) > 0:
+		return 0, errors.New("approvers need the server to encrypt the text")
+	case req.Passphrase != "":
+		return 0, errors.New("approvers can't be combined with a passphrase")
+	case len(req.Recipients) > 0:
+		// Recipients decrypt the note themselves, without the shares held by the server
+		return 0, errors.New("approvers can't be combined with recipients")
+	case req.Approvals == 0:
+		return req.Approvers, nil
+	}
+	return req.Approvals, nil
+}
+
+// sealApprovals encrypts a payload with a data key shared among new approvers, any threshold
+// of which must release their share to decrypt it. Each share is wrapped with the approval token
+// of its approver, so the store alone can't decrypt the note. It returns the payload to timelock,
+// the approvers to store with the note and their tokens.
+func sealApprovals(payload []byte, approvers, threshold uint32) ([]byte, []storage.Approver, []string, error) {
+	sealed, shares, err := crypto.SealApproval(payload, int(approvers), int(threshold))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	stored := make([]storage.Approver, len(shares))
+	tokens := make([]string, len(shares))
+	for i, share := range shares {
+		token, hash, err := newToken()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		wrapped, err := crypto.WrapShare(share, token)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		stored[i] = storage.Approver{TokenHash: hash, Share: wrapped}
+		tokens[i] = token
+	}
+	return sealed, stored, tokens, nil
+}
+
+// openApprovals decrypts the approval layer of a decrypted note, if it has one
+func openApprovals(note storage.Note, plaintext []byte) ([]byte, error) {
+	if note.Approvals == 0 {
+		return plaintext, nil
+	}
+	return crypto.OpenApproval(plaintext, note.Released())
+}
+
+// handleApproveNote handles the POST /api/note/{id}/{h}/approve endpoint.
+// The request must carry one of the approval tokens returned when the note was created:
+// Authorization: Bearer <token>. Approving twice is not an error.
+func (s *Server) handleApproveNote(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Context().Value(requestIDKey).(string)
+	logger := s.logger.With("request_id", requestID)
+
+	// Extract the ID and hash from the URL
+	id := r.PathValue("id")
+	hash := r.PathValue("h")
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		http.Error(w, "Missing approval token", http.StatusUnauthorized)
+		return
+	}
+
+	note, err := s.getNote(r.Context(), id, hash)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Info("Note not found", "id", id, "hash", hash)
+			http.Error(w, "Note not found", http.StatusNotFound)
+		} else {
+			logger.Error("Failed to get note", "error", err, "id", id, "hash", hash)
+			http.Error(w, "Failed to get note", http.StatusInternalServerError)
+		}
+		return
+	}
+	if note.Approvals == 0 {
+		http.Error(w, "This note doesn't need approvals", http.StatusConflict)
+		return
+	}
+
+	// Find the approver of the token, comparing all the hashes in constant time
+	approver := -1
+	for i, a := range note.Approvers {
+		if subtle.ConstantTimeCompare([]byte(tokenHash(token)), []byte(a.TokenHash)) == 1 {
+			approver = i
+		}
+	}
+	if approver < 0 {
+		logger.Info("Invalid approval token", "id", id, "hash", hash)
+		http.Error(w, "Invalid approval token", http.StatusForbidden)
+		return
+	}
+
+	var share []byte
+	if a := note.Approvers[approver]; !a.Released {
+		if share, err = crypto.UnwrapShare(a.Share, token); err != nil {
+			logger.Error("Failed to unwrap approver share", "error", err, "id", id, "hash", hash)
+			http.Error(w, "Failed to approve note", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	note, err = s.store.Update(r.Context(), id, hash, func(n *storage.Note) error {
+		if !n.Approvers[approver].Released {
+			n.Approvers[approver].Share = share
+			n.Approvers[approver].Released = true
+		}
+		return nil
+	})
+	if err != nil {
+		if err == storage.ErrNotFound {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to approve note", "error", err, "id", id, "hash", hash)
+		http.Error(w, "Failed to approve note", http.StatusInternalServerError)
+		return
+	}
+
+	resp := api.ApproveNoteResponse{
+		Approvals:         uint32(len(note.Released())),
+		ApprovalsRequired: note.Approvals,
+	}
+	logger.Info("Approved note", "id", id, "hash", hash, "approvals", resp.Approvals, "required", resp.ApprovalsRequired)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Error("Failed to encode response", "error", err)
+	}
+}
+
+// renderAwaitingApproval renders the page of an unlocked note that still needs approvals
+func (s *Server) renderAwaitingApproval(w http.ResponseWriter, logger *slog.Logger, note storage.Note) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+
+	tmpl := template.Must(template.New("awaiting_approval").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Note Awaiting Approval</title>
+    <link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/water.css@2/out/water.css">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+</head>
+<body>
+    <h1>Note Awaiting Approval</h1>
+    <p>This note was unlocked at {{.UnlockTime}}, but it can only be read once {{.Required}} approvers released their share of its key.</p>
+    <p>Approvals so far: {{.Approvals}} of {{.Required}}.</p>
+    <p><small>It will be deleted at {{.ExpiresAt}}.</small></p>
+</body>
+</html>
+`))
+
+	data := struct {
+		UnlockTime string
+		ExpiresAt  string
+		Approvals  int
+		Required   uint32
+	}{
+		UnlockTime: note.UnlockAt.Format(time.RFC1123),
+		ExpiresAt:  note.Expiry().Format(time.RFC1123),
+		Approvals:  len(note.Released()),
+		Required:   note.Approvals,
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		logger.Error("Failed to render template", "error", err)
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/korjavin/drand-poc/api"
+	"github.com/korjavin/drand-poc/internal/crypt/crypto"
+	"github.com/korjavin/drand-poc/storage"
+)
+
+// Bounds of the check-in interval of dead man's switch notes
+const (
+	minCheckinInterval = time.Minute
+	maxCheckinInterval = 366 * 24 * time.Hour
+)
+
+// checkinScanInterval is how often the server releases the dead man's switch notes whose owner
+// missed a check-in, unless set with WithCheckinScan
+const checkinScanInterval = time.Minute
+
+// errCheckedIn is returned when a note to release was checked in meanwhile
+var errCheckedIn = errors.New("note checked in")
+
+// errAlreadyUnlocked is returned when the owner of a note checks in after it unlocked
+var errAlreadyUnlocked = errors.New("note already unlocked")
+
+// checkinInterval parses the check-in interval of a new note, 0 for notes without check-ins.
+// The server timelocks the note again on each check-in, so it must encrypt the text itself.
+func checkinInterval(req api.CreateNoteRequest) (time.Duration, error) {
+	if req.CheckinInterval == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(req.CheckinInterval)
+	switch {
+	case err != nil:
+		return 0, fmt.Errorf("invalid duration %q", req.CheckinInterval)
+	case interval < minCheckinInterval:
+		return 0, fmt.Errorf("the minimum is %s", minCheckinInterval)
+	case interval > maxCheckinInterval:
+		return 0, fmt.Errorf("the maximum is %s", maxCheckinInterval)
+	case len(req.Ciphertext) > 0:
+		return 0, errors.New("check-ins need the server to encrypt the text")
+	case len(req.Recipients) > 0:
+		// The recipient stanzas can't be added again, the server doesn't keep their keys
+		return 0, errors.New("check-ins can't be combined with recipients")
+	}
+	return interval, nil
+}
+
+// handleCheckin handles the POST /api/note/{id}/{h}/checkin endpoint of dead man's switch notes.
+// The request must carry the delete token of the note: Authorization: Bearer <token>.
+// Until the note unlocks, it is timelocked again to its check-in interval from now, and its expiry
+// moves as much. Check-ins never move the unlock time earlier: when the note is already locked
+// to a later round, e.g. after an extension, the check-in fails with 409 Conflict.
+func (s *Server) handleCheckin(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Context().Value(requestIDKey).(string)
+	logger := s.logger.With("request_id", requestID)
+
+	// Extract the ID and hash from the URL
+	id := r.PathValue("id")
+	hash := r.PathValue("h")
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		http.Error(w, "Missing delete token", http.StatusUnauthorized)
+		return
+	}
+
+	note, err := s.getNote(r.Context(), id, hash)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Info("Note not found", "id", id, "hash", hash)
+			http.Error(w, "Note not found", http.StatusNotFound)
+		} else {
+			logger.Error("Failed to get note", "error", err, "id", id, "hash", hash)
+			http.Error(w, "Failed to get note", http.StatusInternalServerError)
+		}
+		return
+	}
+	if note.CheckinInterval == 0 {
+		http.Error(w, "This note doesn't take check-ins", http.StatusConflict)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(tokenHash(token)), []byte(note.TokenHash)) != 1 {
+		logger.Info("Invalid delete token", "id", id, "hash", hash)
+		http.Error(w, "Invalid delete token", http.StatusForbidden)
+		return
+	}
+	if s.isUnlocked(note) {
+		http.Error(w, "This note is already unlocked", http.StatusConflict)
+		return
+	}
+
+	// Timelock the payload again, from the owner copy only the token opens
+	payload, err := crypto.OpenOwner(note.OwnerCipher, token)
+	if err != nil {
+		logger.Error("Failed to open owner copy", "error", err, "id", id, "hash", hash)
+		http.Error(w, "Failed to check in", http.StatusInternalServerError)
+		return
+	}
+	unlockAt := s.locker.Now().Add(note.CheckinInterval)
+	cipher, _, round, err := s.locker.Encrypt(payload, unlockAt)
+	if err != nil {
+		logger.Error("Failed to encrypt note", "error", err, "id", id, "hash", hash)
+		http.Error(w, "Failed to check in", http.StatusInternalServerError)
+		return
+	}
+
+	note, err = s.store.Update(r.Context(), id, hash, func(n *storage.Note) error {
+		// An extension or a concurrent check-in may have locked the note to a later round already
+		if round <= n.Round {
+			return errNotLater
+		}
+		if s.isUnlocked(*n) {
+			return errAlreadyUnlocked
+		}
+		n.ExpiresAt = n.Expiry().Add(unlockAt.Sub(n.UnlockAt))
+		n.Cipher = cipher
+		n.Round = round
+		n.UnlockAt = unlockAt
+		return nil
+	})
+	switch {
+	case err == storage.ErrNotFound:
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	case err == errAlreadyUnlocked:
+		http.Error(w, "This note is already unlocked", http.StatusConflict)
+		return
+	case err == errNotLater:
+		http.Error(w, "This note is already locked past the check-in interval", http.StatusConflict)
+		return
+	case err != nil:
+		logger.Error("Failed to check in", "error", err, "id", id, "hash", hash)
+		http.Error(w, "Failed to check in", http.StatusInternalServerError)
+		return
+	}
+
+	resp := api.LockNoteResponse{
+		UnlockAt:  note.UnlockAt.Format(time.RFC3339),
+		ExpiresAt: note.Expiry().Format(time.RFC3339),
+		Round:     note.Round,
+	}
+	logger.Info("Checked in", "id", id, "hash", hash, "unlock_at", note.UnlockAt, "round", note.Round)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Error("Failed to encode response", "error", err)
+	}
+}
+
+// WithCheckinScan sets how often the server looks for dead man's switch notes whose owner missed a
+// check-in, to release them. Default: every minute.
+func WithCheckinScan(interval time.Duration) Option {
+	return func(s *Server) {
+		s.checkinScan = interval
+	}
+}
+
+// scheduleCheckins releases the dead man's switch notes whose owner missed a check-in,
+// every checkinScan until ctx is done
+func (s *Server) scheduleCheckins(ctx context.Context) {
+	ticker := time.NewTicker(s.checkinScan)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			released, err := s.releaseMissedCheckins(ctx)
+			if err != nil {
+				// Notes left are released on the next tick
+				s.logger.Error("Failed to release notes with missed check-ins", "error", err)
+			}
+			if released > 0 {
+				s.logger.Info("Released notes with missed check-ins", "count", released)
+			}
+		}
+	}
+}
+
+// releaseMissedCheckins releases the dead man's switch notes that unlocked because their owner
+// missed a check-in, and returns how many were released. A released note drops its owner copy and
+// its check-in interval: its owner can no longer lock it again, and it can be exported like the
+// other unlocked notes.
+func (s *Server) releaseMissedCheckins(ctx context.Context) (int, error) {
+	// The store can't be called while it scans, so the notes are collected first
+	var missed []storage.Note
+	err := s.store.Scan(ctx, func(n storage.Note) error {
+		if n.CheckinInterval > 0 && s.isUnlocked(n) {
+			missed = append(missed, n)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, note := range missed {
+		_, err := s.store.Update(ctx, note.ID, note.Hash, func(n *storage.Note) error {
+			if n.CheckinInterval == 0 || !s.isUnlocked(*n) {
+				return errCheckedIn
+			}
+			n.CheckinInterval = 0
+			n.OwnerCipher = nil
+			return nil
+		})
+		switch {
+		case err == storage.ErrNotFound || err == errCheckedIn:
+		case err != nil:
+			return released, err
+		default:
+			s.logger.Info("Released note with a missed check-in", "id", note.ID, "hash", note.Hash, "unlock_at", note.UnlockAt)
+			released++
+		}
+	}
+	return released, nil
+}
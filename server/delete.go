@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/korjavin/drand-poc/storage"
+)
+
+// tokenSize is the number of random bytes of delete and approval tokens
+const tokenSize = 32
+
+// newToken returns a random token for the creator or an approver of a note, and the hash stored with the note
+func newToken() (token, hash string, err error) {
+	b := make([]byte, tokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, tokenHash(token), nil
+}
+
+// tokenHash returns the hex SHA-256 of a token, so the store never holds the token itself
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// handleDeleteNote handles the DELETE /api/note/{id}/{h} endpoint.
+// The request must carry the token returned when the note was created: Authorization: Bearer <token>.
+func (s *Server) handleDeleteNote(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Context().Value(requestIDKey).(string)
+	logger := s.logger.With("request_id", requestID)
+
+	// Extract the ID and hash from the URL
+	id := r.PathValue("id")
+	hash := r.PathValue("h")
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		http.Error(w, "Missing delete token", http.StatusUnauthorized)
+		return
+	}
+
+	note, err := s.getNote(r.Context(), id, hash)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Info("Note not found", "id", id, "hash", hash)
+			http.Error(w, "Note not found", http.StatusNotFound)
+		} else {
+			logger.Error("Failed to get note", "error", err, "id", id, "hash", hash)
+			http.Error(w, "Failed to get note", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Notes created before delete tokens existed have no hash, and can't be deleted
+	if note.TokenHash == "" || subtle.ConstantTimeCompare([]byte(tokenHash(token)), []byte(note.TokenHash)) != 1 {
+		logger.Info("Invalid delete token", "id", id, "hash", hash)
+		http.Error(w, "Invalid delete token", http.StatusForbidden)
+		return
+	}
+
+	if err := s.store.Delete(r.Context(), id, hash); err != nil {
+		if err == storage.ErrNotFound {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to delete note", "error", err, "id", id, "hash", hash)
+		http.Error(w, "Failed to delete note", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("Deleted note", "id", id, "hash", hash)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/korjavin/drand-poc/api"
+	"github.com/korjavin/drand-poc/internal/crypt/crypto"
+	"github.com/korjavin/drand-poc/storage"
+)
+
+// errNotLater is returned when a note would not be locked to a later round than its current one
+var errNotLater = errors.New("the new unlock time must be later than the current one")
+
+// handleExtendNote handles the PATCH /api/note/{id}/{h} endpoint.
+// The request must carry the delete token of the note: Authorization: Bearer <token>.
+// The note gets a new link, returned in the response, and its previous link returns 404 Not Found.
+// Its expiry moves as much as its unlock time.
+// Unlocked notes can be locked again, although their readers may have kept them.
+func (s *Server) handleExtendNote(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Context().Value(requestIDKey).(string)
+	logger := s.logger.With("request_id", requestID)
+
+	// Extract the ID and hash from the URL
+	id := r.PathValue("id")
+	hash := r.PathValue("h")
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		http.Error(w, "Missing delete token", http.StatusUnauthorized)
+		return
+	}
+
+	var req api.ExtendNoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Error("Failed to decode request body", "error", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	note, err := s.getNote(r.Context(), id, hash)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Info("Note not found", "id", id, "hash", hash)
+			http.Error(w, "Note not found", http.StatusNotFound)
+		} else {
+			logger.Error("Failed to get note", "error", err, "id", id, "hash", hash)
+			http.Error(w, "Failed to get note", http.StatusInternalServerError)
+		}
+		return
+	}
+	if note.TokenHash == "" || subtle.ConstantTimeCompare([]byte(tokenHash(token)), []byte(note.TokenHash)) != 1 {
+		logger.Info("Invalid delete token", "id", id, "hash", hash)
+		http.Error(w, "Invalid delete token", http.StatusForbidden)
+		return
+	}
+
+	// Encrypt the note again, or accept the note encrypted again by the client
+	var relocked storage.Note
+	if len(req.Ciphertext) > 0 {
+		relocked, ok = s.acceptRelock(w, logger, note, req)
+	} else {
+		relocked, ok = s.relockOwnerCopy(w, logger, note, req.UnlockAt, token)
+	}
+	if !ok {
+		return
+	}
+
+	// The round of a client ciphertext was checked against its tlock stanza, so the ciphertext
+	// can't be read before the current unlock round
+	if relocked.Round <= note.Round {
+		http.Error(w, errNotLater.Error(), http.StatusBadRequest)
+		return
+	}
+
+	note, err = s.store.Update(r.Context(), id, hash, func(n *storage.Note) error {
+		// A concurrent check-in or extension may have locked the note to a later round already
+		if relocked.Round <= n.Round {
+			return errNotLater
+		}
+		n.ExpiresAt = n.Expiry().Add(relocked.UnlockAt.Sub(n.UnlockAt))
+		n.Hash = relocked.Hash
+		n.Cipher = relocked.Cipher
+		n.Round = relocked.Round
+		n.UnlockAt = relocked.UnlockAt
+		n.Version = byte(crypto.FormatAge)
+		return nil
+	})
+	switch {
+	case err == storage.ErrNotFound:
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	case err == errNotLater:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		logger.Error("Failed to extend note", "error", err, "id", id, "hash", hash)
+		http.Error(w, "Failed to extend note", http.StatusInternalServerError)
+		return
+	}
+
+	resp := api.LockNoteResponse{
+		UnlockAt:  note.UnlockAt.Format(time.RFC3339),
+		ExpiresAt: note.Expiry().Format(time.RFC3339),
+		Round:     note.Round,
+		URL:       fmt.Sprintf("%s/note/%s/%s", s.baseDomain, note.ID, note.Hash),
+	}
+	logger.Info("Extended note", "id", id, "hash", hash, "new_hash", note.Hash, "unlock_at", note.UnlockAt, "round", note.Round)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Error("Failed to encode response", "error", err)
+	}
+}
+
+// acceptRelock validates a note encrypted again by the client, which must hold the whole payload:
+// notes with layers or copies kept by the server can't be replaced by the client.
+// On failure it writes the error response and returns false.
+func (s *Server) acceptRelock(w http.ResponseWriter, logger *slog.Logger, note storage.Note, req api.ExtendNoteRequest) (storage.Note, bool) {
+	reason := notRelockable(note)
+	if len(note.OwnerCipher) > 0 {
+		reason = "The server encrypts this note again itself: only send unlock_at"
+	}
+	if reason != "" {
+		logger.Error("Ciphertext for a note the client can't encrypt again", "id", note.ID, "hash", note.Hash)
+		http.Error(w, reason, http.StatusConflict)
+		return storage.Note{}, false
+	}
+
+	return s.acceptCiphertext(w, logger, api.CreateNoteRequest{
+		UnlockAt:   req.UnlockAt,
+		Ciphertext: req.Ciphertext,
+		Round:      req.Round,
+	})
+}
+
+// notRelockable returns why the client can't encrypt a note again, if it can't: the server holds
+// the key of its file, its passphrase check or its approver shares
+func notRelockable(note storage.Note) string {
+	switch {
+	case len(note.Meta) > 0:
+		return "Notes with a file can't be extended"
+	case note.Passphrase:
+		return "This note has a passphrase but no owner copy on the server, so it can't be extended"
+	case note.Approvals > 0:
+		return "This note needs approvals but has no owner copy on the server, so it can't be extended"
+	}
+	return ""
+}
+
+// relockOwnerCopy encrypts the owner copy of a note to a later unlock time.
+// On failure it writes the error response and returns false.
+func (s *Server) relockOwnerCopy(w http.ResponseWriter, logger *slog.Logger, note storage.Note, value, token string) (storage.Note, bool) {
+	if len(note.OwnerCipher) == 0 {
+		reason := notRelockable(note)
+		if reason == "" {
+			reason = "The server has no owner copy of this note: send its ciphertext locked to the new round"
+		}
+		http.Error(w, reason, http.StatusConflict)
+		return storage.Note{}, false
+	}
+	unlockAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		logger.Error("Invalid unlock_at format", "error", err)
+		http.Error(w, "Invalid unlock_at format. Use RFC3339 format (e.g., 2023-01-01T12:00:00Z)", http.StatusBadRequest)
+		return storage.Note{}, false
+	}
+	if info := s.locker.Info(); info.RoundFor(unlockAt) <= info.RoundAt(s.locker.Now()) {
+		logger.Error("unlock_at is not in the future", "unlock_at", unlockAt)
+		http.Error(w, "unlock_at must be in the future", http.StatusBadRequest)
+		return storage.Note{}, false
+	}
+
+	payload, err := crypto.OpenOwner(note.OwnerCipher, token)
+	if err != nil {
+		logger.Error("Failed to open owner copy", "error", err, "id", note.ID, "hash", note.Hash)
+		http.Error(w, "Failed to extend note", http.StatusInternalServerError)
+		return storage.Note{}, false
+	}
+	cipher, hash, round, err := s.locker.Encrypt(payload, unlockAt)
+	if err != nil {
+		logger.Error("Failed to encrypt note", "error", err, "id", note.ID, "hash", note.Hash)
+		http.Error(w, "Failed to extend note", http.StatusInternalServerError)
+		return storage.Note{}, false
+	}
+
+	return storage.Note{
+		Hash:     hex.EncodeToString(hash),
+		Cipher:   cipher,
+		Round:    round,
+		UnlockAt: unlockAt,
+	}, true
+}
@@ -0,0 +1,435 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/korjavin/drand-poc/api"
+	"github.com/korjavin/drand-poc/internal/crypt/crypto"
+	"github.com/korjavin/drand-poc/storage"
+)
+
+// Limits of file uploads
+const (
+	maxFileSize     = 64 << 20 // Largest file accepted by POST /api/file
+	maxFormOverhead = 64 << 10 // Room for the other form fields and the part headers
+	maxFileNameSize = 255      // Longest file name kept, in bytes
+)
+
+// handleCreateFile handles the POST /api/file endpoint.
+// The request is a multipart form with an unlock_at field and optional retention, max_views,
+// burn_after_reading and recipient fields, followed by a file part. recipient may be repeated.
+// Other fields are rejected, so that options of text notes aren't silently ignored.
+// The file is encrypted while it is read, so the plaintext is never held in memory.
+func (s *Server) handleCreateFile(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Context().Value(requestIDKey).(string)
+	logger := s.logger.With("request_id", requestID)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxFileSize+maxFormOverhead)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		logger.Error("Invalid multipart request", "error", err)
+		http.Error(w, "Expected a multipart/form-data request", http.StatusBadRequest)
+		return
+	}
+
+	var unlockAt time.Time
+	retention := s.defaultRetention
+	var maxViews uint32
+	var burnAfterReading bool
+	var recipientKeys []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			logger.Error("No file in request")
+			http.Error(w, "Missing file", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to read multipart request", "error", err)
+			http.Error(w, "Invalid multipart request", requestErrorStatus(err))
+			return
+		}
+
+		switch part.FormName() {
+		case "unlock_at":
+			value, err := io.ReadAll(io.LimitReader(part, 64))
+			if err != nil {
+				logger.Error("Failed to read unlock_at", "error", err)
+				http.Error(w, "Invalid multipart request", requestErrorStatus(err))
+				return
+			}
+			unlockAt, err = time.Parse(time.RFC3339, string(value))
+			if err != nil {
+				logger.Error("Invalid unlock_at format", "error", err)
+				http.Error(w, "Invalid unlock_at format. Use RFC3339 format (e.g., 2023-01-01T12:00:00Z)", http.StatusBadRequest)
+				return
+			}
+			if info := s.locker.Info(); info.RoundFor(unlockAt) <= info.RoundAt(s.locker.Now()) {
+				logger.Error("unlock_at is not in the future", "unlock_at", unlockAt)
+				http.Error(w, "unlock_at must be in the future", http.StatusBadRequest)
+				return
+			}
+
+		case "retention":
+			value, err := io.ReadAll(io.LimitReader(part, 64))
+			if err != nil {
+				logger.Error("Failed to read retention", "error", err)
+				http.Error(w, "Invalid multipart request", requestErrorStatus(err))
+				return
+			}
+			if retention, err = s.retention(string(value)); err != nil {
+				logger.Error("Invalid retention", "error", err)
+				http.Error(w, "Invalid retention: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+		case "max_views":
+			value, err := io.ReadAll(io.LimitReader(part, 16))
+			if err != nil {
+				logger.Error("Failed to read max_views", "error", err)
+				http.Error(w, "Invalid multipart request", requestErrorStatus(err))
+				return
+			}
+			views, err := strconv.ParseUint(string(value), 10, 32)
+			if err != nil {
+				logger.Error("Invalid max_views", "error", err)
+				http.Error(w, "Invalid max_views", http.StatusBadRequest)
+				return
+			}
+			maxViews = uint32(views)
+
+		case "burn_after_reading":
+			value, err := io.ReadAll(io.LimitReader(part, 16))
+			if err != nil {
+				logger.Error("Failed to read burn_after_reading", "error", err)
+				http.Error(w, "Invalid multipart request", requestErrorStatus(err))
+				return
+			}
+			if burnAfterReading, err = strconv.ParseBool(string(value)); err != nil {
+				logger.Error("Invalid burn_after_reading", "error", err)
+				http.Error(w, "Invalid burn_after_reading", http.StatusBadRequest)
+				return
+			}
+
+		case "recipient":
+			value, err := io.ReadAll(io.LimitReader(part, maxRecipientLength))
+			if err != nil {
+				logger.Error("Failed to read recipient", "error", err)
+				http.Error(w, "Invalid multipart request", requestErrorStatus(err))
+				return
+			}
+			recipientKeys = append(recipientKeys, string(value))
+
+		case "passphrase":
+			logger.Error("Passphrase with a file")
+			http.Error(w, "Passphrases are only supported for text notes", http.StatusBadRequest)
+			return
+
+		case "approvers", "approvals":
+			logger.Error("Approvers with a file")
+			http.Error(w, "Approvers are only supported for text notes", http.StatusBadRequest)
+			return
+
+		case "checkin_interval":
+			logger.Error("Check-in interval with a file")
+			http.Error(w, "Check-ins are only supported for text notes", http.StatusBadRequest)
+			return
+
+		case "file":
+			if unlockAt.IsZero() {
+				logger.Error("File before unlock_at in request")
+				http.Error(w, "unlock_at must be sent before the file", http.StatusBadRequest)
+				return
+			}
+
+			maxViews, err = viewLimit(maxViews, burnAfterReading)
+			if err != nil {
+				logger.Error("Invalid view limit", "error", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			recipients, err := parseRecipients(recipientKeys, maxViews)
+			if err != nil {
+				logger.Error("Invalid recipients", "error", err)
+				http.Error(w, "Invalid recipients: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			token, err := s.deleteToken(w, logger)
+			if err != nil {
+				return
+			}
+
+			// The ciphertext is written to a temporary file, then streamed to the store
+			cipher, err := os.CreateTemp("", "drand-note-*.age")
+			if err != nil {
+				logger.Error("Failed to create temporary file", "error", err)
+				http.Error(w, "Failed to encrypt file", http.StatusInternalServerError)
+				return
+			}
+			defer os.Remove(cipher.Name())
+			defer cipher.Close()
+
+			note, size, ok := s.encryptFile(w, logger, part, cipher, unlockAt, recipients)
+			if !ok {
+				return
+			}
+			note.ExpiresAt = unlockAt.Add(retention)
+			note.MaxViews = maxViews
+			s.saveNoteWith(w, logger, note, token, nil, func(note storage.Note) error {
+				return s.store.SaveStream(r.Context(), note, cipher, size)
+			})
+			return
+
+		default:
+			logger.Error("Unknown field in request", "field", part.FormName())
+			http.Error(w, fmt.Sprintf("Unknown field %q", part.FormName()), http.StatusBadRequest)
+			return
+		}
+	}
+}
+
+// encryptFile encrypts a file part into cipher, and its attachment metadata, also to the
+// recipients. It returns the note without its ciphertext, and the size of the ciphertext,
+// which is left to be read from the start of cipher.
+// On failure it writes the error response and returns false.
+func (s *Server) encryptFile(w http.ResponseWriter, logger *slog.Logger, part *multipart.Part, cipher io.ReadWriteSeeker, unlockAt time.Time, recipients []crypto.Recipient) (storage.Note, int64, bool) {
+	// Sniff the type of files sent without a specific one
+	br := bufio.NewReaderSize(part, 512)
+	contentType := part.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType == "application/octet-stream" {
+		head, _ := br.Peek(512)
+		contentType = http.DetectContentType(head)
+	}
+
+	counter := &countingReader{r: br}
+	hash, round, err := s.locker.EncryptStream(cipher, counter, unlockAt, recipients...)
+	if err != nil {
+		logger.Error("Failed to encrypt file", "error", err)
+		http.Error(w, "Failed to encrypt file", requestErrorStatus(err))
+		return storage.Note{}, 0, false
+	}
+	size, err := cipher.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = cipher.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		logger.Error("Failed to rewind ciphertext", "error", err)
+		http.Error(w, "Failed to encrypt file", http.StatusInternalServerError)
+		return storage.Note{}, 0, false
+	}
+
+	attachment := api.Attachment{
+		Name: attachmentName(part.FileName()),
+		Type: contentType,
+		Size: counter.n,
+	}
+	data, err := json.Marshal(attachment)
+	if err != nil {
+		logger.Error("Failed to marshal attachment", "error", err)
+		http.Error(w, "Failed to encrypt file", http.StatusInternalServerError)
+		return storage.Note{}, 0, false
+	}
+	meta, _, err := crypto.Seal(s.locker.Info(), data, round, recipients...)
+	if err != nil {
+		logger.Error("Failed to encrypt attachment", "error", err)
+		http.Error(w, "Failed to encrypt file", http.StatusInternalServerError)
+		return storage.Note{}, 0, false
+	}
+
+	logger.Info("Encrypted file", "size", attachment.Size, "round", round)
+	return storage.Note{
+		Hash:     hex.EncodeToString(hash),
+		Meta:     meta,
+		Round:    round,
+		UnlockAt: unlockAt,
+	}, size, true
+}
+
+// attachmentName returns the base name of an uploaded file, without any client path
+func attachmentName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" {
+		return "file"
+	}
+	if len(name) > maxFileNameSize {
+		name = strings.ToValidUTF8(name[:maxFileNameSize], "")
+	}
+	return name
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read implements the io.Reader interface
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// requestErrorStatus returns the status code for an error while reading a request body
+func requestErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, multipart.ErrMessageTooLarge):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// openAttachment decrypts the attachment metadata of a note.
+// It returns nil for text notes and crypto.ErrTooEarly for locked notes.
+func (s *Server) openAttachment(note storage.Note) (*api.Attachment, error) {
+	if len(note.Meta) == 0 {
+		return nil, nil
+	}
+
+	data, err := s.locker.Decrypt(note.Meta, note.Round)
+	if err != nil {
+		return nil, err
+	}
+
+	var attachment api.Attachment
+	if err := json.Unmarshal(data, &attachment); err != nil {
+		return nil, fmt.Errorf("failed to parse attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+// getNote retrieves a note with the ciphertext of text notes. The ciphertext of a file is
+// left in the store, to be streamed by openFile, so that large files are never held in memory.
+func (s *Server) getNote(ctx context.Context, id, hash string) (storage.Note, error) {
+	note, cipher, err := s.store.GetStream(ctx, id, hash)
+	if err != nil {
+		return note, err
+	}
+	defer cipher.Close()
+
+	if len(note.Meta) == 0 {
+		if note.Cipher, err = io.ReadAll(cipher); err != nil {
+			return storage.Note{}, err
+		}
+	}
+	return note, nil
+}
+
+// handleDownloadNote handles the GET /note/{id}/{h}/download endpoint.
+// It streams the decrypted file of an unlocked note, or the text of a text note.
+func (s *Server) handleDownloadNote(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Context().Value(requestIDKey).(string)
+	logger := s.logger.With("request_id", requestID)
+
+	// Extract the ID and hash from the URL
+	id := r.PathValue("id")
+	hash := r.PathValue("h")
+
+	// Get the note from the store
+	note, err := s.getNote(r.Context(), id, hash)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Info("Note not found", "id", id, "hash", hash)
+			http.Error(w, "Note not found", http.StatusNotFound)
+		} else {
+			logger.Error("Failed to get note", "error", err, "id", id, "hash", hash)
+			http.Error(w, "Failed to get note", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Notes encrypted with a fragment key can only be read in the browser
+	if note.FragmentKey {
+		http.Error(w, "This note is decrypted in the browser and cannot be downloaded", http.StatusConflict)
+		return
+	}
+
+	// The passphrase is only given to the note page
+	if note.Passphrase {
+		http.Error(w, "This note is protected by a passphrase and cannot be downloaded", http.StatusConflict)
+		return
+	}
+
+	attachment, err := s.openAttachment(note)
+	if err == nil && attachment == nil {
+		s.downloadText(w, r, logger, note)
+		return
+	}
+
+	var plaintext io.ReadCloser
+	if err == nil {
+		plaintext, err = s.openFile(r.Context(), logger, note)
+	}
+	if err != nil {
+		if err == storage.ErrNotFound {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		if err == crypto.ErrTooEarly {
+			logger.Info("Too early to decrypt note", "id", id, "hash", hash, "unlock_at", note.UnlockAt)
+			http.Error(w, "This note is locked until "+note.UnlockAt.Format(time.RFC1123), http.StatusLocked)
+			return
+		}
+		logger.Error("Failed to decrypt note", "error", err, "id", id, "hash", hash)
+		http.Error(w, "Failed to decrypt note", http.StatusInternalServerError)
+		return
+	}
+
+	// The type is chosen by the uploader, so browsers must not render or sniff the file
+	w.Header().Set("Content-Type", attachment.Type)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	defer plaintext.Close()
+	if _, err := io.Copy(w, plaintext); err != nil {
+		logger.Error("Failed to stream file", "error", err, "id", id, "hash", hash)
+	}
+}
+
+// downloadText writes the decrypted text of a note as a text file
+func (s *Server) downloadText(w http.ResponseWriter, r *http.Request, logger *slog.Logger, note storage.Note) {
+	plaintext, _, err := s.readNote(r.Context(), logger, note, "")
+	if err != nil {
+		if err == storage.ErrNotFound {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		if err == crypto.ErrTooEarly {
+			http.Error(w, "This note is locked until "+note.UnlockAt.Format(time.RFC1123), http.StatusLocked)
+			return
+		}
+		if err == errNotApproved {
+			http.Error(w, "This note is waiting for approvals", http.StatusLocked)
+			return
+		}
+		logger.Error("Failed to decrypt note", "error", err, "id", note.ID, "hash", note.Hash)
+		http.Error(w, "Failed to decrypt note", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": note.ID + ".txt"}))
+	if _, err := w.Write(plaintext); err != nil {
+		logger.Error("Failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/korjavin/drand-poc/api"
+	"github.com/korjavin/drand-poc/internal/crypt/crypto"
+	"github.com/korjavin/drand-poc/storage"
+)
+
+// A note accepts passphraseAttempts attempts per passphraseWindow, counted in the store
+// so that they are shared by all the servers of a deployment. A correct passphrase resets them.
+const (
+	passphraseAttempts = 5
+	passphraseWindow   = 15 * time.Minute
+)
+
+// maxPassphraseLength bounds the passphrases given to scrypt
+const maxPassphraseLength = 1024
+
+// errPassphraseRequired is returned when an unlocked note is read without its passphrase
+var errPassphraseRequired = errors.New("passphrase required")
+
+// tooManyAttemptsError is returned when the passphrase attempts of a note are used up
+type tooManyAttemptsError struct {
+	retryAt time.Time
+}
+
+func (e *tooManyAttemptsError) Error() string {
+	return "too many passphrase attempts"
+}
+
+// checkPassphrase validates the passphrase of a new note, if any
+func checkPassphrase(req api.CreateNoteRequest) error {
+	switch {
+	case req.Passphrase == "":
+		return nil
+	case len(req.Passphrase) > maxPassphraseLength:
+		return fmt.Errorf("passphrase is longer than %d bytes", maxPassphraseLength)
+	case len(req.Ciphertext) > 0:
+		return errors.New("passphrase needs the server to encrypt the text")
+	case req.FragmentKey:
+		return errors.New("passphrase can't be combined with fragment_key")
+	case len(req.Recipients) > 0:
+		// Recipients read the exported note, whose passphrase could be guessed offline
+		return errors.New("passphrase can't be combined with recipients")
+	}
+	return nil
+}
+
+// countAttempt records a passphrase attempt for a note before it is checked,
+// so that concurrent attempts can't exceed the limit
+func (s *Server) countAttempt(ctx context.Context, note storage.Note) error {
+	_, err := s.store.Update(ctx, note.ID, note.Hash, func(n *storage.Note) error {
+		now := s.locker.Now()
+		if now.Sub(n.AttemptsSince) >= passphraseWindow {
+			n.Attempts = 0
+			n.AttemptsSince = now
+		}
+		if n.Attempts >= passphraseAttempts {
+			return &tooManyAttemptsError{retryAt: n.AttemptsSince.Add(passphraseWindow)}
+		}
+		n.Attempts++
+		return nil
+	})
+	return err
+}
+
+// resetAttempts forgets the passphrase attempts of a note once the passphrase was given
+func (s *Server) resetAttempts(ctx context.Context, logger *slog.Logger, note storage.Note) {
+	_, err := s.store.Update(ctx, note.ID, note.Hash, func(n *storage.Note) error {
+		n.Attempts = 0
+		return nil
+	})
+	// Notes deleted by their last view have no attempts left to reset
+	if err != nil && err != storage.ErrNotFound {
+		logger.Error("Failed to reset passphrase attempts", "error", err, "id", note.ID, "hash", note.Hash)
+	}
+}
+
+// writePassphraseError writes the API response of a failed passphrase check,
+// and reports whether err was one
+func (s *Server) writePassphraseError(w http.ResponseWriter, err error) bool {
+	var tooMany *tooManyAttemptsError
+	switch {
+	case errors.As(err, &tooMany):
+		w.Header().Set("Retry-After", s.retryAfter(tooMany.retryAt))
+		http.Error(w, "Too many passphrase attempts", http.StatusTooManyRequests)
+	case err == crypto.ErrWrongPassphrase:
+		http.Error(w, "Wrong passphrase", http.StatusForbidden)
+	default:
+		return false
+	}
+	return true
+}
+
+// retryAfter returns the seconds until a time, rounded up, for the Retry-After header
+func (s *Server) retryAfter(t time.Time) string {
+	return strconv.FormatInt(int64((t.Sub(s.locker.Now())+time.Second-1)/time.Second), 10)
+}
+
+// renderPassphrasePrompt renders the page asking for the passphrase of an unlocked note.
+// The form posts the passphrase to the same URL.
+func (s *Server) renderPassphrasePrompt(w http.ResponseWriter, logger *slog.Logger, note storage.Note, err error) {
+	status := http.StatusOK
+	message := ""
+	var tooMany *tooManyAttemptsError
+	switch {
+	case errors.As(err, &tooMany):
+		status = http.StatusTooManyRequests
+		message = "Too many attempts. Try again after " + tooMany.retryAt.Format(time.RFC1123) + "."
+		w.Header().Set("Retry-After", s.retryAfter(tooMany.retryAt))
+	case err == crypto.ErrWrongPassphrase:
+		status = http.StatusForbidden
+		message = "Wrong passphrase."
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	tmpl := template.Must(template.New("passphrase").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Note Unlocked</title>
+    <link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/water.css@2/out/water.css">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+</head>
+<body>
+    <h1>Note Unlocked</h1>
+    <p>This note is protected by a passphrase.</p>
+    {{if .Message}}<p><strong>{{.Message}}</strong></p>{{end}}
+    <form method="post">
+        <label for="passphrase">Passphrase:</label>
+        <input type="password" id="passphrase" name="passphrase" required autofocus>
+        <button type="submit">Open Note</button>
+    </form>
+    <p><small>This note was unlocked at {{.UnlockTime}}. It will be deleted at {{.ExpiresAt}}.</small></p>
+</body>
+</html>
+`))
+
+	data := struct {
+		Message    string
+		UnlockTime string
+		ExpiresAt  string
+	}{
+		Message:    message,
+		UnlockTime: note.UnlockAt.Format(time.RFC1123),
+		ExpiresAt:  note.Expiry().Format(time.RFC1123),
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		logger.Error("Failed to render template", "error", err)
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/korjavin/drand-poc/internal/crypt/crypto"
+)
+
+// maxRecipients is the largest number of recipients of a note
+const maxRecipients = 16
+
+// maxRecipientLength bounds the public keys read from multipart requests
+const maxRecipientLength = 128
+
+// parseRecipients parses the public keys of the recipients of a new note.
+// Recipients decrypt the exported note themselves, so they can't be combined with a view limit,
+// which only the server enforces.
+func parseRecipients(keys []string, maxViews uint32) ([]crypto.Recipient, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	if len(keys) > maxRecipients {
+		return nil, fmt.Errorf("a note has at most %d recipients", maxRecipients)
+	}
+	if maxViews > 0 {
+		return nil, fmt.Errorf("recipients can't be combined with a view limit")
+	}
+	return crypto.ParseRecipients(keys)
+}
@@ -1,11 +1,15 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
@@ -13,6 +17,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/korjavin/drand-poc/api"
 	"github.com/korjavin/drand-poc/internal/crypt/crypto"
 	"github.com/korjavin/drand-poc/storage"
 )
@@ -27,61 +32,82 @@ const (
 
 // Server represents the HTTP server
 type Server struct {
-	store      storage.Store
+	store      storage.StreamStore
+	locker     *crypto.Locker
 	logger     *slog.Logger
 	baseDomain string
 	staticDir  string
-	testMode   bool // Used for testing to bypass time checks
-}
 
-// NewServer creates a new HTTP server
-func NewServer(store storage.Store, logger *slog.Logger, baseDomain, staticDir string) *Server {
-	return &Server{
-		store:      store,
-		logger:     logger,
-		baseDomain: baseDomain,
-		staticDir:  staticDir,
-		testMode:   false,
-	}
+	defaultRetention time.Duration // How long notes are kept after unlock, unless their creator chose
+	maxRetention     time.Duration // Longest retention a creator can choose
+	checkinScan      time.Duration // How often notes with missed check-ins are released
 }
 
-// NewTestServer creates a new HTTP server in test mode
-func NewTestServer(store storage.Store, logger *slog.Logger, baseDomain, staticDir string) *Server {
-	return &Server{
-		store:      store,
-		logger:     logger,
-		baseDomain: baseDomain,
-		staticDir:  staticDir,
-		testMode:   true,
+// Option configures a Server
+type Option func(*Server)
+
+// WithRetention sets how long notes are kept after their unlock time when their creator
+// doesn't choose, and the longest time creators can choose. Both default to storage.Retention.
+func WithRetention(defaultRetention, maxRetention time.Duration) Option {
+	return func(s *Server) {
+		s.defaultRetention = defaultRetention
+		s.maxRetention = maxRetention
 	}
 }
 
-// CreateNoteRequest represents the request body for creating a new note
-type CreateNoteRequest struct {
-	Text     string `json:"text"`
-	UnlockAt string `json:"unlock_at"` // RFC3339 format
+// NewServer creates a new HTTP server
+func NewServer(store storage.Store, locker *crypto.Locker, logger *slog.Logger, baseDomain, staticDir string, opts ...Option) *Server {
+	s := &Server{
+		store:            storage.Streams(store),
+		locker:           locker,
+		logger:           logger,
+		baseDomain:       baseDomain,
+		staticDir:        staticDir,
+		defaultRetention: storage.Retention,
+		maxRetention:     storage.Retention,
+		checkinScan:      checkinScanInterval,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-// CreateNoteResponse represents the response body for creating a new note
-type CreateNoteResponse struct {
-	URL string `json:"url"`
-}
+// fragmentPayloadMinSize is the length of an empty note encrypted with a fragment key:
+// a 12-byte AES-GCM nonce and a 16-byte tag
+const fragmentPayloadMinSize = 12 + 16
 
-// Start starts the HTTP server
+// Start starts the HTTP server, and the release of dead man's switch notes with missed check-ins
 func (s *Server) Start(addr string) error {
 	mux := http.NewServeMux()
 
 	// API routes
 	mux.HandleFunc("POST /api/note", s.handleCreateNote)
+	mux.HandleFunc("POST /api/file", s.handleCreateFile)
+	mux.HandleFunc("GET /api/note/{id}/{h}", s.handleGetNoteAPI)
+	mux.HandleFunc("POST /api/note/{id}/{h}", s.handleGetNoteAPI)
+	mux.HandleFunc("GET /api/note/{id}/{h}/export", s.handleExportNote)
+	mux.HandleFunc("PATCH /api/note/{id}/{h}", s.handleExtendNote)
+	mux.HandleFunc("DELETE /api/note/{id}/{h}", s.handleDeleteNote)
+	mux.HandleFunc("POST /api/note/{id}/{h}/approve", s.handleApproveNote)
+	mux.HandleFunc("POST /api/note/{id}/{h}/checkin", s.handleCheckin)
+	mux.HandleFunc("GET /api/chain", s.handleGetChain)
 
 	// Static routes
 	mux.HandleFunc("GET /note/{id}/{h}", s.handleGetNote)
+	mux.HandleFunc("POST /note/{id}/{h}", s.handleGetNote)
+	mux.HandleFunc("GET /note/{id}/{h}/download", s.handleDownloadNote)
 	mux.HandleFunc("GET /", s.handleIndex)
 
 	// Static files
 	fs := http.FileServer(http.Dir(s.staticDir))
 	mux.Handle("GET /static/", http.StripPrefix("/static/", fs))
 
+	// Release the dead man's switch notes whose owner missed a check-in while the server runs
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.scheduleCheckins(ctx)
+
 	s.logger.Info("Starting server", "addr", addr)
 	return http.ListenAndServe(addr, s.loggingMiddleware(mux))
 }
@@ -117,83 +143,129 @@ func (s *Server) handleCreateNote(w http.ResponseWriter, r *http.Request) {
 	logger := s.logger.With("request_id", requestID)
 
 	// Parse the request body
-	var req CreateNoteRequest
+	var req api.CreateNoteRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		logger.Error("Failed to decode request body", "error", err)
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Validate the request
-	if req.Text == "" {
-		logger.Error("Empty text in request")
-		http.Error(w, "Text cannot be empty", http.StatusBadRequest)
+	retention, err := s.retention(req.Retention)
+	if err != nil {
+		logger.Error("Invalid retention", "error", err)
+		http.Error(w, "Invalid retention: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	// Parse the unlock time
-	unlockAt, err := time.Parse(time.RFC3339, req.UnlockAt)
+	maxViews, err := viewLimit(req.MaxViews, req.BurnAfterReading)
 	if err != nil {
-		logger.Error("Invalid unlock_at format", "error", err)
-		http.Error(w, "Invalid unlock_at format. Use RFC3339 format (e.g., 2023-01-01T12:00:00Z)", http.StatusBadRequest)
+		logger.Error("Invalid view limit", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkPassphrase(req); err != nil {
+		logger.Error("Invalid passphrase", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Recipients) > 0 && len(req.Ciphertext) > 0 {
+		logger.Error("Recipients with a ciphertext")
+		http.Error(w, "Recipients must be added to the ciphertext by the client", http.StatusBadRequest)
+		return
+	}
+	recipients, err := parseRecipients(req.Recipients, maxViews)
+	if err != nil {
+		logger.Error("Invalid recipients", "error", err)
+		http.Error(w, "Invalid recipients: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	approvals, err := approvalThreshold(req)
+	if err != nil {
+		logger.Error("Invalid approvers", "error", err)
+		http.Error(w, "Invalid approvers: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	checkin, err := checkinInterval(req)
+	if err != nil {
+		logger.Error("Invalid check-in interval", "error", err)
+		http.Error(w, "Invalid checkin_interval: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Encrypt the note
-	var cipher []byte
-	var hash []byte
-	var round uint64
-
-	if s.testMode {
-		// In test mode, store the plaintext directly
-		cipher = []byte(req.Text)
-
-		// Generate a fake hash
-		hash = make([]byte, 32)
-		for i := range hash {
-			hash[i] = byte(i)
-		}
+	token, err := s.deleteToken(w, logger)
+	if err != nil {
+		return
+	}
 
-		// Use a fake round number
-		round = 12345
+	// Encrypt the note, or accept the note encrypted by the client.
+	// Notes encrypted by the server keep an owner copy of their payload, opened by the delete
+	// token, to encrypt them again on check-ins and extensions. Notes with recipients don't,
+	// since the server doesn't keep the recipients to encrypt them to again.
+	var note storage.Note
+	var approvalTokens []string
+	var ok bool
+	if len(req.Ciphertext) > 0 {
+		note, ok = s.acceptCiphertext(w, logger, req)
 	} else {
-		// In normal mode, encrypt the note
-		var encryptErr error
-		cipher, hash, round, encryptErr = crypto.Encrypt([]byte(req.Text), unlockAt)
-		if encryptErr != nil {
-			logger.Error("Failed to encrypt note", "error", encryptErr)
-			http.Error(w, "Failed to encrypt note", http.StatusInternalServerError)
-			return
+		owner := ""
+		if len(recipients) == 0 {
+			owner = token
 		}
+		note, approvalTokens, ok = s.encryptText(w, logger, req, recipients, approvals, owner)
+	}
+	if !ok {
+		return
 	}
 
-	// Generate a UUID for the note
-	id := uuid.New().String()
-
-	// Convert the hash to a hex string
-	hashHex := hex.EncodeToString(hash)
+	note.FragmentKey = req.FragmentKey
+	note.MaxViews = maxViews
+	note.ExpiresAt = note.UnlockAt.Add(retention)
+	note.CheckinInterval = checkin
+	s.saveNote(r.Context(), w, logger, note, token, approvalTokens)
+}
 
-	// Create a note
-	note := storage.Note{
-		ID:       id,
-		Hash:     hashHex,
-		Cipher:   cipher,
-		Round:    round,
-		UnlockAt: unlockAt,
+// deleteToken generates the delete token of a new note.
+// On failure it writes the error response and returns the error.
+func (s *Server) deleteToken(w http.ResponseWriter, logger *slog.Logger) (string, error) {
+	token, _, err := newToken()
+	if err != nil {
+		logger.Error("Failed to generate delete token", "error", err)
+		http.Error(w, "Failed to save note", http.StatusInternalServerError)
 	}
+	return token, err
+}
+
+// saveNote assigns an ID to a new note, saves it and writes its URL, delete token and
+// approval tokens in the response
+func (s *Server) saveNote(ctx context.Context, w http.ResponseWriter, logger *slog.Logger, note storage.Note, token string, approvalTokens []string) {
+	s.saveNoteWith(w, logger, note, token, approvalTokens, func(note storage.Note) error {
+		return s.store.Save(ctx, note)
+	})
+}
+
+// saveNoteWith is saveNote with the note saved by save, e.g. with its ciphertext streamed
+func (s *Server) saveNoteWith(w http.ResponseWriter, logger *slog.Logger, note storage.Note, token string, approvalTokens []string, save func(storage.Note) error) {
+	// Generate a UUID for the note
+	note.ID = uuid.New().String()
+	note.Version = byte(crypto.FormatAge)
+	note.TokenHash = tokenHash(token)
 
 	// Save the note
-	if err := s.store.Save(r.Context(), note); err != nil {
+	if err := save(note); err != nil {
 		logger.Error("Failed to save note", "error", err)
 		http.Error(w, "Failed to save note", http.StatusInternalServerError)
 		return
 	}
 
 	// Generate the URL
-	url := fmt.Sprintf("%s/note/%s/%s", s.baseDomain, id, hashHex)
+	url := fmt.Sprintf("%s/note/%s/%s", s.baseDomain, note.ID, note.Hash)
 
 	// Return the URL
-	resp := CreateNoteResponse{URL: url}
+	resp := api.CreateNoteResponse{
+		URL:            url,
+		ExpiresAt:      note.Expiry().Format(time.RFC3339),
+		DeleteToken:    token,
+		ApprovalTokens: approvalTokens,
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
@@ -201,7 +273,170 @@ func (s *Server) handleCreateNote(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleGetNote handles the GET /{id}/{h} endpoint
+// retention parses the retention chosen by the creator of a note, if any, and checks its bounds
+func (s *Server) retention(value string) (time.Duration, error) {
+	if value == "" {
+		return s.defaultRetention, nil
+	}
+
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention <= 0 {
+		return 0, fmt.Errorf("%q is not a positive duration (e.g., 24h)", value)
+	}
+	if retention > s.maxRetention {
+		return 0, fmt.Errorf("the maximum is %s", s.maxRetention)
+	}
+	return retention, nil
+}
+
+// encryptText encrypts the text of a request on the server, also to its recipients, and returns
+// the tokens of its approvers when it needs approvals. With an owner token, the note keeps an
+// owner copy of its timelocked payload. On failure it writes the error response and returns false.
+func (s *Server) encryptText(w http.ResponseWriter, logger *slog.Logger, req api.CreateNoteRequest, recipients []crypto.Recipient, approvals uint32, owner string) (storage.Note, []string, bool) {
+	// Validate the request
+	if req.Text == "" {
+		logger.Error("Empty text in request")
+		http.Error(w, "Text cannot be empty", http.StatusBadRequest)
+		return storage.Note{}, nil, false
+	}
+
+	// Notes encrypted with a fragment key must hold at least a nonce and a tag
+	if req.FragmentKey {
+		payload, err := base64.StdEncoding.DecodeString(req.Text)
+		if err != nil || len(payload) < fragmentPayloadMinSize {
+			logger.Error("Invalid fragment key payload", "error", err)
+			http.Error(w, "Text must be a base64 encoded AES-GCM payload with fragment_key", http.StatusBadRequest)
+			return storage.Note{}, nil, false
+		}
+	}
+
+	// Parse the unlock time
+	unlockAt, err := time.Parse(time.RFC3339, req.UnlockAt)
+	if err != nil {
+		logger.Error("Invalid unlock_at format", "error", err)
+		http.Error(w, "Invalid unlock_at format. Use RFC3339 format (e.g., 2023-01-01T12:00:00Z)", http.StatusBadRequest)
+		return storage.Note{}, nil, false
+	}
+
+	// The passphrase layer is inside the timelock, so its attempts can only start after unlock
+	payload := []byte(req.Text)
+	if req.Passphrase != "" {
+		if payload, err = crypto.SealPassphrase(payload, req.Passphrase); err != nil {
+			logger.Error("Failed to encrypt note with passphrase", "error", err)
+			http.Error(w, "Failed to encrypt note", http.StatusInternalServerError)
+			return storage.Note{}, nil, false
+		}
+	}
+
+	// The approval layer is also inside the timelock, so approvals before unlock reveal nothing
+	var approvers []storage.Approver
+	var tokens []string
+	if approvals > 0 {
+		if payload, approvers, tokens, err = sealApprovals(payload, req.Approvers, approvals); err != nil {
+			logger.Error("Failed to encrypt note for approvers", "error", err)
+			http.Error(w, "Failed to encrypt note", http.StatusInternalServerError)
+			return storage.Note{}, nil, false
+		}
+	}
+
+	var ownerCipher []byte
+	if owner != "" {
+		if ownerCipher, err = crypto.SealOwner(payload, owner); err != nil {
+			logger.Error("Failed to encrypt owner copy", "error", err)
+			http.Error(w, "Failed to encrypt note", http.StatusInternalServerError)
+			return storage.Note{}, nil, false
+		}
+	}
+
+	// Encrypt the note
+	cipher, hash, round, err := s.locker.Encrypt(payload, unlockAt, recipients...)
+	if err != nil {
+		logger.Error("Failed to encrypt note", "error", err)
+		http.Error(w, "Failed to encrypt note", http.StatusInternalServerError)
+		return storage.Note{}, nil, false
+	}
+
+	return storage.Note{
+		Hash:       hex.EncodeToString(hash),
+		Cipher:     cipher,
+		Round:      round,
+		UnlockAt:   unlockAt,
+		Passphrase: req.Passphrase != "",
+		Approvals:  approvals,
+		Approvers:  approvers,
+
+		OwnerCipher: ownerCipher,
+	}, tokens, true
+}
+
+// acceptCiphertext validates a note encrypted by the client, so the server never sees the plaintext.
+// On failure it writes the error response and returns false.
+func (s *Server) acceptCiphertext(w http.ResponseWriter, logger *slog.Logger, req api.CreateNoteRequest) (storage.Note, bool) {
+	if req.Text != "" {
+		logger.Error("Both text and ciphertext in request")
+		http.Error(w, "Provide either text or ciphertext, not both", http.StatusBadRequest)
+		return storage.Note{}, false
+	}
+
+	// Armored files, e.g. from tle --armor, are stored in binary form
+	ciphertext, err := crypto.Dearmor(req.Ciphertext)
+	if err != nil {
+		logger.Error("Invalid ciphertext", "error", err)
+		http.Error(w, "Invalid ciphertext: "+err.Error(), http.StatusBadRequest)
+		return storage.Note{}, false
+	}
+
+	// Files made by tle carry their round in the tlock stanza
+	if req.Round == 0 {
+		if req.Round, _, err = crypto.ParseHeader(ciphertext); err != nil {
+			logger.Error("Invalid ciphertext", "error", err)
+			http.Error(w, "Invalid ciphertext: "+err.Error(), http.StatusBadRequest)
+			return storage.Note{}, false
+		}
+	}
+
+	hash, err := s.locker.Validate(ciphertext, req.Round)
+	if err != nil {
+		logger.Error("Invalid ciphertext", "error", err)
+		http.Error(w, "Invalid ciphertext: "+err.Error(), http.StatusBadRequest)
+		return storage.Note{}, false
+	}
+
+	// The note unlocks with its round, unless the client gave a time within that round
+	info := s.locker.Info()
+	unlockAt := info.TimeOfRound(req.Round)
+	if req.UnlockAt != "" {
+		unlockAt, err = time.Parse(time.RFC3339, req.UnlockAt)
+		if err != nil {
+			logger.Error("Invalid unlock_at format", "error", err)
+			http.Error(w, "Invalid unlock_at format. Use RFC3339 format (e.g., 2023-01-01T12:00:00Z)", http.StatusBadRequest)
+			return storage.Note{}, false
+		}
+		if info.RoundFor(unlockAt) != req.Round {
+			logger.Error("unlock_at does not match round", "unlock_at", unlockAt, "round", req.Round)
+			http.Error(w, "unlock_at does not match round", http.StatusBadRequest)
+			return storage.Note{}, false
+		}
+	}
+
+	return storage.Note{
+		Hash:     hex.EncodeToString(hash),
+		Cipher:   ciphertext,
+		Round:    req.Round,
+		UnlockAt: unlockAt,
+	}, true
+}
+
+// handleGetChain handles the GET /api/chain endpoint.
+// It returns the drand chain info clients need to encrypt notes themselves.
+func (s *Server) handleGetChain(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.locker.Info()); err != nil {
+		s.logger.Error("Failed to encode chain info", "error", err)
+	}
+}
+
+// handleGetNote handles the GET /{id}/{h} endpoint, and its POST with the passphrase of a protected note
 func (s *Server) handleGetNote(w http.ResponseWriter, r *http.Request) {
 	requestID := r.Context().Value(requestIDKey).(string)
 	logger := s.logger.With("request_id", requestID)
@@ -210,8 +445,11 @@ func (s *Server) handleGetNote(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	hash := r.PathValue("h")
 
+	r.Body = http.MaxBytesReader(w, r.Body, 2*maxPassphraseLength)
+	passphrase := r.PostFormValue("passphrase")
+
 	// Get the note from the store
-	note, err := s.store.Get(r.Context(), id, hash)
+	note, err := s.getNote(r.Context(), id, hash)
 	if err != nil {
 		if err == storage.ErrNotFound {
 			logger.Info("Note not found", "id", id, "hash", hash)
@@ -223,25 +461,42 @@ func (s *Server) handleGetNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Try to decrypt the note
+	// Try to decrypt the note, or only the metadata of its file
 	var plaintext []byte
-	var decryptErr error
-
-	if s.testMode {
-		// In test mode, bypass the time check
-		plaintext = note.Cipher // In test mode, we store the plaintext directly
-		decryptErr = nil
-	} else {
-		// In normal mode, decrypt the note
-		plaintext, decryptErr = crypto.Decrypt(note.Cipher, note.Round)
+	attachment, decryptErr := s.openAttachment(note)
+	switch {
+	case decryptErr != nil || attachment != nil:
+	case note.FragmentKey && note.MaxViews > 0:
+		// The page reads the note through the JSON API, which counts the view
+		if !s.isUnlocked(note) {
+			decryptErr = crypto.ErrTooEarly
+		} else if note.Approvals > 0 && !note.Approved() {
+			decryptErr = errNotApproved
+		}
+	default:
+		plaintext, note, decryptErr = s.readNote(r.Context(), logger, note, passphrase)
+	}
+	if decryptErr == storage.ErrNotFound {
+		logger.Info("Note deleted after its last view", "id", id, "hash", hash)
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	}
+	var tooMany *tooManyAttemptsError
+	if decryptErr == errPassphraseRequired || decryptErr == crypto.ErrWrongPassphrase || errors.As(decryptErr, &tooMany) {
+		s.renderPassphrasePrompt(w, logger, note, decryptErr)
+		return
+	}
+	if decryptErr == errNotApproved {
+		logger.Info("Note awaiting approvals", "id", id, "hash", hash)
+		s.renderAwaitingApproval(w, logger, note)
+		return
 	}
-
 	if decryptErr != nil {
 		if decryptErr == crypto.ErrTooEarly {
 			logger.Info("Too early to decrypt note", "id", id, "hash", hash, "unlock_at", note.UnlockAt)
 
 			// Calculate the remaining time
-			remaining := time.Until(note.UnlockAt)
+			remaining := note.UnlockAt.Sub(s.locker.Now())
 
 			// Render the "too early" template
 			w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -259,6 +514,10 @@ func (s *Server) handleGetNote(w http.ResponseWriter, r *http.Request) {
     <h1>Note Locked</h1>
     <p>This note is locked until {{.UnlockAt}}.</p>
     <p>Remaining time: {{.Remaining}}</p>
+    <p><small>It will be deleted at {{.ExpiresAt}}.</small></p>
+    {{if eq .MaxViews 1}}<p><small>This note will be deleted the first time it is read after unlock.</small></p>
+    {{else if .MaxViews}}<p><small>This note will be deleted once it is read {{.MaxViews}} times after unlock.</small></p>{{end}}
+    {{if .Approvals}}<p><small>Once unlocked, it can only be read after {{.Approvals}} approvers approved it.</small></p>{{end}}
 </body>
 </html>
 `))
@@ -266,9 +525,15 @@ func (s *Server) handleGetNote(w http.ResponseWriter, r *http.Request) {
 			data := struct {
 				UnlockAt  string
 				Remaining string
+				ExpiresAt string
+				MaxViews  uint32
+				Approvals uint32
 			}{
 				UnlockAt:  note.UnlockAt.Format(time.RFC1123),
 				Remaining: remaining.Round(time.Second).String(),
+				ExpiresAt: note.Expiry().Format(time.RFC1123),
+				MaxViews:  note.MaxViews,
+				Approvals: note.Approvals,
 			}
 
 			if err := tmpl.Execute(w, data); err != nil {
@@ -282,6 +547,17 @@ func (s *Server) handleGetNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Notes encrypted with a fragment key are decrypted by the browser
+	if note.FragmentKey {
+		s.renderFragmentNote(w, logger, note)
+		return
+	}
+
+	if attachment != nil {
+		s.renderAttachment(w, logger, note, attachment)
+		return
+	}
+
 	// Render the note
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
@@ -297,7 +573,9 @@ func (s *Server) handleGetNote(w http.ResponseWriter, r *http.Request) {
 <body>
     <h1>Decrypted Note</h1>
     <pre>{{.Content}}</pre>
-    <p><small>This note was unlocked at {{.UnlockTime}}.</small></p>
+    <p><small>This note was unlocked at {{.UnlockTime}}.{{if not .Spent}} It will be deleted at {{.ExpiresAt}}.{{end}}</small></p>
+    {{if .Spent}}<p><strong>This note has been deleted from the server. Copy it now: it can't be opened again.</strong></p>
+    {{else if .ViewsLeft}}<p><strong>This note can be opened {{.ViewsLeft}} more time(s) before it is deleted.</strong></p>{{end}}
 </body>
 </html>
 `))
@@ -305,9 +583,331 @@ func (s *Server) handleGetNote(w http.ResponseWriter, r *http.Request) {
 	data := struct {
 		Content    string
 		UnlockTime string
+		ExpiresAt  string
+		Spent      bool
+		ViewsLeft  *uint32
 	}{
 		Content:    string(plaintext),
 		UnlockTime: note.UnlockAt.Format(time.RFC1123),
+		ExpiresAt:  note.Expiry().Format(time.RFC1123),
+		Spent:      note.Spent(),
+		ViewsLeft:  viewsLeft(note),
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		logger.Error("Failed to render template", "error", err)
+	}
+}
+
+// handleGetNoteAPI handles the GET /api/note/{id}/{h} endpoint, and its POST with an api.OpenNoteRequest
+func (s *Server) handleGetNoteAPI(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Context().Value(requestIDKey).(string)
+	logger := s.logger.With("request_id", requestID)
+
+	// Extract the ID and hash from the URL
+	id := r.PathValue("id")
+	hash := r.PathValue("h")
+
+	var open api.OpenNoteRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*maxPassphraseLength)).Decode(&open); err != nil {
+			logger.Error("Failed to decode request body", "error", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Get the note from the store
+	note, err := s.getNote(r.Context(), id, hash)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Info("Note not found", "id", id, "hash", hash)
+			http.Error(w, "Note not found", http.StatusNotFound)
+		} else {
+			logger.Error("Failed to get note", "error", err, "id", id, "hash", hash)
+			http.Error(w, "Failed to get note", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := api.GetNoteResponse{
+		Status:    api.NoteStatusLocked,
+		UnlockAt:  note.UnlockAt.Format(time.RFC3339),
+		ExpiresAt: note.Expiry().Format(time.RFC3339),
+		Round:     note.Round,
+	}
+	status := http.StatusOK
+
+	// Try to decrypt the note, or only the metadata of its file
+	var plaintext []byte
+	attachment, err := s.openAttachment(note)
+	if err == nil && attachment == nil {
+		plaintext, note, err = s.readNote(r.Context(), logger, note, open.Passphrase)
+	}
+	if s.writePassphraseError(w, err) {
+		return
+	}
+	switch {
+	case err == storage.ErrNotFound:
+		logger.Info("Note deleted after its last view", "id", id, "hash", hash)
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	case err == crypto.ErrTooEarly:
+		logger.Info("Too early to decrypt note", "id", id, "hash", hash, "unlock_at", note.UnlockAt)
+
+		// The note unlocks when its round is produced, rounded up to the next second
+		remaining := s.locker.Info().TimeOfRound(note.Round).Sub(s.locker.Now())
+		resp.RemainingSeconds = int64((remaining + time.Second - 1) / time.Second)
+		status = http.StatusLocked
+	case err == errPassphraseRequired:
+		resp.Status = api.NoteStatusUnlocked
+	case err == errNotApproved:
+		logger.Info("Note awaiting approvals", "id", id, "hash", hash)
+		resp.Status = api.NoteStatusAwaitingApproval
+		status = http.StatusLocked
+	case err != nil:
+		logger.Error("Failed to decrypt note", "error", err, "id", id, "hash", hash)
+		http.Error(w, "Failed to decrypt note", http.StatusInternalServerError)
+		return
+	case attachment != nil:
+		resp.Status = api.NoteStatusUnlocked
+		resp.Attachment = attachment
+		resp.DownloadURL = fmt.Sprintf("%s/note/%s/%s/download", s.baseDomain, note.ID, note.Hash)
+	default:
+		resp.Status = api.NoteStatusUnlocked
+		resp.Text = string(plaintext)
+	}
+	resp.FragmentKey = note.FragmentKey
+	resp.ViewsLeft = viewsLeft(note)
+	resp.Passphrase = note.Passphrase
+	if note.Approvals > 0 {
+		resp.Approvals = uint32(len(note.Released()))
+		resp.ApprovalsRequired = note.Approvals
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Error("Failed to encode response", "error", err)
+	}
+}
+
+// decryptNote decrypts a note. Notes in the legacy format are migrated
+// to the age format the first time they are decrypted.
+func (s *Server) decryptNote(ctx context.Context, logger *slog.Logger, note storage.Note) ([]byte, error) {
+	plaintext, err := s.locker.Decrypt(note.Cipher, note.Round)
+	if err != nil || note.Version >= byte(crypto.FormatAge) {
+		return plaintext, err
+	}
+
+	// The note keeps its ID and hash, so its URL does not change
+	if err := s.migrateNote(ctx, note); err != nil {
+		logger.Error("Failed to migrate note", "error", err, "id", note.ID, "hash", note.Hash)
+	} else {
+		logger.Info("Migrated note to the age format", "id", note.ID, "hash", note.Hash)
+	}
+
+	return plaintext, nil
+}
+
+// migrateNote converts an unlocked legacy note to the age format in the store. Only the
+// ciphertext of the stored note is replaced, so views, deletes and updates since it was read are kept.
+func (s *Server) migrateNote(ctx context.Context, note storage.Note) error {
+	cipher, err := s.locker.Migrate(note.Cipher, note.Round)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.store.Update(ctx, note.ID, note.Hash, func(n *storage.Note) error {
+		// Another reader may have migrated the note first
+		if n.Version >= byte(crypto.FormatAge) {
+			return nil
+		}
+		n.Cipher = cipher
+		n.Version = byte(crypto.FormatAge)
+		return nil
+	})
+	if err == storage.ErrNotFound {
+		// The note was deleted meanwhile, and must not come back
+		return nil
+	}
+	return err
+}
+
+// handleExportNote handles the GET /api/note/{id}/{h}/export endpoint.
+// It returns the note as an armored age file, which the tle tool can decrypt once unlocked.
+func (s *Server) handleExportNote(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Context().Value(requestIDKey).(string)
+	logger := s.logger.With("request_id", requestID)
+
+	// Extract the ID and hash from the URL
+	id := r.PathValue("id")
+	hash := r.PathValue("h")
+
+	// Get the note from the store, with its ciphertext as a stream
+	note, cipher, err := s.store.GetStream(r.Context(), id, hash)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Info("Note not found", "id", id, "hash", hash)
+			http.Error(w, "Note not found", http.StatusNotFound)
+		} else {
+			logger.Error("Failed to get note", "error", err, "id", id, "hash", hash)
+			http.Error(w, "Failed to get note", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer cipher.Close()
+
+	// The exported file could be decrypted any number of times
+	if note.MaxViews > 0 {
+		http.Error(w, "Notes with a view limit cannot be exported", http.StatusConflict)
+		return
+	}
+
+	// The passphrase layer of an exported file could be attacked offline, without attempt limits
+	if note.Passphrase {
+		http.Error(w, "Notes with a passphrase cannot be exported", http.StatusConflict)
+		return
+	}
+
+	// The approval layer of an exported file can't be opened without the shares kept by the server
+	if note.Approvals > 0 {
+		http.Error(w, "Notes that need approvals cannot be exported", http.StatusConflict)
+		return
+	}
+
+	// An exported file would keep unlocking at the current round after the next check-in
+	if note.CheckinInterval > 0 {
+		http.Error(w, "Notes with check-ins cannot be exported", http.StatusConflict)
+		return
+	}
+
+	// Legacy notes can only be converted once unlocked
+	var export io.Reader = cipher
+	if note.Version < byte(crypto.FormatAge) {
+		legacy, err := io.ReadAll(cipher)
+		if err != nil {
+			logger.Error("Failed to read note", "error", err, "id", id, "hash", hash)
+			http.Error(w, "Failed to export note", http.StatusInternalServerError)
+			return
+		}
+		migrated, err := s.locker.Migrate(legacy, note.Round)
+		if err != nil {
+			if err == crypto.ErrTooEarly {
+				http.Error(w, "This note uses a legacy format and can only be exported once unlocked", http.StatusConflict)
+				return
+			}
+			logger.Error("Failed to migrate note", "error", err, "id", id, "hash", hash)
+			http.Error(w, "Failed to export note", http.StatusInternalServerError)
+			return
+		}
+		export = bytes.NewReader(migrated)
+	}
+
+	// The file is armored while it is streamed, so errors past this point can only be logged
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".age"))
+	if err := crypto.ArmorStream(w, export); err != nil {
+		logger.Error("Failed to export note", "error", err, "id", id, "hash", hash)
+	}
+}
+
+// renderAttachment renders the page of an unlocked note with a file, linking to its download
+func (s *Server) renderAttachment(w http.ResponseWriter, logger *slog.Logger, note storage.Note, attachment *api.Attachment) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	tmpl := template.Must(template.New("attachment").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Decrypted File</title>
+    <link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/water.css@2/out/water.css">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+</head>
+<body>
+    <h1>Decrypted File</h1>
+    <p><strong>{{.Name}}</strong> ({{.Type}}, {{.Size}} bytes)</p>
+    <p><a href="{{.DownloadURL}}" download>Download</a></p>
+    <p><small>This file was unlocked at {{.UnlockTime}}. It will be deleted at {{.ExpiresAt}}.</small></p>
+</body>
+</html>
+`))
+
+	data := struct {
+		Name        string
+		Type        string
+		Size        int64
+		DownloadURL string
+		UnlockTime  string
+		ExpiresAt   string
+	}{
+		Name:        attachment.Name,
+		Type:        attachment.Type,
+		Size:        attachment.Size,
+		DownloadURL: fmt.Sprintf("/note/%s/%s/download", note.ID, note.Hash),
+		UnlockTime:  note.UnlockAt.Format(time.RFC1123),
+		ExpiresAt:   note.Expiry().Format(time.RFC1123),
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		logger.Error("Failed to render template", "error", err)
+	}
+}
+
+// renderFragmentNote renders the page of an unlocked note encrypted with a fragment key.
+// The page fetches the payload from the JSON API and decrypts it with the key in the URL fragment.
+func (s *Server) renderFragmentNote(w http.ResponseWriter, logger *slog.Logger, note storage.Note) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	tmpl := template.Must(template.New("fragment_note").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Decrypted Note</title>
+    <link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/water.css@2/out/water.css">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+</head>
+<body>
+    <h1>Decrypted Note</h1>
+    <pre id="content">Decrypting...</pre>
+    <p><small>This note was unlocked at {{.UnlockTime}}. It was decrypted in your browser with the key in the link.{{if not .Limited}} It will be deleted at {{.ExpiresAt}}.{{end}}</small></p>
+    <script src="/static/fragment.js"></script>
+    <script>
+        const content = document.getElementById('content');
+        const key = location.hash.slice(1);
+        if (!key) {
+            content.textContent = 'This link is missing the key needed to decrypt the note.';
+        } else {
+            fetch('/api' + location.pathname)
+                .then(response => {
+                    if (!response.ok) {
+                        throw new Error('Failed to get note');
+                    }
+                    return response.json();
+                })
+                .then(data => fragmentDecrypt(data.text, key))
+                .then(text => {
+                    content.textContent = text;
+                })
+                .catch(error => {
+                    content.textContent = 'Failed to decrypt the note: ' + error.message;
+                });
+        }
+    </script>
+</body>
+</html>
+`))
+
+	data := struct {
+		UnlockTime string
+		ExpiresAt  string
+		Limited    bool
+	}{
+		UnlockTime: note.UnlockAt.Format(time.RFC1123),
+		ExpiresAt:  note.Expiry().Format(time.RFC1123),
+		Limited:    note.MaxViews > 0,
 	}
 
 	if err := tmpl.Execute(w, data); err != nil {
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/korjavin/drand-poc/internal/crypt/crypto"
+	"github.com/korjavin/drand-poc/storage"
+)
+
+// errNoteChanged is returned by the read of a view if the note changed since it was decrypted
+var errNoteChanged = errors.New("note changed since it was decrypted")
+
+// viewLimit returns the view limit of a new note from the request options
+func viewLimit(maxViews uint32, burnAfterReading bool) (uint32, error) {
+	if burnAfterReading {
+		if maxViews > 1 {
+			return 0, fmt.Errorf("burn_after_reading allows a single view, got max_views %d", maxViews)
+		}
+		return 1, nil
+	}
+	return maxViews, nil
+}
+
+// readNote decrypts a note for a reader and returns it with the read counted.
+// Reads of notes with a view limit are counted in the store, and the last one deletes the note:
+// storage.ErrNotFound is returned if other readers used up the views first.
+// Notes protected by a passphrase count an attempt before the passphrase is checked, and return
+// errPassphraseRequired without one once unlocked. Failed attempts don't count as views.
+// Notes that need approvals return errNotApproved once unlocked, until enough approvers released their share.
+func (s *Server) readNote(ctx context.Context, logger *slog.Logger, note storage.Note, passphrase string) ([]byte, storage.Note, error) {
+	if note.Approvals > 0 {
+		if !s.isUnlocked(note) {
+			return nil, note, crypto.ErrTooEarly
+		}
+		if !note.Approved() {
+			return nil, note, errNotApproved
+		}
+	}
+	if note.Passphrase {
+		if !s.isUnlocked(note) {
+			return nil, note, crypto.ErrTooEarly
+		}
+		if passphrase == "" {
+			return nil, note, errPassphraseRequired
+		}
+		if err := s.countAttempt(ctx, note); err != nil {
+			return nil, note, err
+		}
+	}
+
+	// Notes are decrypted before their view is counted, so the store isn't held during the beacon fetch
+	// and the passphrase check. The view is only counted if the note wasn't changed meanwhile.
+	viewed := note
+	plaintext, err := s.decryptNote(ctx, logger, note)
+	for err == nil {
+		if plaintext, err = openLayers(note, plaintext, passphrase); err != nil || note.MaxViews == 0 {
+			break
+		}
+		var changed storage.Note
+		viewed, err = s.store.View(ctx, note.ID, note.Hash, func(n storage.Note) error {
+			if n.Round != note.Round || !bytes.Equal(n.Cipher, note.Cipher) {
+				changed = n
+				return errNoteChanged
+			}
+			return nil
+		})
+		if err != errNoteChanged {
+			break
+		}
+		// A check-in locked the note again, or a reader migrated it
+		note = changed
+		plaintext, err = s.decryptNote(ctx, logger, note)
+	}
+	if err != nil {
+		if err == crypto.ErrWrongPassphrase {
+			logger.Info("Wrong passphrase", "id", note.ID, "hash", note.Hash)
+		}
+		return nil, note, err
+	}
+
+	if viewed.Spent() {
+		logger.Info("Deleted note after its last view", "id", note.ID, "hash", note.Hash, "views", viewed.Views)
+	}
+	if note.Passphrase {
+		s.resetAttempts(ctx, logger, viewed)
+	}
+	return plaintext, viewed, nil
+}
+
+// openLayers decrypts the layers of a note inside its timelock: its approvals, then its passphrase
+func openLayers(note storage.Note, plaintext []byte, passphrase string) ([]byte, error) {
+	plaintext, err := openApprovals(note, plaintext)
+	if err != nil || !note.Passphrase {
+		return plaintext, err
+	}
+	return crypto.OpenPassphrase(plaintext, passphrase)
+}
+
+// openFile decrypts the file of a note as a stream, counting the read like readNote.
+// The ciphertext is streamed from the store, and released when the stream is closed.
+func (s *Server) openFile(ctx context.Context, logger *slog.Logger, note storage.Note) (io.ReadCloser, error) {
+	if note.MaxViews == 0 {
+		_, cipher, err := s.store.GetStream(ctx, note.ID, note.Hash)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := s.locker.DecryptStream(cipher, note.Round)
+		if err != nil {
+			cipher.Close()
+			return nil, err
+		}
+		return fileStream{plaintext, cipher}, nil
+	}
+
+	// The header is decrypted when the stream is opened, so locked notes fail before the view is counted
+	var plaintext io.Reader
+	viewed, cipher, err := s.store.ViewStream(ctx, note.ID, note.Hash, func(n storage.Note, cipher io.Reader) error {
+		var err error
+		plaintext, err = s.locker.DecryptStream(cipher, n.Round)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if viewed.Spent() {
+		logger.Info("Deleted note after its last view", "id", note.ID, "hash", note.Hash, "views", viewed.Views)
+	}
+	return fileStream{plaintext, cipher}, nil
+}
+
+// fileStream is the decrypted stream of a file, closing the ciphertext stream it reads from
+type fileStream struct {
+	io.Reader
+	io.Closer
+}
+
+// viewsLeft returns the number of reads left before a note is deleted, or nil without a limit
+func viewsLeft(note storage.Note) *uint32 {
+	if note.MaxViews == 0 {
+		return nil
+	}
+	left := uint32(0)
+	if note.Views < note.MaxViews {
+		left = note.MaxViews - note.Views
+	}
+	return &left
+}
+
+// isUnlocked reports whether the round of a note was reached, without decrypting it
+func (s *Server) isUnlocked(note storage.Note) bool {
+	return !s.locker.Now().Before(s.locker.Info().TimeOfRound(note.Round))
+}
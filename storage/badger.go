@@ -1,8 +1,8 @@
 package storage
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
@@ -11,7 +11,8 @@ import (
 
 // BadgerStore implements the Store interface using Badger DB
 type BadgerStore struct {
-	db *badger.DB
+	db  *badger.DB
+	now func() time.Time
 }
 
 // NewBadgerStore creates a new BadgerStore with the given options
@@ -20,7 +21,13 @@ func NewBadgerStore(opts badger.Options) (*BadgerStore, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to open badger db: %w", err)
 	}
-	return &BadgerStore{db: db}, nil
+	return &BadgerStore{db: db, now: time.Now}, nil
+}
+
+// SetClock replaces the clock used to hide expired notes, for tests.
+// Badger itself still drops the notes at their expiry time by the system clock.
+func (s *BadgerStore) SetClock(now func() time.Time) {
+	s.now = now
 }
 
 // Close closes the underlying Badger database
@@ -30,13 +37,14 @@ func (s *BadgerStore) Close() error {
 
 // Save stores a note in the database with TTL
 func (s *BadgerStore) Save(ctx context.Context, n Note) error {
-	// Calculate TTL: UnlockAt + 7 days
-	ttl := time.Until(n.UnlockAt.Add(7 * 24 * time.Hour))
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to save note: %w", err)
+	}
 
-	// Marshal the note to JSON
-	data, err := json.Marshal(n)
+	// Encode the note as a versioned binary record
+	data, err := encodeNote(n)
 	if err != nil {
-		return fmt.Errorf("failed to marshal note: %w", err)
+		return err
 	}
 
 	// Create a composite key: id:hash
@@ -44,7 +52,8 @@ func (s *BadgerStore) Save(ctx context.Context, n Note) error {
 
 	// Store the note in the database with TTL
 	err = s.db.Update(func(txn *badger.Txn) error {
-		entry := badger.NewEntry(key, data).WithTTL(ttl)
+		entry := badger.NewEntry(key, data)
+		entry.ExpiresAt = uint64(n.Expiry().Unix())
 		return txn.SetEntry(entry)
 	})
 
@@ -57,6 +66,10 @@ func (s *BadgerStore) Save(ctx context.Context, n Note) error {
 
 // Get retrieves a note by its ID and hash
 func (s *BadgerStore) Get(ctx context.Context, id, hash string) (Note, error) {
+	if err := ctx.Err(); err != nil {
+		return Note{}, fmt.Errorf("failed to get note: %w", err)
+	}
+
 	var note Note
 
 	// Create the composite key
@@ -64,25 +77,302 @@ func (s *BadgerStore) Get(ctx context.Context, id, hash string) (Note, error) {
 
 	// Retrieve the note from the database
 	err := s.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(key)
-		if err != nil {
+		var err error
+		note, err = s.getNote(txn, key)
+		return err
+	})
+
+	if err != nil {
+		if err == ErrNotFound {
+			return Note{}, ErrNotFound
+		}
+		return Note{}, fmt.Errorf("failed to get note: %w", err)
+	}
+
+	return note, nil
+}
+
+// getNote reads and decodes a note in a transaction. Expired notes are not returned.
+func (s *BadgerStore) getNote(txn *badger.Txn, key []byte) (Note, error) {
+	item, err := txn.Get(key)
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return Note{}, ErrNotFound
+		}
+		return Note{}, err
+	}
+	if expiresAt := item.ExpiresAt(); expiresAt != 0 && int64(expiresAt) <= s.now().Unix() {
+		return Note{}, ErrNotFound
+	}
+
+	var note Note
+	err = item.Value(func(val []byte) error {
+		note, err = decodeNote(val)
+		return err
+	})
+	return note, err
+}
+
+// Delete removes a note by its ID and hash
+func (s *BadgerStore) Delete(ctx context.Context, id, hash string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to delete note: %w", err)
+	}
+
+	key := []byte(fmt.Sprintf("%s:%s", id, hash))
+	err := s.db.Update(func(txn *badger.Txn) error {
+		if _, err := s.getNote(txn, key); err != nil {
+			return err
+		}
+		return txn.Delete(key)
+	})
+
+	if err != nil {
+		if err == ErrNotFound {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to delete note: %w", err)
+	}
+	return nil
+}
+
+// View reads a note and counts the view in a single transaction
+func (s *BadgerStore) View(ctx context.Context, id, hash string, read func(Note) error) (Note, error) {
+	return s.update(ctx, "view", id, hash, func(n *Note) error {
+		if err := read(*n); err != nil {
+			return err
+		}
+		n.Views++
+		return nil
+	})
+}
+
+// Update changes a note in a single transaction
+func (s *BadgerStore) Update(ctx context.Context, id, hash string, update func(*Note) error) (Note, error) {
+	return s.update(ctx, "update", id, hash, update)
+}
+
+// update applies fn to a note and saves it in a single transaction, or deletes it once it is Spent.
+// When concurrent updates commit first, the transaction fails with a conflict and is retried
+// with the new note. Errors of fn are returned as is, and op names the operation in the others.
+func (s *BadgerStore) update(ctx context.Context, op, id, hash string, fn func(*Note) error) (Note, error) {
+	key := []byte(fmt.Sprintf("%s:%s", id, hash))
+	for attempt := 0; attempt < updateAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return Note{}, fmt.Errorf("failed to %s note: %w", op, err)
+		}
+
+		var note Note
+		var fnErr error
+		err := s.db.Update(func(txn *badger.Txn) error {
+			var err error
+			if note, err = s.getNote(txn, key); err != nil {
+				return err
+			}
+			if fnErr = fn(&note); fnErr != nil {
+				return fnErr
+			}
+
+			if note.Spent() {
+				return txn.Delete(key)
+			}
+			data, err := encodeNote(note)
+			if err != nil {
+				return err
+			}
+
+			// A note with a new hash moves to its new key
+			newKey := []byte(fmt.Sprintf("%s:%s", id, note.Hash))
+			if !bytes.Equal(newKey, key) {
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+			entry := badger.NewEntry(newKey, data)
+			entry.ExpiresAt = uint64(note.Expiry().Unix())
+			return txn.SetEntry(entry)
+		})
+
+		switch {
+		case fnErr != nil:
+			return Note{}, fnErr
+		case err == ErrNotFound:
+			return Note{}, ErrNotFound
+		case err == badger.ErrConflict:
+			continue
+		case err != nil:
+			return Note{}, fmt.Errorf("failed to %s note: %w", op, err)
+		}
+		return note, nil
+	}
+	return Note{}, fmt.Errorf("failed to %s note: %w", op, badger.ErrConflict)
+}
+
+// Scan passes each note that hasn't expired to fn, within a read-only transaction
+func (s *BadgerStore) Scan(ctx context.Context, fn func(Note) error) error {
+	var fnErr error
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		now := s.now().Unix()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			item := it.Item()
+			if bytes.HasPrefix(item.Key(), []byte(beaconKeyPrefix)) {
+				continue
+			}
+			if expiresAt := item.ExpiresAt(); expiresAt != 0 && int64(expiresAt) <= now {
+				continue
+			}
+
+			var note Note
+			if err := item.Value(func(val []byte) error {
+				var err error
+				note, err = decodeNote(val)
+				return err
+			}); err != nil {
+				return err
+			}
+			if fnErr = fn(note); fnErr != nil {
+				return fnErr
+			}
+		}
+		return nil
+	})
+	switch {
+	case fnErr != nil:
+		return fnErr
+	case err != nil:
+		return fmt.Errorf("failed to scan notes: %w", err)
+	}
+	return nil
+}
+
+// Migrate re-encodes the notes stored in an older encoding with the current one,
+// keeping their expiry time, and returns how many notes were migrated
+func (s *BadgerStore) Migrate(ctx context.Context) (int, error) {
+	// Collect the keys first, so that each note is migrated in a small transaction
+	var keys [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if bytes.HasPrefix(item.Key(), []byte(beaconKeyPrefix)) {
+				continue
+			}
+			current := false
+			if err := item.Value(func(val []byte) error {
+				current = isCurrentRecord(val)
+				return nil
+			}); err != nil {
+				return err
+			}
+			if !current {
+				keys = append(keys, item.KeyCopy(nil))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list notes: %w", err)
+	}
+
+	migrated := 0
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return migrated, err
+		}
+
+		err := s.db.Update(func(txn *badger.Txn) error {
+			item, err := txn.Get(key)
 			if err == badger.ErrKeyNotFound {
-				return ErrNotFound
+				// The note expired in the meantime
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
 			}
+			if isCurrentRecord(val) {
+				return nil
+			}
+
+			note, err := decodeNote(val)
+			if err != nil {
+				return fmt.Errorf("note %s: %w", key, err)
+			}
+			data, err := encodeNote(note)
+			if err != nil {
+				return err
+			}
+
+			entry := badger.NewEntry(key, data)
+			entry.ExpiresAt = item.ExpiresAt()
+			if err := txn.SetEntry(entry); err != nil {
+				return err
+			}
+			migrated++
+			return nil
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("failed to migrate note: %w", err)
+		}
+	}
+
+	return migrated, nil
+}
+
+// beaconKeyPrefix is the prefix of the keys of cached beacon signatures
+const beaconKeyPrefix = "beacon:"
+
+// beaconKey returns the key of a cached beacon signature.
+// Note IDs are UUIDs, so the prefix cannot collide with note keys.
+func beaconKey(chainHash string, round uint64) []byte {
+	return []byte(fmt.Sprintf("%s%s:%d", beaconKeyPrefix, chainHash, round))
+}
+
+// LoadSignature returns the cached signature of a drand round, if any
+func (s *BadgerStore) LoadSignature(chainHash string, round uint64) ([]byte, bool, error) {
+	var sig []byte
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(beaconKey(chainHash, round))
+		if err != nil {
 			return err
 		}
 
-		return item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &note)
-		})
+		sig, err = item.ValueCopy(nil)
+		return err
 	})
 
 	if err != nil {
-		if err == ErrNotFound {
-			return Note{}, ErrNotFound
+		if err == badger.ErrKeyNotFound {
+			return nil, false, nil
 		}
-		return Note{}, fmt.Errorf("failed to get note: %w", err)
+		return nil, false, fmt.Errorf("failed to load signature: %w", err)
 	}
 
-	return note, nil
+	return sig, true, nil
+}
+
+// SaveSignature caches the signature of a drand round. Signatures never change, so they have no TTL.
+func (s *BadgerStore) SaveSignature(chainHash string, round uint64, signature []byte) error {
+	err := s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(beaconKey(chainHash, round), signature)
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to save signature: %w", err)
+	}
+
+	return nil
 }
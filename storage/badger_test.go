@@ -66,3 +66,37 @@ func TestBadgerStore(t *testing.T) {
 		t.Errorf("Expected ErrNotFound, got %v", err)
 	}
 }
+
+func TestBadgerStoreSignatures(t *testing.T) {
+	opts := badger.DefaultOptions("").WithInMemory(true)
+	store, err := NewBadgerStore(opts)
+	if err != nil {
+		t.Fatalf("Failed to create BadgerStore: %v", err)
+	}
+	defer store.Close()
+
+	chainHash := "52db9ba70e0cc0f6eaf7803dd07447a1f5477735fd3f661792ba94600c84e971"
+	sig := []byte("signature")
+
+	// A round that was never saved is not found
+	if _, ok, err := store.LoadSignature(chainHash, 42); err != nil || ok {
+		t.Fatalf("Expected no signature, got ok=%v err=%v", ok, err)
+	}
+
+	if err := store.SaveSignature(chainHash, 42, sig); err != nil {
+		t.Fatalf("Failed to save signature: %v", err)
+	}
+
+	loaded, ok, err := store.LoadSignature(chainHash, 42)
+	if err != nil || !ok {
+		t.Fatalf("Failed to load signature: ok=%v err=%v", ok, err)
+	}
+	if string(loaded) != string(sig) {
+		t.Errorf("Expected signature %s, got %s", sig, loaded)
+	}
+
+	// Signatures are scoped to their chain
+	if _, ok, _ := store.LoadSignature("other", 42); ok {
+		t.Errorf("Expected no signature for another chain")
+	}
+}
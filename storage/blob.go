@@ -0,0 +1,406 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrHashMismatch is returned when the content of a blob does not match its key
+var ErrHashMismatch = errors.New("blob content does not match its hash")
+
+// BlobStore stores ciphertexts outside of the note records.
+// Blobs are content-addressed per note: the key of a blob is the ID of its note and
+// the hex SHA-256 of its content, so notes with the same ciphertext never share a blob.
+// Blobs are written and read as streams, so they are never held in memory.
+type BlobStore interface {
+	// Put stores the size bytes read from r as a blob under its key. The expiry time of an
+	// existing blob is only ever extended. It fails with ErrHashMismatch if the content does
+	// not match the key.
+	Put(ctx context.Context, key string, r io.Reader, size int64, expiresAt time.Time) error
+
+	// Get opens a blob by its key, or returns ErrNotFound. Reading the blob to its end fails
+	// with ErrHashMismatch if its content does not match the key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Delete removes a blob. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// Sweep removes the blobs whose expiry time has passed and returns how many were removed
+	Sweep(ctx context.Context) (int, error)
+}
+
+// BlobKey returns the key of the blob of a note with the given content.
+// For the first ciphertext of a note, it is the note ID and hash.
+func BlobKey(id string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return id + "/" + hex.EncodeToString(sum[:])
+}
+
+// keyHash returns the content hash of a blob key
+func keyHash(key string) string {
+	return key[len(key)-2*sha256.Size:]
+}
+
+// hashingReader hashes and counts the content read through it
+type hashingReader struct {
+	r    io.Reader
+	hash hash.Hash
+	n    int64
+}
+
+// newHashingReader returns a hashingReader reading from r
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, hash: sha256.New()}
+}
+
+// Read implements the io.Reader interface
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hash.Write(p[:n])
+	h.n += int64(n)
+	return n, err
+}
+
+// check returns ErrHashMismatch unless the content read so far is the blob of a key and size
+func (h *hashingReader) check(key string, size int64) error {
+	if h.n != size || hex.EncodeToString(h.hash.Sum(nil)) != keyHash(key) {
+		return ErrHashMismatch
+	}
+	return nil
+}
+
+// blobReader checks the content of a blob against its key once read to its end
+type blobReader struct {
+	io.Closer
+	content *hashingReader
+	key     string
+}
+
+// newBlobReader returns a blobReader for the blob of a key read from r
+func newBlobReader(r io.ReadCloser, key string) *blobReader {
+	return &blobReader{Closer: r, content: newHashingReader(r), key: key}
+}
+
+// Read implements the io.Reader interface
+func (b *blobReader) Read(p []byte) (int, error) {
+	n, err := b.content.Read(p)
+	if err == io.EOF && hex.EncodeToString(b.content.hash.Sum(nil)) != keyHash(b.key) {
+		return n, ErrHashMismatch
+	}
+	return n, err
+}
+
+// checkBlobKey checks that a key is a note ID and a lowercase hex SHA-256, so it is safe in
+// paths and URLs. Keys without a note ID, from before blobs were kept per note, are accepted.
+func checkBlobKey(key string) error {
+	id, hash, found := strings.Cut(key, "/")
+	if !found {
+		id, hash = "", key
+	}
+	if found && (id == "" || len(id) > 64 || strings.Trim(id, "0123456789abcdef-") != "") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	if len(hash) != 2*sha256.Size || strings.Trim(hash, "0123456789abcdef") != "" {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
+
+// SplitStore is a Store that keeps large ciphertexts in a BlobStore
+// and only the note metadata with a blob reference in the underlying Store
+type SplitStore struct {
+	store     Store
+	blobs     BlobStore
+	threshold int
+}
+
+// NewSplitStore creates a SplitStore. Ciphertexts of at least threshold bytes
+// are saved in the blob store; smaller ones stay inline in the note records.
+func NewSplitStore(store Store, blobs BlobStore, threshold int) *SplitStore {
+	return &SplitStore{
+		store:     store,
+		blobs:     blobs,
+		threshold: threshold,
+	}
+}
+
+// Save stores the ciphertext of a note in the blob store if it is large, then the note
+func (s *SplitStore) Save(ctx context.Context, n Note) error {
+	n.Blob = ""
+	if len(n.Cipher) >= s.threshold {
+		// The note hash is not used as is: legacy notes keep their hash when re-encrypted
+		key := BlobKey(n.ID, n.Cipher)
+		if err := s.put(ctx, key, bytes.NewReader(n.Cipher), int64(len(n.Cipher)), n.Expiry()); err != nil {
+			return err
+		}
+		n.Blob = key
+		n.Cipher = nil
+	}
+
+	return s.store.Save(ctx, n)
+}
+
+// SaveStream streams the ciphertext of a note to the blob store if it is large, then saves the note.
+// Small ciphertexts are read into the note record.
+func (s *SplitStore) SaveStream(ctx context.Context, n Note, cipher io.Reader, size int64) error {
+	if size < int64(s.threshold) {
+		var err error
+		if n.Cipher, err = readCipher(cipher, size); err != nil {
+			return err
+		}
+		return s.Save(ctx, n)
+	}
+
+	key := n.ID + "/" + n.Hash
+	if err := s.put(ctx, key, cipher, size, n.Expiry()); err != nil {
+		return err
+	}
+	n.Blob = key
+	n.Cipher = nil
+	return s.store.Save(ctx, n)
+}
+
+// put stores a blob
+func (s *SplitStore) put(ctx context.Context, key string, r io.Reader, size int64, expiresAt time.Time) error {
+	if err := s.blobs.Put(ctx, key, r, size, expiresAt); err != nil {
+		return fmt.Errorf("failed to save blob: %w", err)
+	}
+	return nil
+}
+
+// load reads a blob into memory
+func (s *SplitStore) load(ctx context.Context, key string) ([]byte, error) {
+	r, err := s.blobs.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob %s: %w", key, err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// open returns a stream of the ciphertext of a note record. A blob is opened on the first read.
+func (s *SplitStore) open(ctx context.Context, n Note) io.ReadCloser {
+	if n.Blob == "" {
+		return io.NopCloser(bytes.NewReader(n.Cipher))
+	}
+	return &lazyBlob{open: func() (io.ReadCloser, error) {
+		r, err := s.blobs.Get(ctx, n.Blob)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get blob %s: %w", n.Blob, err)
+		}
+		return r, nil
+	}}
+}
+
+// SetClock replaces the clock of the underlying store, if it has one, for tests
+func (s *SplitStore) SetClock(now func() time.Time) {
+	if clock, ok := s.store.(interface{ SetClock(func() time.Time) }); ok {
+		clock.SetClock(now)
+	}
+}
+
+// Get retrieves a note and loads its ciphertext from the blob store if needed
+func (s *SplitStore) Get(ctx context.Context, id, hash string) (Note, error) {
+	n, err := s.store.Get(ctx, id, hash)
+	if err != nil || n.Blob == "" {
+		return n, err
+	}
+
+	if n.Cipher, err = s.load(ctx, n.Blob); err != nil {
+		return Note{}, err
+	}
+	return n, nil
+}
+
+// GetStream retrieves a note, with a stream of its ciphertext from the blob store if needed
+func (s *SplitStore) GetStream(ctx context.Context, id, hash string) (Note, io.ReadCloser, error) {
+	n, err := s.store.Get(ctx, id, hash)
+	if err != nil {
+		return n, nil, err
+	}
+
+	cipher := s.open(ctx, n)
+	n.Cipher = nil
+	return n, cipher, nil
+}
+
+// Delete removes a note and its blob
+func (s *SplitStore) Delete(ctx context.Context, id, hash string) error {
+	n, err := s.store.Get(ctx, id, hash)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Delete(ctx, id, hash); err != nil {
+		return err
+	}
+
+	if n.Blob != "" {
+		if err := s.blobs.Delete(ctx, n.Blob); err != nil {
+			return fmt.Errorf("failed to delete blob %s: %w", n.Blob, err)
+		}
+	}
+	return nil
+}
+
+// View reads a note with its ciphertext and counts the view, deleting the blob of spent notes
+func (s *SplitStore) View(ctx context.Context, id, hash string, read func(Note) error) (Note, error) {
+	// The blob is only loaded again if the note was replaced between attempts
+	var cipher []byte
+	var loaded string
+	n, err := s.store.View(ctx, id, hash, func(n Note) error {
+		if n.Blob != "" {
+			if n.Blob != loaded {
+				var err error
+				if cipher, err = s.load(ctx, n.Blob); err != nil {
+					return err
+				}
+				loaded = n.Blob
+			}
+			n.Cipher = cipher
+		}
+		return read(n)
+	})
+	if err != nil || n.Blob == "" {
+		return n, err
+	}
+	n.Cipher = cipher
+
+	// The note was read and is gone: a blob that can't be deleted now is left
+	// to expire in the blob store rather than failing the read
+	if n.Spent() {
+		_ = s.blobs.Delete(ctx, n.Blob)
+	}
+	return n, nil
+}
+
+// ViewStream reads a note with a stream of its ciphertext and counts the view.
+// The blob of a spent note is deleted when the returned stream is closed.
+func (s *SplitStore) ViewStream(ctx context.Context, id, hash string, read func(Note, io.Reader) error) (Note, io.ReadCloser, error) {
+	// Each attempt reads the ciphertext from its start
+	var cipher io.ReadCloser
+	n, err := s.store.View(ctx, id, hash, func(n Note) error {
+		if cipher != nil {
+			cipher.Close()
+		}
+		cipher = s.open(ctx, n)
+		n.Cipher = nil
+		return read(n, cipher)
+	})
+	if err != nil {
+		if cipher != nil {
+			cipher.Close()
+		}
+		return n, nil, err
+	}
+	n.Cipher = nil
+
+	// Like in View, the blob is left to expire if it can't be deleted, even once the request is done
+	if n.Spent() && n.Blob != "" {
+		blob := n.Blob
+		ctx := context.WithoutCancel(ctx)
+		cipher = &spentBlob{ReadCloser: cipher, delete: func() { _ = s.blobs.Delete(ctx, blob) }}
+	}
+	return n, cipher, nil
+}
+
+// Update changes the record of a note. The ciphertext of notes in the blob store is neither
+// passed to update nor returned, and the blob of a note that update spent is deleted.
+// A ciphertext set by update is saved like in Save, and replaces the blob of the note.
+func (s *SplitStore) Update(ctx context.Context, id, hash string, update func(*Note) error) (Note, error) {
+	var replaced string
+	n, err := s.store.Update(ctx, id, hash, func(n *Note) error {
+		blob := n.Blob
+		if err := update(n); err != nil {
+			return err
+		}
+
+		// Notes in the blob store are passed without a ciphertext, so one was set by update
+		switch {
+		case n.Cipher == nil:
+		case len(n.Cipher) >= s.threshold:
+			key := BlobKey(n.ID, n.Cipher)
+			if err := s.put(ctx, key, bytes.NewReader(n.Cipher), int64(len(n.Cipher)), n.Expiry()); err != nil {
+				return err
+			}
+			n.Blob = key
+			n.Cipher = nil
+		default:
+			n.Blob = ""
+		}
+		replaced = ""
+		if blob != n.Blob {
+			replaced = blob
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	// Like in View, blobs that can't be deleted now are left to expire in the blob store
+	if replaced != "" {
+		_ = s.blobs.Delete(ctx, replaced)
+	}
+	if n.Spent() && n.Blob != "" {
+		_ = s.blobs.Delete(ctx, n.Blob)
+	}
+	return n, nil
+}
+
+// Scan passes each note that hasn't expired to fn. Notes in the blob store are passed without
+// their ciphertext.
+func (s *SplitStore) Scan(ctx context.Context, fn func(Note) error) error {
+	return s.store.Scan(ctx, fn)
+}
+
+// lazyBlob is a blob opened on its first read, so that streams that are never read cost nothing
+type lazyBlob struct {
+	open func() (io.ReadCloser, error)
+	r    io.ReadCloser
+	err  error
+}
+
+// Read implements the io.Reader interface
+func (b *lazyBlob) Read(p []byte) (int, error) {
+	if b.r == nil && b.err == nil {
+		b.r, b.err = b.open()
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.r.Read(p)
+}
+
+// Close closes the blob if it was opened
+func (b *lazyBlob) Close() error {
+	if b.r == nil {
+		return nil
+	}
+	return b.r.Close()
+}
+
+// spentBlob deletes the blob of a spent note once its last reader is done with it
+type spentBlob struct {
+	io.ReadCloser
+	delete func()
+}
+
+// Close closes the blob, then deletes it
+func (b *spentBlob) Close() error {
+	err := b.ReadCloser.Close()
+	b.delete()
+	return err
+}
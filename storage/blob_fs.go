@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FSBlobStore implements the BlobStore interface with files in a local directory.
+// Blobs are spread over subdirectories named after the first two characters of their key,
+// with a directory per note.
+// The modification time of a blob file is its expiry time, used by Sweep.
+type FSBlobStore struct {
+	dir string
+	now func() time.Time
+}
+
+// NewFSBlobStore creates an FSBlobStore in the given directory, creating it if needed
+func NewFSBlobStore(dir string) (*FSBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &FSBlobStore{dir: dir, now: time.Now}, nil
+}
+
+// path returns the path of the file of a blob
+func (s *FSBlobStore) path(key string) string {
+	return filepath.Join(s.dir, key[:2], key)
+}
+
+// Put streams a blob to a file. The file is written under a temporary name and renamed,
+// so readers never see a partial blob. It keeps the later expiry time of an existing file.
+func (s *FSBlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, expiresAt time.Time) error {
+	if err := checkBlobKey(key); err != nil {
+		return err
+	}
+
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	content := newHashingReader(r)
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := content.check(key, size); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if info, err := os.Stat(path); err == nil && info.ModTime().After(expiresAt) {
+		expiresAt = info.ModTime()
+	}
+	if err := os.Chtimes(tmp.Name(), expiresAt, expiresAt); err != nil {
+		return fmt.Errorf("failed to set blob expiry: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save blob: %w", err)
+	}
+	return nil
+}
+
+// Get opens the file of a blob
+func (s *FSBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkBlobKey(key); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read blob: %w", err)
+	}
+	return newBlobReader(f, key), nil
+}
+
+// Delete removes the file of a blob
+func (s *FSBlobStore) Delete(ctx context.Context, key string) error {
+	if err := checkBlobKey(key); err != nil {
+		return err
+	}
+
+	if err := s.remove(s.path(key)); err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return nil
+}
+
+// remove removes the file of a blob, and the directory of its note once it is empty
+func (s *FSBlobStore) remove(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	// Other blobs of the note, or a concurrent Put, keep the directory
+	if dir := filepath.Dir(path); filepath.Dir(filepath.Dir(dir)) == filepath.Clean(s.dir) {
+		_ = os.Remove(dir)
+	}
+	return nil
+}
+
+// Sweep removes the blobs whose expiry time has passed and returns how many were removed
+func (s *FSBlobStore) Sweep(ctx context.Context) (int, error) {
+	now := s.now()
+	removed := 0
+
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if info.ModTime().After(now) {
+			return nil
+		}
+
+		if err := s.remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to sweep blobs: %w", err)
+	}
+
+	return removed, nil
+}
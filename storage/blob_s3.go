@@ -0,0 +1,406 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config holds the parameters of an S3-compatible bucket
+type S3Config struct {
+	Endpoint  string // Base URL of the service, e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Bucket    string
+	Region    string // Signing region, default: us-east-1
+	AccessKey string
+	SecretKey string
+	Prefix    string // Optional prefix of the object keys, e.g. "notes/"
+}
+
+// S3BlobStore implements the BlobStore interface with objects in an S3-compatible bucket,
+// such as AWS S3 or MinIO. Requests use path-style URLs and AWS Signature Version 4.
+// The expiry time is recorded in the x-amz-meta-expires-at header of each object,
+// and read back by Sweep: bucket lifecycle rules can't expire objects by metadata.
+type S3BlobStore struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3BlobStore creates an S3BlobStore. If client is nil, http.DefaultClient is used.
+func NewS3BlobStore(cfg S3Config, client *http.Client) (*S3BlobStore, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("missing S3 bucket")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &S3BlobStore{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   client,
+		now:      time.Now,
+	}, nil
+}
+
+// Put streams a blob to the bucket. The payload hash sent for signing is the content hash
+// of the key, so the service also checks the integrity of the upload. The later expiry time
+// of an existing object is kept, so each Put costs two requests: a HEAD, then the PUT.
+func (s *S3BlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, expiresAt time.Time) error {
+	if err := checkBlobKey(key); err != nil {
+		return err
+	}
+
+	current, err := s.expiry(ctx, key)
+	if err != nil && err != ErrNotFound {
+		return fmt.Errorf("failed to save blob: %w", err)
+	}
+	if current.After(expiresAt) {
+		expiresAt = current
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("X-Amz-Meta-Expires-At", expiresAt.UTC().Format(time.RFC3339))
+
+	resp, err := s.do(ctx, http.MethodPut, s.objectPath(key), nil, header, r, size, keyHash(key))
+	if err != nil {
+		return fmt.Errorf("failed to save blob: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// The service rejects uploads that don't match their payload hash once they are sent
+		err := s3Error(resp)
+		if err.Code == "XAmzContentSHA256Mismatch" || err.Code == "BadDigest" {
+			return ErrHashMismatch
+		}
+		return fmt.Errorf("failed to save blob: %w", err)
+	}
+	return nil
+}
+
+// Get opens a blob, whose content is checked as it is read
+func (s *S3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkBlobKey(key); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(ctx, http.MethodGet, s.objectPath(key), nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return newBlobReader(resp.Body, key), nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("failed to get blob: %w", s3Error(resp))
+	}
+}
+
+// Delete removes a blob. S3 also answers with success for missing objects.
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	if err := checkBlobKey(key); err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodDelete, s.objectPath(key), nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to delete blob: %w", s3Error(resp))
+	}
+	return nil
+}
+
+// Sweep lists the objects under the prefix and removes those whose expiry time has passed.
+// Objects that are not blobs, or have no expiry time, are left alone.
+func (s *S3BlobStore) Sweep(ctx context.Context) (int, error) {
+	now := s.now()
+	removed := 0
+
+	token := ""
+	for {
+		page, err := s.list(ctx, token)
+		if err != nil {
+			return removed, fmt.Errorf("failed to sweep blobs: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			key := strings.TrimPrefix(object.Key, s.cfg.Prefix)
+			if checkBlobKey(key) != nil {
+				continue
+			}
+
+			expiresAt, err := s.expiry(ctx, key)
+			if err == ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return removed, fmt.Errorf("failed to sweep blobs: %w", err)
+			}
+			if expiresAt.IsZero() || expiresAt.After(now) {
+				continue
+			}
+
+			if err := s.Delete(ctx, key); err != nil {
+				return removed, fmt.Errorf("failed to sweep blobs: %w", err)
+			}
+			removed++
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return removed, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// listBucketResult is the response of ListObjectsV2
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list returns a page of the objects under the prefix, starting at a continuation token
+func (s *S3BlobStore) list(ctx context.Context, token string) (listBucketResult, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", s.cfg.Prefix)
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+
+	resp, err := s.do(ctx, http.MethodGet, "/"+s.cfg.Bucket, query, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return listBucketResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return listBucketResult{}, s3Error(resp)
+	}
+	var page listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return listBucketResult{}, fmt.Errorf("invalid object list: %w", err)
+	}
+	return page, nil
+}
+
+// expiry returns the expiry time of an object, zero if it has none, or ErrNotFound
+func (s *S3BlobStore) expiry(ctx context.Context, key string) (time.Time, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.objectPath(key), nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return time.Time{}, ErrNotFound
+	default:
+		return time.Time{}, s3Error(resp)
+	}
+
+	value := resp.Header.Get("X-Amz-Meta-Expires-At")
+	if value == "" {
+		return time.Time{}, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry time %q of blob %s", value, key)
+	}
+	return expiresAt, nil
+}
+
+// emptyPayloadHash is the hex SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// objectPath returns the path of the object of a blob
+func (s *S3BlobStore) objectPath(key string) string {
+	return "/" + s.cfg.Bucket + "/" + s.cfg.Prefix + key
+}
+
+// do sends a signed request for a path of the service, with a body of size bytes
+func (s *S3BlobStore) do(ctx context.Context, method, path string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = u.Path + path
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.ContentLength = size
+
+	signV4(req, payloadHash, s.cfg.Region, "s3", s.cfg.AccessKey, s.cfg.SecretKey, s.now())
+	return s.client.Do(req)
+}
+
+// s3ResponseError is a failed S3 response, with the error code of its body if it has one
+type s3ResponseError struct {
+	Status string
+	Code   string
+	Body   []byte
+}
+
+func (e *s3ResponseError) Error() string {
+	return fmt.Sprintf("unexpected status %s: %s", e.Status, e.Body)
+}
+
+// s3Error returns an error describing a failed S3 response
+func s3Error(resp *http.Response) *s3ResponseError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	var doc struct {
+		Code string
+	}
+	xml.Unmarshal(body, &doc) // Bodies that aren't S3 errors leave the code empty
+	return &s3ResponseError{Status: resp.Status, Code: doc.Code, Body: bytes.TrimSpace(body)}
+}
+
+// signV4 signs a request with AWS Signature Version 4.
+// The Host, X-Amz-Date, X-Amz-Content-Sha256 and X-Amz-Meta-* headers are signed.
+func signV4(req *http.Request, payloadHash, region, service, accessKey, secretKey string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	canonicalRequest, signedHeaders := canonicalV4Request(req, payloadHash)
+	stringToSign := stringToSignV4(amzDate, scope, canonicalRequest)
+
+	key := signingKeyV4(secretKey, date, region, service)
+	signature := hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalV4Request returns the canonical form of a request and its signed header names
+func canonicalV4Request(req *http.Request, payloadHash string) (canonical, signedHeaders string) {
+	// Incoming requests carry the host in req.Host, outgoing ones in the URL
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "x-amz-date" || lower == "x-amz-content-sha256" || strings.HasPrefix(lower, "x-amz-meta-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders = strings.Join(names, ";")
+
+	// Query parameters are sorted by name, then value
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, awsEscape(name, true)+"="+awsEscape(value, true))
+		}
+	}
+	sort.Strings(params)
+
+	path := req.URL.EscapedPath()
+	if u, err := url.PathUnescape(path); err == nil {
+		path = awsEscape(u, false)
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	canonical = strings.Join([]string{
+		req.Method,
+		path,
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	return canonical, signedHeaders
+}
+
+// stringToSignV4 returns the string signed for a canonical request
+func stringToSignV4(amzDate, scope, canonicalRequest string) string {
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	return "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+}
+
+// awsEscape percent-encodes every byte but the unreserved characters, and the slash in paths
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signingKeyV4 derives the signing key of a day, region and service
+func signingKeyV4(secretKey, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretKey), []byte(date))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(service))
+	return hmacSHA256(key, []byte("aws4_request"))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
+)
+
+// putBlob stores data as a blob
+func putBlob(ctx context.Context, blobs BlobStore, key string, data []byte, expiresAt time.Time) error {
+	return blobs.Put(ctx, key, bytes.NewReader(data), int64(len(data)), expiresAt)
+}
+
+// getBlob reads a blob
+func getBlob(ctx context.Context, blobs BlobStore, key string) ([]byte, error) {
+	r, err := blobs.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// testBlobStore checks the behaviour shared by all BlobStore implementations
+func testBlobStore(t *testing.T, blobs BlobStore) {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("ciphertext"), 10000)
+	key := BlobKey(uuid.New().String(), data)
+	expiresAt := time.Now().Add(time.Hour)
+
+	if _, err := getBlob(ctx, blobs, key); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got: %v", err)
+	}
+
+	if err := putBlob(ctx, blobs, key, data, expiresAt); err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	// Putting the same blob again only extends its expiry
+	if err := putBlob(ctx, blobs, key, data, expiresAt.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to put blob again: %v", err)
+	}
+
+	got, err := getBlob(ctx, blobs, key)
+	if err != nil {
+		t.Fatalf("Failed to get blob: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Blob content differs: got %d bytes, want %d", len(got), len(data))
+	}
+
+	// Blobs are content-addressed
+	if err := putBlob(ctx, blobs, key, []byte("other data"), expiresAt); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("Expected ErrHashMismatch, got: %v", err)
+	}
+	if err := blobs.Put(ctx, key, bytes.NewReader(data), int64(len(data))+1, expiresAt); err == nil {
+		t.Errorf("Expected an error for a blob shorter than its size")
+	}
+	for _, invalid := range []string{"../../etc/passwd", "../" + key, "/" + keyHash(key), strings.ToUpper(key)} {
+		if err := putBlob(ctx, blobs, invalid, data, expiresAt); err == nil {
+			t.Errorf("Expected an error for the invalid key %q", invalid)
+		}
+	}
+
+	// Notes with the same ciphertext have their own blob
+	other := BlobKey(uuid.New().String(), data)
+	if err := putBlob(ctx, blobs, other, data, expiresAt); err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	if err := blobs.Delete(ctx, other); err != nil {
+		t.Fatalf("Failed to delete blob: %v", err)
+	}
+
+	if err := blobs.Delete(ctx, key); err != nil {
+		t.Fatalf("Failed to delete blob: %v", err)
+	}
+	if _, err := getBlob(ctx, blobs, key); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound after delete, got: %v", err)
+	}
+	if err := blobs.Delete(ctx, key); err != nil {
+		t.Errorf("Expected deleting a missing blob to succeed, got: %v", err)
+	}
+}
+
+func TestFSBlobStore(t *testing.T) {
+	blobs, err := NewFSBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create FSBlobStore: %v", err)
+	}
+	testBlobStore(t, blobs)
+
+	// Blobs changed on disk are detected when read
+	ctx := context.Background()
+	data := []byte("ciphertext")
+	key := BlobKey(uuid.New().String(), data)
+	if err := putBlob(ctx, blobs, key, data, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	if err := os.WriteFile(blobs.path(key), []byte("tampered!!"), 0644); err != nil {
+		t.Fatalf("Failed to change blob: %v", err)
+	}
+	if _, err := getBlob(ctx, blobs, key); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("Expected ErrHashMismatch, got: %v", err)
+	}
+}
+
+func TestFSBlobStoreSweep(t *testing.T) {
+	blobs, err := NewFSBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create FSBlobStore: %v", err)
+	}
+
+	expired := testBlobStoreSweep(t, blobs, func(now time.Time) { blobs.now = func() time.Time { return now } })
+	if _, err := os.Stat(filepath.Dir(blobs.path(expired))); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the directory of the expired blob to be removed, got: %v", err)
+	}
+}
+
+// testBlobStoreSweep checks that Sweep removes expired blobs and only them, with setNow
+// changing the clock of the store. It returns the key of the removed blob.
+func testBlobStoreSweep(t *testing.T, blobs BlobStore, setNow func(time.Time)) string {
+	ctx := context.Background()
+	now := time.Now()
+	expired := BlobKey(uuid.New().String(), []byte("expired"))
+	live := BlobKey(uuid.New().String(), []byte("live"))
+	if err := putBlob(ctx, blobs, expired, []byte("expired"), now.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	if err := putBlob(ctx, blobs, live, []byte("live"), now.Add(3*time.Hour)); err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	// Putting a blob again with an earlier expiry doesn't shorten its life
+	if err := putBlob(ctx, blobs, live, []byte("live"), now.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+
+	// Two hours later, only the first blob has expired
+	setNow(now.Add(2 * time.Hour))
+	removed, err := blobs.Sweep(ctx)
+	if err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 blob to be removed, got %d", removed)
+	}
+
+	if _, err := getBlob(ctx, blobs, expired); err != ErrNotFound {
+		t.Errorf("Expected the expired blob to be removed, got: %v", err)
+	}
+	if _, err := getBlob(ctx, blobs, live); err != nil {
+		t.Errorf("Expected the live blob to be kept, got: %v", err)
+	}
+	return expired
+}
+
+func TestSplitStore(t *testing.T) {
+	db, err := NewBadgerStore(badger.DefaultOptions("").WithInMemory(true))
+	if err != nil {
+		t.Fatalf("Failed to create BadgerStore: %v", err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	blobs, err := NewFSBlobStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create FSBlobStore: %v", err)
+	}
+	store := NewSplitStore(db, blobs, 1024)
+
+	ctx := context.Background()
+	small := Note{ID: uuid.New().String(), Cipher: []byte("small"), UnlockAt: time.Now().Add(time.Hour)}
+	small.Hash = keyHash(BlobKey(small.ID, small.Cipher))
+	large := Note{ID: uuid.New().String(), Cipher: bytes.Repeat([]byte("large"), 1000), UnlockAt: time.Now().Add(time.Hour)}
+	large.Hash = keyHash(BlobKey(large.ID, large.Cipher))
+
+	for _, note := range []Note{small, large} {
+		if err := store.Save(ctx, note); err != nil {
+			t.Fatalf("Failed to save note: %v", err)
+		}
+		got, err := store.Get(ctx, note.ID, note.Hash)
+		if err != nil {
+			t.Fatalf("Failed to get note: %v", err)
+		}
+		if !bytes.Equal(got.Cipher, note.Cipher) {
+			t.Errorf("Cipher differs for a note of %d bytes", len(note.Cipher))
+		}
+	}
+
+	// Only the metadata of the large note is in Badger, with a reference to its blob
+	record, err := db.Get(ctx, large.ID, large.Hash)
+	if err != nil {
+		t.Fatalf("Failed to get record: %v", err)
+	}
+	if len(record.Cipher) != 0 || record.Blob != large.ID+"/"+large.Hash {
+		t.Errorf("Expected a blob reference in the record, got %d bytes and blob %q", len(record.Cipher), record.Blob)
+	}
+	if _, err := os.Stat(filepath.Join(dir, large.ID[:2], large.ID, large.Hash)); err != nil {
+		t.Errorf("Expected the blob file to exist: %v", err)
+	}
+
+	record, err = db.Get(ctx, small.ID, small.Hash)
+	if err != nil {
+		t.Fatalf("Failed to get record: %v", err)
+	}
+	if record.Blob != "" || !bytes.Equal(record.Cipher, small.Cipher) {
+		t.Errorf("Expected a small note to be stored inline, got blob %q", record.Blob)
+	}
+
+	if _, err := store.Get(ctx, uuid.New().String(), large.Hash); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got: %v", err)
+	}
+	// The blob of a note spent by a streamed view is kept until the stream is closed
+	large.MaxViews = 1
+	if err := store.Save(ctx, large); err != nil {
+		t.Fatalf("Failed to save note: %v", err)
+	}
+	_, stream, err := store.ViewStream(ctx, large.ID, large.Hash, func(Note, io.Reader) error { return nil })
+	if err != nil {
+		t.Fatalf("Failed to view note: %v", err)
+	}
+	data, err := io.ReadAll(stream)
+	if err != nil || !bytes.Equal(data, large.Cipher) {
+		t.Errorf("Unexpected ciphertext of %d bytes: %v", len(data), err)
+	}
+	if _, err := getBlob(ctx, blobs, large.ID+"/"+large.Hash); err != nil {
+		t.Errorf("Expected the blob to be kept while it is read, got: %v", err)
+	}
+	stream.Close()
+	if _, err := getBlob(ctx, blobs, large.ID+"/"+large.Hash); err != ErrNotFound {
+		t.Errorf("Expected the blob to be deleted once read, got: %v", err)
+	}
+}
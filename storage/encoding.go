@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Records of notes start with a header: a magic byte, then the version of the encoding.
+// Records written before the header existed are JSON objects, so they start with '{'.
+const (
+	recordMagic = 0xD7
+
+	// recordVersionCBOR is a CBOR map of noteRecord with integer keys
+	recordVersionCBOR = 1
+
+	// recordVersion is the version written by encodeNote
+	recordVersion = recordVersionCBOR
+)
+
+// ErrUnknownRecord is returned for records in an unknown encoding
+var ErrUnknownRecord = errors.New("unknown note record encoding")
+
+// noteRecord is the CBOR schema of a note. Fields are only ever added, with new keys,
+// so older versions of the server skip the fields they don't know.
+type noteRecord struct {
+	ID          string    `cbor:"1,keyasint"`
+	Hash        string    `cbor:"2,keyasint"`
+	Cipher      []byte    `cbor:"3,keyasint,omitempty"`
+	Round       uint64    `cbor:"4,keyasint"`
+	UnlockAt    time.Time `cbor:"5,keyasint"`
+	Version     byte      `cbor:"6,keyasint,omitempty"`
+	FragmentKey bool      `cbor:"7,keyasint,omitempty"`
+	Meta        []byte    `cbor:"8,keyasint,omitempty"`
+	Blob        string    `cbor:"9,keyasint,omitempty"`
+
+	// Key 10 is reserved: it held a burn after reading flag, replaced by MaxViews.
+	TokenHash string `cbor:"11,keyasint,omitempty"`
+
+	ExpiresAt time.Time `cbor:"12,keyasint,omitempty"`
+	Views     uint32    `cbor:"13,keyasint,omitempty"`
+	MaxViews  uint32    `cbor:"14,keyasint,omitempty"`
+
+	Passphrase    bool      `cbor:"15,keyasint,omitempty"`
+	Attempts      uint32    `cbor:"16,keyasint,omitempty"`
+	AttemptsSince time.Time `cbor:"17,keyasint,omitempty"`
+
+	Approvals uint32           `cbor:"18,keyasint,omitempty"`
+	Approvers []approverRecord `cbor:"19,keyasint,omitempty"`
+
+	CheckinInterval time.Duration `cbor:"20,keyasint,omitempty"`
+	OwnerCipher     []byte        `cbor:"21,keyasint,omitempty"`
+}
+
+// approverRecord is the CBOR schema of an approver of a note
+type approverRecord struct {
+	TokenHash string `cbor:"1,keyasint"`
+	Share     []byte `cbor:"2,keyasint"`
+	Released  bool   `cbor:"3,keyasint,omitempty"`
+}
+
+// cborEncMode encodes times as RFC 3339 strings, so they keep their nanoseconds
+var cborEncMode = func() cbor.EncMode {
+	mode, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}()
+
+// encodeNote encodes a note as a record with the current version
+func encodeNote(n Note) ([]byte, error) {
+	payload, err := cborEncMode.Marshal(noteRecord{
+		ID:          n.ID,
+		Hash:        n.Hash,
+		Cipher:      n.Cipher,
+		Round:       n.Round,
+		UnlockAt:    n.UnlockAt,
+		Version:     n.Version,
+		FragmentKey: n.FragmentKey,
+		Meta:        n.Meta,
+		Blob:        n.Blob,
+
+		TokenHash: n.TokenHash,
+		ExpiresAt: n.ExpiresAt,
+		Views:     n.Views,
+		MaxViews:  n.MaxViews,
+
+		Passphrase:    n.Passphrase,
+		Attempts:      n.Attempts,
+		AttemptsSince: n.AttemptsSince,
+
+		Approvals: n.Approvals,
+		Approvers: encodeApprovers(n.Approvers),
+
+		CheckinInterval: n.CheckinInterval,
+		OwnerCipher:     n.OwnerCipher,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode note: %w", err)
+	}
+
+	return append([]byte{recordMagic, recordVersion}, payload...), nil
+}
+
+// decodeNote decodes a record of any version, including the JSON records without a header
+func decodeNote(data []byte) (Note, error) {
+	if len(data) > 0 && data[0] == '{' {
+		var n Note
+		if err := json.Unmarshal(data, &n); err != nil {
+			return Note{}, fmt.Errorf("failed to decode JSON note: %w", err)
+		}
+		return n, nil
+	}
+
+	if len(data) < 2 || data[0] != recordMagic {
+		return Note{}, ErrUnknownRecord
+	}
+
+	switch data[1] {
+	case recordVersionCBOR:
+		var r noteRecord
+		if err := cbor.Unmarshal(data[2:], &r); err != nil {
+			return Note{}, fmt.Errorf("failed to decode note: %w", err)
+		}
+		return Note{
+			ID:          r.ID,
+			Hash:        r.Hash,
+			Cipher:      r.Cipher,
+			Meta:        r.Meta,
+			Blob:        r.Blob,
+			Round:       r.Round,
+			UnlockAt:    r.UnlockAt,
+			Version:     r.Version,
+			FragmentKey: r.FragmentKey,
+
+			TokenHash: r.TokenHash,
+			ExpiresAt: r.ExpiresAt,
+			Views:     r.Views,
+			MaxViews:  r.MaxViews,
+
+			Passphrase:    r.Passphrase,
+			Attempts:      r.Attempts,
+			AttemptsSince: r.AttemptsSince,
+
+			Approvals: r.Approvals,
+			Approvers: decodeApprovers(r.Approvers),
+
+			CheckinInterval: r.CheckinInterval,
+			OwnerCipher:     r.OwnerCipher,
+		}, nil
+	default:
+		return Note{}, fmt.Errorf("%w: version %d", ErrUnknownRecord, data[1])
+	}
+}
+
+// encodeApprovers converts the approvers of a note to their records
+func encodeApprovers(approvers []Approver) []approverRecord {
+	if len(approvers) == 0 {
+		return nil
+	}
+	records := make([]approverRecord, len(approvers))
+	for i, a := range approvers {
+		records[i] = approverRecord{TokenHash: a.TokenHash, Share: a.Share, Released: a.Released}
+	}
+	return records
+}
+
+// decodeApprovers converts approver records to the approvers of a note
+func decodeApprovers(records []approverRecord) []Approver {
+	if len(records) == 0 {
+		return nil
+	}
+	approvers := make([]Approver, len(records))
+	for i, r := range records {
+		approvers[i] = Approver{TokenHash: r.TokenHash, Share: r.Share, Released: r.Released}
+	}
+	return approvers
+}
+
+// isCurrentRecord reports whether a record is encoded with the current version
+func isCurrentRecord(data []byte) bool {
+	return len(data) >= 2 && data[0] == recordMagic && data[1] == recordVersion
+}
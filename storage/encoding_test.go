@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
+)
+
+// testNote returns a note with every field set
+func testNote() Note {
+	return Note{
+		ID:          uuid.New().String(),
+		Hash:        "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		Cipher:      []byte("encrypted data"),
+		Meta:        []byte("encrypted metadata"),
+		Blob:        "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210",
+		Round:       12345,
+		UnlockAt:    time.Date(2030, 1, 2, 3, 4, 5, 6, time.UTC),
+		Version:     1,
+		FragmentKey: true,
+
+		TokenHash: "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff",
+		ExpiresAt: time.Date(2030, 1, 3, 3, 4, 5, 6, time.UTC),
+		Views:     2,
+		MaxViews:  3,
+
+		Passphrase:    true,
+		Attempts:      4,
+		AttemptsSince: time.Date(2030, 1, 2, 4, 5, 6, 7, time.UTC),
+
+		Approvals: 1,
+		Approvers: []Approver{
+			{TokenHash: "ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100", Share: []byte("wrapped share")},
+			{TokenHash: "00ffeeddccbbaa99887766554433221100ffeeddccbbaa998877665544332211", Share: []byte("share"), Released: true},
+		},
+
+		CheckinInterval: 24 * time.Hour,
+		OwnerCipher:     []byte("owner copy"),
+	}
+}
+
+func TestEncodeDecodeNote(t *testing.T) {
+	note := testNote()
+
+	data, err := encodeNote(note)
+	if err != nil {
+		t.Fatalf("encodeNote failed: %v", err)
+	}
+	if data[0] != recordMagic || data[1] != recordVersion {
+		t.Errorf("Unexpected record header: %x", data[:2])
+	}
+
+	decoded, err := decodeNote(data)
+	if err != nil {
+		t.Fatalf("decodeNote failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, note) {
+		t.Errorf("Decoded note differs.\nGot:  %+v\nWant: %+v", decoded, note)
+	}
+
+	// The binary encoding doesn't inflate the ciphertext like base64 in JSON
+	jsonData, _ := json.Marshal(note)
+	if len(data) >= len(jsonData) {
+		t.Errorf("Expected the record (%d bytes) to be smaller than JSON (%d bytes)", len(data), len(jsonData))
+	}
+}
+
+func TestDecodeNoteJSON(t *testing.T) {
+	note := testNote()
+
+	// Records written before the binary encoding
+	data, err := json.Marshal(note)
+	if err != nil {
+		t.Fatalf("Failed to marshal note: %v", err)
+	}
+
+	decoded, err := decodeNote(data)
+	if err != nil {
+		t.Fatalf("decodeNote failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, note) {
+		t.Errorf("Decoded note differs.\nGot:  %+v\nWant: %+v", decoded, note)
+	}
+}
+
+func TestDecodeNoteErrors(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":           {},
+		"unknown magic":   {0x00, recordVersion},
+		"unknown version": {recordMagic, 0xFF},
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decodeNote(data); !errors.Is(err, ErrUnknownRecord) {
+				t.Errorf("Expected ErrUnknownRecord, got: %v", err)
+			}
+		})
+	}
+
+	if _, err := decodeNote([]byte{recordMagic, recordVersion, 0xFF}); err == nil {
+		t.Errorf("Expected an error for a truncated record")
+	}
+}
+
+func TestBadgerStoreMigrate(t *testing.T) {
+	store, err := NewBadgerStore(badger.DefaultOptions("").WithInMemory(true))
+	if err != nil {
+		t.Fatalf("Failed to create BadgerStore: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	legacy := testNote()
+	current := testNote()
+	expiresAt := uint64(time.Now().Add(time.Hour).Unix())
+
+	// A JSON record as written by older versions, and a cached beacon
+	data, _ := json.Marshal(legacy)
+	err = store.db.Update(func(txn *badger.Txn) error {
+		entry := badger.NewEntry([]byte(legacy.ID+":"+legacy.Hash), data)
+		entry.ExpiresAt = expiresAt
+		return txn.SetEntry(entry)
+	})
+	if err != nil {
+		t.Fatalf("Failed to write JSON record: %v", err)
+	}
+	if err := store.SaveSignature("chain", 1, []byte("signature")); err != nil {
+		t.Fatalf("Failed to save signature: %v", err)
+	}
+	if err := store.Save(ctx, current); err != nil {
+		t.Fatalf("Failed to save note: %v", err)
+	}
+
+	migrated, err := store.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if migrated != 1 {
+		t.Errorf("Expected 1 migrated note, got %d", migrated)
+	}
+
+	err = store.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(legacy.ID + ":" + legacy.Hash))
+		if err != nil {
+			return err
+		}
+		if item.ExpiresAt() != expiresAt {
+			t.Errorf("Expected the expiry to be kept. Got: %d, Want: %d", item.ExpiresAt(), expiresAt)
+		}
+		return item.Value(func(val []byte) error {
+			if !isCurrentRecord(val) {
+				t.Errorf("Expected the record to be re-encoded, got: %q", val)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("Failed to read record: %v", err)
+	}
+
+	got, err := store.Get(ctx, legacy.ID, legacy.Hash)
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	if !reflect.DeepEqual(got, legacy) {
+		t.Errorf("Migrated note differs.\nGot:  %+v\nWant: %+v", got, legacy)
+	}
+
+	// Signatures are not notes, and a second run has nothing to do
+	if sig, ok, err := store.LoadSignature("chain", 1); err != nil || !ok || string(sig) != "signature" {
+		t.Errorf("Expected the signature to be kept, got %q, %v, %v", sig, ok, err)
+	}
+	if migrated, err := store.Migrate(ctx); err != nil || migrated != 0 {
+		t.Errorf("Expected nothing to migrate, got %d: %v", migrated, err)
+	}
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/glebarez/go-sqlite"
+	_ "github.com/lib/pq"
+)
+
+func TestSQLiteStoreSweep(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "notes.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLiteStore: %v", err)
+	}
+	defer store.Close()
+
+	testSQLStoreSweep(t, store)
+}
+
+func TestPostgresStoreSweep(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+
+	store, err := NewPostgresStore(dsn)
+	if err != nil {
+		t.Fatalf("Failed to create PostgresStore: %v", err)
+	}
+	defer store.Close()
+
+	testSQLStoreSweep(t, store)
+}
+
+// testSQLStoreSweep checks that expired notes are deleted by Sweep, and only them
+func testSQLStoreSweep(t *testing.T, store *SQLStore) {
+	ctx := context.Background()
+	now := time.Now()
+
+	expired := testNote()
+	expired.UnlockAt = now.Add(time.Hour)
+	expired.ExpiresAt = time.Time{}
+	live := testNote()
+	live.UnlockAt = now.Add(time.Hour)
+	live.ExpiresAt = now.Add(30 * 24 * time.Hour)
+	for _, note := range []Note{expired, live} {
+		if err := store.Save(ctx, note); err != nil {
+			t.Fatalf("Failed to save note: %v", err)
+		}
+	}
+
+	store.SetClock(func() time.Time { return expired.UnlockAt.Add(Retention + time.Minute) })
+	swept, err := store.Sweep(ctx)
+	if err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+	if swept != 1 {
+		t.Errorf("Expected 1 swept note, got %d", swept)
+	}
+
+	for id, want := range map[string]int{expired.ID: 0, live.ID: 1} {
+		var count int
+		err = store.db.QueryRow(store.dialect.rebind(`SELECT COUNT(*) FROM notes WHERE id = $1`), id).Scan(&count)
+		if err != nil || count != want {
+			t.Errorf("Expected %d rows for note %s, got %d: %v", want, id, count, err)
+		}
+	}
+}
@@ -0,0 +1,820 @@
+// Package storagetest provides a conformance test suite for storage.Store implementations.
+//
+// A backend proves it behaves like the built-in stores by running the suite from its tests:
+//
+//	func TestMyStore(t *testing.T) {
+//		storagetest.Run(t, func() storage.Store { return newMyStore(t) })
+//	}
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/korjavin/drand-poc/storage"
+)
+
+// Clock is implemented by stores whose notion of the current time can be replaced.
+// The expiry tests are skipped for stores that don't implement it.
+type Clock interface {
+	SetClock(now func() time.Time)
+}
+
+// SignatureStore is implemented by stores that also cache drand beacon signatures.
+// The signature tests are skipped for stores that don't implement it.
+type SignatureStore interface {
+	LoadSignature(chainHash string, round uint64) ([]byte, bool, error)
+	SaveSignature(chainHash string, round uint64, signature []byte) error
+}
+
+// LargeNoteSize is the size of the ciphertext saved by the large value test
+const LargeNoteSize = 4 << 20
+
+// Run runs the conformance suite. newStore is called once per subtest and must return
+// an empty store; stores implementing io.Closer are closed at the end of the subtest.
+//
+// Missing notes must be reported with storage.ErrNotFound itself, not a wrapped error,
+// and the operations must fail with the context error once their context is done.
+func Run(t *testing.T, newStore func() storage.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store storage.Store)
+	}{
+		{"NotFound", testNotFound},
+		{"SaveGet", testSaveGet},
+		{"HashMismatch", testHashMismatch},
+		{"Replace", testReplace},
+		{"Expiry", testExpiry},
+		{"ConcurrentWriters", testConcurrentWriters},
+		{"LargeNote", testLargeNote},
+		{"ContextCanceled", testContextCanceled},
+		{"Delete", testDelete},
+		{"View", testView},
+		{"ConcurrentViews", testConcurrentViews},
+		{"Update", testUpdate},
+		{"ConcurrentUpdates", testConcurrentUpdates},
+		{"UpdateCipher", testUpdateCipher},
+		{"Streams", testStreams},
+		{"Scan", testScan},
+		{"Signatures", testSignatures},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newStore()
+			if closer, ok := store.(io.Closer); ok {
+				t.Cleanup(func() {
+					if err := closer.Close(); err != nil {
+						t.Errorf("Failed to close store: %v", err)
+					}
+				})
+			}
+			tt.test(t, store)
+		})
+	}
+}
+
+// NewNote returns a note with every field set, unlocking in an hour
+func NewNote() storage.Note {
+	cipher := make([]byte, 64)
+	rand.Read(cipher)
+	return noteWithCipher(cipher)
+}
+
+// noteWithCipher returns a note with the given ciphertext and its hash
+func noteWithCipher(cipher []byte) storage.Note {
+	sum := sha256.Sum256(cipher)
+	return storage.Note{
+		ID:       uuid.New().String(),
+		Hash:     hex.EncodeToString(sum[:]),
+		Cipher:   cipher,
+		Meta:     []byte("encrypted metadata"),
+		Round:    12345,
+		UnlockAt: time.Now().Add(time.Hour).UTC().Truncate(time.Second),
+		Version:  1,
+
+		TokenHash:  hex.EncodeToString(sum[:]),
+		Passphrase: true,
+
+		Approvals: 1,
+		Approvers: []storage.Approver{
+			{TokenHash: hex.EncodeToString(sum[:]), Share: []byte("wrapped share")},
+			{TokenHash: hex.EncodeToString(sum[:]), Share: []byte("share"), Released: true},
+		},
+
+		CheckinInterval: 24 * time.Hour,
+		OwnerCipher:     []byte("owner copy"),
+	}
+}
+
+// checkNote reports the fields of a retrieved note that differ from the saved one.
+// Blob is set by the store, so it isn't compared.
+func checkNote(t *testing.T, got, want storage.Note) {
+	t.Helper()
+	if got.ID != want.ID || got.Hash != want.Hash || !bytes.Equal(got.Cipher, want.Cipher) ||
+		!bytes.Equal(got.Meta, want.Meta) || got.Round != want.Round || !got.UnlockAt.Equal(want.UnlockAt) ||
+		got.Version != want.Version || got.FragmentKey != want.FragmentKey ||
+		got.TokenHash != want.TokenHash || !got.ExpiresAt.Equal(want.ExpiresAt) ||
+		got.Views != want.Views || got.MaxViews != want.MaxViews || got.Passphrase != want.Passphrase ||
+		got.Attempts != want.Attempts || !got.AttemptsSince.Equal(want.AttemptsSince) ||
+		got.Approvals != want.Approvals || !equalApprovers(got.Approvers, want.Approvers) ||
+		got.CheckinInterval != want.CheckinInterval || !bytes.Equal(got.OwnerCipher, want.OwnerCipher) {
+		t.Errorf("Retrieved note differs.\nGot:  %s\nWant: %s", describe(got), describe(want))
+	}
+}
+
+// describe formats a note without dumping large ciphertexts
+func describe(n storage.Note) string {
+	return fmt.Sprintf("{ID:%s Hash:%s Cipher:%d bytes Meta:%q Round:%d UnlockAt:%s ExpiresAt:%s Version:%d FragmentKey:%v TokenHash:%s Views:%d/%d Passphrase:%v Attempts:%d since %s}",
+		n.ID, n.Hash, len(n.Cipher), n.Meta, n.Round, n.UnlockAt, n.ExpiresAt, n.Version, n.FragmentKey, n.TokenHash, n.Views, n.MaxViews,
+		n.Passphrase, n.Attempts, n.AttemptsSince) + fmt.Sprintf("{Approvals:%d Approvers:%v CheckinInterval:%s OwnerCipher:%d bytes}", n.Approvals, n.Approvers, n.CheckinInterval, len(n.OwnerCipher))
+}
+
+// equalApprovers reports whether two notes have the same approvers
+func equalApprovers(a, b []storage.Approver) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].TokenHash != b[i].TokenHash || !bytes.Equal(a[i].Share, b[i].Share) || a[i].Released != b[i].Released {
+			return false
+		}
+	}
+	return true
+}
+
+func testNotFound(t *testing.T, store storage.Store) {
+	note := NewNote()
+	if _, err := store.Get(context.Background(), note.ID, note.Hash); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing note, got: %v", err)
+	}
+}
+
+func testSaveGet(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	note := NewNote()
+	note.FragmentKey = true
+
+	if err := store.Save(ctx, note); err != nil {
+		t.Fatalf("Failed to save note: %v", err)
+	}
+	got, err := store.Get(ctx, note.ID, note.Hash)
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	checkNote(t, got, note)
+}
+
+func testHashMismatch(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	note := NewNote()
+	if err := store.Save(ctx, note); err != nil {
+		t.Fatalf("Failed to save note: %v", err)
+	}
+
+	// Both the ID and the hash of the URL are needed
+	other := NewNote()
+	if _, err := store.Get(ctx, note.ID, other.Hash); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for another hash, got: %v", err)
+	}
+	if _, err := store.Get(ctx, other.ID, note.Hash); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for another ID, got: %v", err)
+	}
+	if _, err := store.Get(ctx, note.ID, ""); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an empty hash, got: %v", err)
+	}
+}
+
+func testReplace(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	note := NewNote()
+	if err := store.Save(ctx, note); err != nil {
+		t.Fatalf("Failed to save note: %v", err)
+	}
+
+	// Saving a note with the same ID and hash replaces it, e.g. when a legacy note is migrated
+	note.Cipher = []byte("re-encrypted data")
+	note.Version = 2
+	if err := store.Save(ctx, note); err != nil {
+		t.Fatalf("Failed to save note again: %v", err)
+	}
+	got, err := store.Get(ctx, note.ID, note.Hash)
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	checkNote(t, got, note)
+}
+
+func testExpiry(t *testing.T, store storage.Store) {
+	clock, ok := store.(Clock)
+	if !ok {
+		t.Skip("The store doesn't implement storagetest.Clock")
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	clock.SetClock(func() time.Time { return now })
+
+	// Notes without an expiry time are kept for the default retention period after they unlock,
+	// the others until their expiry time
+	note := NewNote()
+	note.UnlockAt = now.Add(time.Hour)
+	short := NewNote()
+	short.UnlockAt = now.Add(time.Hour)
+	short.ExpiresAt = short.UnlockAt.Add(time.Hour)
+	for _, n := range []storage.Note{note, short} {
+		if err := store.Save(ctx, n); err != nil {
+			t.Fatalf("Failed to save note: %v", err)
+		}
+	}
+
+	tests := []struct {
+		note    storage.Note
+		expires time.Time
+	}{
+		{note, note.UnlockAt.Add(storage.Retention)},
+		{short, short.ExpiresAt},
+	}
+	for _, tt := range tests {
+		clock.SetClock(func() time.Time { return tt.expires.Add(-time.Minute) })
+		if _, err := store.Get(ctx, tt.note.ID, tt.note.Hash); err != nil {
+			t.Errorf("Expected the note before its expiry at %s, got: %v", tt.expires, err)
+		}
+
+		clock.SetClock(func() time.Time { return tt.expires.Add(time.Minute) })
+		if _, err := store.Get(ctx, tt.note.ID, tt.note.Hash); err != storage.ErrNotFound {
+			t.Errorf("Expected ErrNotFound after the expiry at %s, got: %v", tt.expires, err)
+		}
+	}
+}
+
+func testConcurrentWriters(t *testing.T, store storage.Store) {
+	const writers, notesPerWriter = 8, 20
+	ctx := context.Background()
+
+	notes := make([][]storage.Note, writers)
+	shared := NewNote()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := range notes {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < notesPerWriter; i++ {
+				note := NewNote()
+				if err := store.Save(ctx, note); err != nil {
+					errs <- fmt.Errorf("writer %d: %w", w, err)
+					return
+				}
+				notes[w] = append(notes[w], note)
+
+				// Every writer also replaces the same note
+				if err := store.Save(ctx, shared); err != nil {
+					errs <- fmt.Errorf("writer %d, shared note: %w", w, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Failed to save note: %v", err)
+	}
+
+	for _, written := range notes {
+		for _, note := range written {
+			got, err := store.Get(ctx, note.ID, note.Hash)
+			if err != nil {
+				t.Fatalf("Failed to get note: %v", err)
+			}
+			checkNote(t, got, note)
+		}
+	}
+	got, err := store.Get(ctx, shared.ID, shared.Hash)
+	if err != nil {
+		t.Fatalf("Failed to get shared note: %v", err)
+	}
+	checkNote(t, got, shared)
+}
+
+func testLargeNote(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	cipher := make([]byte, LargeNoteSize)
+	rand.Read(cipher)
+	note := noteWithCipher(cipher)
+
+	if err := store.Save(ctx, note); err != nil {
+		t.Fatalf("Failed to save a note of %d bytes: %v", len(cipher), err)
+	}
+	got, err := store.Get(ctx, note.ID, note.Hash)
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	checkNote(t, got, note)
+}
+
+func testContextCanceled(t *testing.T, store storage.Store) {
+	note := NewNote()
+	if err := store.Save(context.Background(), note); err != nil {
+		t.Fatalf("Failed to save note: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := store.Save(ctx, NewNote()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Save to fail with context.Canceled, got: %v", err)
+	}
+	if _, err := store.Get(ctx, note.ID, note.Hash); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Get to fail with context.Canceled, got: %v", err)
+	}
+	if err := store.Delete(ctx, note.ID, note.Hash); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Delete to fail with context.Canceled, got: %v", err)
+	}
+	if _, err := store.View(ctx, note.ID, note.Hash, func(storage.Note) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected View to fail with context.Canceled, got: %v", err)
+	}
+
+	// Nothing was deleted
+	if _, err := store.Get(context.Background(), note.ID, note.Hash); err != nil {
+		t.Errorf("Expected the note to be kept, got: %v", err)
+	}
+}
+
+func testDelete(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	note, other := NewNote(), NewNote()
+	for _, n := range []storage.Note{note, other} {
+		if err := store.Save(ctx, n); err != nil {
+			t.Fatalf("Failed to save note: %v", err)
+		}
+	}
+
+	// Both the ID and the hash are needed
+	if err := store.Delete(ctx, note.ID, other.Hash); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for another hash, got: %v", err)
+	}
+
+	if err := store.Delete(ctx, note.ID, note.Hash); err != nil {
+		t.Fatalf("Failed to delete note: %v", err)
+	}
+	if _, err := store.Get(ctx, note.ID, note.Hash); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after Delete, got: %v", err)
+	}
+	if err := store.Delete(ctx, note.ID, note.Hash); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound when deleting twice, got: %v", err)
+	}
+
+	// Other notes are kept
+	if _, err := store.Get(ctx, other.ID, other.Hash); err != nil {
+		t.Errorf("Expected the other note to be kept, got: %v", err)
+	}
+}
+
+func testView(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	note := NewNote()
+	note.MaxViews = 2
+	if err := store.Save(ctx, note); err != nil {
+		t.Fatalf("Failed to save note: %v", err)
+	}
+
+	// A failed read, e.g. before the unlock time, is not counted
+	errRead := errors.New("too early")
+	if _, err := store.View(ctx, note.ID, note.Hash, func(storage.Note) error { return errRead }); err != errRead {
+		t.Errorf("Expected the error of read, got: %v", err)
+	}
+	if got, err := store.Get(ctx, note.ID, note.Hash); err != nil || got.Views != 0 {
+		t.Fatalf("Expected the note without views after a failed read, got %d views: %v", got.Views, err)
+	}
+
+	// Each view is counted, and the last one deletes the note
+	for views := uint32(1); views <= note.MaxViews; views++ {
+		var read storage.Note
+		viewed, err := store.View(ctx, note.ID, note.Hash, func(n storage.Note) error {
+			read = n
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to view note: %v", err)
+		}
+
+		want := note
+		want.Views = views - 1
+		checkNote(t, read, want)
+		want.Views = views
+		checkNote(t, viewed, want)
+	}
+	if _, err := store.Get(ctx, note.ID, note.Hash); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound once the note is spent, got: %v", err)
+	}
+
+	// Missing notes are not passed to read
+	_, err := store.View(ctx, note.ID, note.Hash, func(storage.Note) error {
+		t.Errorf("Unexpected read of a spent note")
+		return nil
+	})
+	if err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound when viewing a spent note, got: %v", err)
+	}
+
+	// Views of notes without a limit are counted, without deleting them
+	unlimited := NewNote()
+	if err := store.Save(ctx, unlimited); err != nil {
+		t.Fatalf("Failed to save note: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := store.View(ctx, unlimited.ID, unlimited.Hash, func(storage.Note) error { return nil }); err != nil {
+			t.Fatalf("Failed to view note: %v", err)
+		}
+	}
+	if got, err := store.Get(ctx, unlimited.ID, unlimited.Hash); err != nil || got.Views != 3 {
+		t.Errorf("Expected the note with 3 views, got %d views: %v", got.Views, err)
+	}
+}
+
+func testConcurrentViews(t *testing.T, store storage.Store) {
+	const readers = 8
+	ctx := context.Background()
+	note := NewNote()
+	note.MaxViews = 3
+	if err := store.Save(ctx, note); err != nil {
+		t.Fatalf("Failed to save note: %v", err)
+	}
+
+	// All the readers get the note before any of them counts its view
+	var ready sync.WaitGroup
+	ready.Add(readers)
+	results := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		go func() {
+			var once sync.Once
+			_, err := store.View(ctx, note.ID, note.Hash, func(storage.Note) error {
+				once.Do(func() {
+					ready.Done()
+					ready.Wait()
+				})
+				return nil
+			})
+			results <- err
+		}()
+	}
+
+	succeeded := 0
+	for i := 0; i < readers; i++ {
+		switch err := <-results; err {
+		case nil:
+			succeeded++
+		case storage.ErrNotFound:
+		default:
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if succeeded != int(note.MaxViews) {
+		t.Errorf("Expected %d successful views, got %d", note.MaxViews, succeeded)
+	}
+}
+
+func testUpdate(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	note := NewNote()
+	if err := store.Save(ctx, note); err != nil {
+		t.Fatalf("Failed to save note: %v", err)
+	}
+
+	// A failed update is not saved
+	errUpdate := errors.New("rate limited")
+	_, err := store.Update(ctx, note.ID, note.Hash, func(n *storage.Note) error {
+		n.Attempts = 10
+		return errUpdate
+	})
+	if err != errUpdate {
+		t.Errorf("Expected the error of update, got: %v", err)
+	}
+	if got, err := store.Get(ctx, note.ID, note.Hash); err != nil || got.Attempts != 0 {
+		t.Fatalf("Expected the note unchanged after a failed update, got %d attempts: %v", got.Attempts, err)
+	}
+
+	want := note
+	want.Attempts = 1
+	want.AttemptsSince = time.Now().UTC().Truncate(time.Second)
+	want.ExpiresAt = note.Expiry().Add(time.Hour)
+	want.Approvers = append([]storage.Approver(nil), note.Approvers...)
+	want.Approvers[0] = storage.Approver{TokenHash: note.Approvers[0].TokenHash, Share: []byte("released share"), Released: true}
+	updated, err := store.Update(ctx, note.ID, note.Hash, func(n *storage.Note) error {
+		n.Attempts++
+		n.AttemptsSince = want.AttemptsSince
+		n.ExpiresAt = want.ExpiresAt
+		n.Approvers[0] = want.Approvers[0]
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to update note: %v", err)
+	}
+	if updated.Attempts != want.Attempts || !updated.AttemptsSince.Equal(want.AttemptsSince) {
+		t.Errorf("Update returned %s, want %s", describe(updated), describe(want))
+	}
+	got, err := store.Get(ctx, note.ID, note.Hash)
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	checkNote(t, got, want)
+
+	// The new expiry time applies
+	clock, ok := store.(Clock)
+	if !ok {
+		return
+	}
+	clock.SetClock(func() time.Time { return note.Expiry().Add(time.Minute) })
+	if _, err := store.Get(ctx, note.ID, note.Hash); err != nil {
+		t.Errorf("Expected the note before its new expiry time, got: %v", err)
+	}
+	clock.SetClock(func() time.Time { return want.ExpiresAt.Add(time.Second) })
+	_, err = store.Update(ctx, note.ID, note.Hash, func(*storage.Note) error {
+		t.Errorf("Unexpected update of an expired note")
+		return nil
+	})
+	if err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound when updating an expired note, got: %v", err)
+	}
+}
+
+func testConcurrentUpdates(t *testing.T, store storage.Store) {
+	const writers = 8
+	ctx := context.Background()
+	note := NewNote()
+	if err := store.Save(ctx, note); err != nil {
+		t.Fatalf("Failed to save note: %v", err)
+	}
+
+	// All the writers get the note before any of them saves it
+	var ready sync.WaitGroup
+	ready.Add(writers)
+	results := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func() {
+			var once sync.Once
+			_, err := store.Update(ctx, note.ID, note.Hash, func(n *storage.Note) error {
+				once.Do(func() {
+					ready.Done()
+					ready.Wait()
+				})
+				n.Attempts++
+				return nil
+			})
+			results <- err
+		}()
+	}
+	for i := 0; i < writers; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("Failed to update note: %v", err)
+		}
+	}
+
+	got, err := store.Get(ctx, note.ID, note.Hash)
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	if got.Attempts != writers {
+		t.Errorf("Expected %d attempts, got %d", writers, got.Attempts)
+	}
+}
+
+func testUpdateCipher(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	note := NewNote()
+	if err := store.Save(ctx, note); err != nil {
+		t.Fatalf("Failed to save note: %v", err)
+	}
+
+	// The note is re-encrypted to a later round, with a large then a small ciphertext,
+	// and moves to the hash of its new ciphertext
+	want := note
+	for _, size := range []int{LargeNoteSize, 64} {
+		hash := want.Hash
+		want.Cipher = make([]byte, size)
+		rand.Read(want.Cipher)
+		sum := sha256.Sum256(want.Cipher)
+		want.Hash = hex.EncodeToString(sum[:])
+		want.Round += 10
+		want.UnlockAt = want.UnlockAt.Add(time.Hour)
+		want.ExpiresAt = want.UnlockAt.Add(time.Hour)
+		_, err := store.Update(ctx, note.ID, hash, func(n *storage.Note) error {
+			n.Hash = want.Hash
+			n.Cipher = want.Cipher
+			n.Round = want.Round
+			n.UnlockAt = want.UnlockAt
+			n.ExpiresAt = want.ExpiresAt
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to update the ciphertext of %d bytes: %v", size, err)
+		}
+		if _, err := store.Get(ctx, note.ID, hash); err != storage.ErrNotFound {
+			t.Errorf("Expected ErrNotFound for the previous hash, got: %v", err)
+		}
+		got, err := store.Get(ctx, note.ID, want.Hash)
+		if err != nil {
+			t.Fatalf("Failed to get note: %v", err)
+		}
+		checkNote(t, got, want)
+	}
+
+	// Other updates keep the ciphertext and the hash
+	want.Attempts = 1
+	if _, err := store.Update(ctx, note.ID, want.Hash, func(n *storage.Note) error {
+		n.Attempts++
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to update note: %v", err)
+	}
+	got, err := store.Get(ctx, note.ID, want.Hash)
+	if err != nil {
+		t.Fatalf("Failed to get note: %v", err)
+	}
+	checkNote(t, got, want)
+}
+
+func testStreams(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	streams := storage.Streams(store)
+
+	// Large and small ciphertexts are saved and read back as streams
+	for _, size := range []int{64, LargeNoteSize} {
+		cipher := make([]byte, size)
+		rand.Read(cipher)
+		note := noteWithCipher(cipher)
+		note.MaxViews = 1
+		saved := note
+		saved.Cipher = nil
+		if err := streams.SaveStream(ctx, saved, bytes.NewReader(cipher), int64(size)); err != nil {
+			t.Fatalf("Failed to save a note of %d bytes: %v", size, err)
+		}
+
+		got, stream, err := streams.GetStream(ctx, note.ID, note.Hash)
+		if err != nil {
+			t.Fatalf("Failed to get note: %v", err)
+		}
+		data, err := io.ReadAll(stream)
+		stream.Close()
+		if err != nil {
+			t.Fatalf("Failed to read ciphertext: %v", err)
+		}
+		if got.Cipher != nil || !bytes.Equal(data, cipher) {
+			t.Errorf("Expected the ciphertext of %d bytes in the stream only, got %d bytes and %d in the note", size, len(data), len(got.Cipher))
+		}
+		got.Cipher = cipher
+		checkNote(t, got, note)
+
+		// The stream given to read is returned to read the rest of the ciphertext
+		viewed, stream, err := streams.ViewStream(ctx, note.ID, note.Hash, func(n storage.Note, cipher io.Reader) error {
+			_, err := io.ReadFull(cipher, make([]byte, 16))
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Failed to view note: %v", err)
+		}
+		rest, err := io.ReadAll(stream)
+		if err := stream.Close(); err != nil {
+			t.Errorf("Failed to close stream: %v", err)
+		}
+		if err != nil || !bytes.Equal(rest, cipher[16:]) || !viewed.Spent() {
+			t.Errorf("Unexpected view of %d bytes: %d bytes left, %d views: %v", size, len(rest), viewed.Views, err)
+		}
+		if _, err := streams.Get(ctx, note.ID, note.Hash); err != storage.ErrNotFound {
+			t.Errorf("Expected ErrNotFound once the note is spent, got: %v", err)
+		}
+	}
+
+	// Errors of read are returned without a stream or counting the view
+	note := NewNote()
+	if err := store.Save(ctx, note); err != nil {
+		t.Fatalf("Failed to save note: %v", err)
+	}
+	errRead := errors.New("too early")
+	_, stream, err := streams.ViewStream(ctx, note.ID, note.Hash, func(storage.Note, io.Reader) error { return errRead })
+	if err != errRead || stream != nil {
+		t.Errorf("Expected the error of read without a stream, got: %v", err)
+	}
+	if _, _, err := streams.GetStream(ctx, uuid.New().String(), note.Hash); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got: %v", err)
+	}
+}
+
+func testScan(t *testing.T, store storage.Store) {
+	ctx := context.Background()
+	now := time.Now()
+	if clock, ok := store.(Clock); ok {
+		clock.SetClock(func() time.Time { return now })
+	}
+
+	// Beacon signatures cached by the store are not notes
+	if sigs, ok := store.(SignatureStore); ok {
+		if err := sigs.SaveSignature("52db9ba70e0cc0f6eaf7803dd07447a1f5477735fd3f661792ba94600c84e971", 42, []byte("signature")); err != nil {
+			t.Fatalf("Failed to save signature: %v", err)
+		}
+	}
+
+	small := NewNote()
+	small.ExpiresAt = small.UnlockAt.Add(time.Hour)
+	large := NewNote()
+	large.Cipher = make([]byte, LargeNoteSize)
+	rand.Read(large.Cipher)
+	sum := sha256.Sum256(large.Cipher)
+	large.Hash = hex.EncodeToString(sum[:])
+	large.ExpiresAt = large.UnlockAt.Add(2 * time.Hour)
+	want := map[string]storage.Note{small.ID: small, large.ID: large}
+	for _, n := range want {
+		if err := store.Save(ctx, n); err != nil {
+			t.Fatalf("Failed to save note: %v", err)
+		}
+	}
+
+	scan := func() map[string]storage.Note {
+		t.Helper()
+		got := map[string]storage.Note{}
+		if err := store.Scan(ctx, func(n storage.Note) error {
+			got[n.ID] = n
+			return nil
+		}); err != nil {
+			t.Fatalf("Failed to scan notes: %v", err)
+		}
+		return got
+	}
+	got := scan()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d notes, got %d", len(want), len(got))
+	}
+	for id, n := range want {
+		// Ciphertexts may be left out, e.g. when they are kept in a blob store
+		if got[id].Cipher == nil {
+			n.Cipher = nil
+		}
+		if got[id].ID != id || got[id].Hash != n.Hash || got[id].Round != n.Round || !bytes.Equal(got[id].Cipher, n.Cipher) {
+			t.Errorf("Scan passed %s, want %s", describe(got[id]), describe(n))
+		}
+	}
+
+	// Errors of fn stop the scan
+	errStop := errors.New("stop")
+	calls := 0
+	if err := store.Scan(ctx, func(storage.Note) error {
+		calls++
+		return errStop
+	}); err != errStop || calls != 1 {
+		t.Errorf("Expected the scan stopped by the error of fn after one note, got %d calls: %v", calls, err)
+	}
+
+	// Expired notes are left out
+	clock, ok := store.(Clock)
+	if !ok {
+		return
+	}
+	clock.SetClock(func() time.Time { return small.Expiry().Add(time.Minute) })
+	if got := scan(); len(got) != 1 || got[large.ID].ID != large.ID {
+		t.Errorf("Expected only the note that hasn't expired, got %d notes", len(got))
+	}
+}
+
+func testSignatures(t *testing.T, store storage.Store) {
+	sigs, ok := store.(SignatureStore)
+	if !ok {
+		t.Skip("The store doesn't implement storagetest.SignatureStore")
+	}
+
+	chainHash := "52db9ba70e0cc0f6eaf7803dd07447a1f5477735fd3f661792ba94600c84e971"
+	if _, ok, err := sigs.LoadSignature(chainHash, 42); err != nil || ok {
+		t.Fatalf("Expected no signature, got ok=%v err=%v", ok, err)
+	}
+
+	// Signatures never change, so saving one twice is not an error
+	for i := 0; i < 2; i++ {
+		if err := sigs.SaveSignature(chainHash, 42, []byte("signature")); err != nil {
+			t.Fatalf("Failed to save signature: %v", err)
+		}
+	}
+	sig, ok, err := sigs.LoadSignature(chainHash, 42)
+	if err != nil || !ok || string(sig) != "signature" {
+		t.Errorf("Unexpected signature %q, ok=%v err=%v", sig, ok, err)
+	}
+
+	// Signatures are scoped to their chain and round
+	if _, ok, _ := sigs.LoadSignature("other", 42); ok {
+		t.Errorf("Expected no signature for another chain")
+	}
+	if _, ok, _ := sigs.LoadSignature(chainHash, 43); ok {
+		t.Errorf("Expected no signature for another round")
+	}
+}
@@ -11,20 +11,116 @@ var (
 	ErrNotFound = errors.New("note not found")
 )
 
+// updateAttempts is how many times a view or update is retried when concurrent ones changed the note first
+const updateAttempts = 32
+
+// Retention is how long notes without an expiry time are kept after their unlock time
+const Retention = 7 * 24 * time.Hour
+
 // Note represents a stored encrypted note
 type Note struct {
-	ID       string    // UUIDv4
-	Hash     string    // hex(sha256(cipher))
-	Cipher   []byte    // Encrypted data
-	Round    uint64    // drand round number
-	UnlockAt time.Time // Time when the note can be decrypted
+	ID        string    // UUIDv4
+	Hash      string    // hex(sha256(cipher)) of the ciphertext of the note, kept on check-ins, replaced on extensions
+	Cipher    []byte    // Encrypted data
+	Blob      string    // Key of Cipher in a BlobStore, set by SplitStore when Cipher is stored outside the record
+	Meta      []byte    // Encrypted metadata of an attached file, empty for text notes
+	Round     uint64    // drand round number
+	UnlockAt  time.Time // Time when the note can be decrypted
+	ExpiresAt time.Time // Time when the note is deleted, zero for UnlockAt + Retention
+	Version   byte      // Format of Cipher: 0 for legacy blobs, 1 for age files (see crypto.Format)
+
+	// FragmentKey is set when the decrypted note is itself encrypted with a key
+	// that only exists in the URL fragment, so the server can never read it
+	FragmentKey bool
+
+	TokenHash string // hex(sha256(token)) of the token given to the creator to delete the note
+
+	Views    uint32 // Number of reads after unlock
+	MaxViews uint32 // Number of reads after which the note is deleted, 0 for no limit
+
+	// Passphrase is set when the decrypted note is itself encrypted with a key derived
+	// from a passphrase, which readers must give to the server after unlock
+	Passphrase bool
+
+	Attempts      uint32    // Passphrase attempts since AttemptsSince, reset by a correct passphrase
+	AttemptsSince time.Time // Start of the current window of passphrase attempts
+
+	// Approvals is the number of Approvers that must release their share of the data key
+	// before the note can be decrypted, even once unlocked. 0 for notes without approvals.
+	Approvals uint32
+	Approvers []Approver
+
+	// CheckinInterval is set for dead man's switch notes: until the note unlocks, each check-in
+	// of its creator timelocks OwnerCipher again, to CheckinInterval after the check-in.
+	// OwnerCipher is the payload of Cipher encrypted with the delete token of the creator, kept for
+	// the notes the server encrypted without recipients, so that it can extend them too.
+	CheckinInterval time.Duration
+	OwnerCipher     []byte
+}
+
+// Approver holds the share of the data key of a note that an approver releases
+type Approver struct {
+	TokenHash string // hex(sha256(token)) of the approval token of the approver
+	Share     []byte // Encrypted with the approval token until Released, then in the clear
+	Released  bool
+}
+
+// Released returns the shares released by the approvers of a note
+func (n Note) Released() [][]byte {
+	var shares [][]byte
+	for _, a := range n.Approvers {
+		if a.Released {
+			shares = append(shares, a.Share)
+		}
+	}
+	return shares
+}
+
+// Approved reports whether enough approvers released their share to decrypt the note
+func (n Note) Approved() bool {
+	return len(n.Released()) >= int(n.Approvals)
+}
+
+// Expiry returns the time when the note is deleted
+func (n Note) Expiry() time.Time {
+	if n.ExpiresAt.IsZero() {
+		return n.UnlockAt.Add(Retention)
+	}
+	return n.ExpiresAt
+}
+
+// Spent reports whether the note was read its maximum number of times
+func (n Note) Spent() bool {
+	return n.MaxViews > 0 && n.Views >= n.MaxViews
 }
 
 // Store defines the interface for storing and retrieving notes
 type Store interface {
 	// Save stores a note in the database
 	Save(ctx context.Context, n Note) error
-	
+
 	// Get retrieves a note by its ID and hash
 	Get(ctx context.Context, id, hash string) (Note, error)
+
+	// Delete removes a note before its expiry. It returns ErrNotFound if there is no such note.
+	Delete(ctx context.Context, id, hash string) error
+
+	// View retrieves a note, passes it to read and, if read succeeds, counts the view:
+	// Views is incremented atomically, and the note is deleted once it is Spent.
+	// It returns the note with the view counted. Concurrent views are all counted, and the ones
+	// beyond MaxViews return ErrNotFound, so read may be called again if the note changed meanwhile.
+	// Errors of read are returned as is, without counting the view.
+	View(ctx context.Context, id, hash string, read func(Note) error) (Note, error)
+
+	// Update retrieves a note, passes it to update and saves the changes atomically: if the note
+	// changed meanwhile, update is called again with the new note. update may change the ciphertext,
+	// the metadata and the Hash of the note, which then moves to its new hash, not its ID.
+	// It returns the updated note.
+	// Errors of update are returned as is, without saving the note.
+	Update(ctx context.Context, id, hash string, update func(*Note) error) (Note, error)
+
+	// Scan passes each note that hasn't expired to fn, in no particular order. fn must not call
+	// the store: notes to change are collected, then updated once Scan returns. Errors of fn stop
+	// the scan and are returned as is.
+	Scan(ctx context.Context, fn func(Note) error) error
 }
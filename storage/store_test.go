@@ -0,0 +1,103 @@
+package storage_test
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
+	"github.com/korjavin/drand-poc/storage"
+	"github.com/korjavin/drand-poc/storage/storagetest"
+
+	_ "github.com/glebarez/go-sqlite"
+	_ "github.com/lib/pq"
+)
+
+func TestBadgerStoreConformance(t *testing.T) {
+	storagetest.Run(t, func() storage.Store {
+		// On disk like in production: in-memory databases limit values to 1 MiB
+		store, err := storage.NewBadgerStore(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+		if err != nil {
+			t.Fatalf("Failed to create BadgerStore: %v", err)
+		}
+		return store
+	})
+}
+
+func TestSQLiteStoreConformance(t *testing.T) {
+	storagetest.Run(t, func() storage.Store {
+		store, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "notes.db"))
+		if err != nil {
+			t.Fatalf("Failed to create SQLiteStore: %v", err)
+		}
+		return store
+	})
+}
+
+func TestPostgresStoreConformance(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+
+	// Each subtest gets an empty schema of its own
+	storagetest.Run(t, func() storage.Store {
+		store, err := storage.NewPostgresStore(postgresSchema(t, dsn))
+		if err != nil {
+			t.Fatalf("Failed to create PostgresStore: %v", err)
+		}
+		return store
+	})
+}
+
+// postgresSchema creates an empty schema, dropped at the end of the test,
+// and returns the DSN of a connection that uses it
+func postgresSchema(t *testing.T, dsn string) string {
+	t.Helper()
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	schema := "storagetest_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		db.Close()
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("Failed to drop schema %s: %v", schema, err)
+		}
+		db.Close()
+	})
+
+	// Both DSN forms pass unknown parameters to the server as settings
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	return dsn + " search_path=" + schema
+}
+
+func TestSplitStoreConformance(t *testing.T) {
+	storagetest.Run(t, func() storage.Store {
+		db, err := storage.NewBadgerStore(badger.DefaultOptions("").WithInMemory(true))
+		if err != nil {
+			t.Fatalf("Failed to create BadgerStore: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		blobs, err := storage.NewFSBlobStore(t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create FSBlobStore: %v", err)
+		}
+		// Most notes of the suite are inline, the large one is a blob
+		return storage.NewSplitStore(db, blobs, 64<<10)
+	})
+}
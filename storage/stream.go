@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+)
+
+// StreamStore is a Store that can write and read ciphertexts as streams, so that large
+// files are not held in memory. SplitStore streams the ciphertexts of its BlobStore.
+type StreamStore interface {
+	Store
+
+	// SaveStream stores a note like Save, with its ciphertext of size bytes read from cipher
+	// instead of n.Cipher. The hash of the note must be the hex SHA-256 of the ciphertext.
+	SaveStream(ctx context.Context, n Note, cipher io.Reader, size int64) error
+
+	// GetStream retrieves a note like Get, with its ciphertext returned as a stream instead
+	// of in n.Cipher. The stream is only opened when it is first read, and must be closed.
+	GetStream(ctx context.Context, id, hash string) (Note, io.ReadCloser, error)
+
+	// ViewStream views a note like View, passing its ciphertext to read as a stream instead
+	// of in n.Cipher. The stream passed to the successful read is returned to read the rest
+	// of the ciphertext, and must be closed.
+	ViewStream(ctx context.Context, id, hash string, read func(Note, io.Reader) error) (Note, io.ReadCloser, error)
+}
+
+// Streams returns a store as a StreamStore. The streams of stores that don't implement
+// StreamStore are read from and into memory.
+func Streams(store Store) StreamStore {
+	if streams, ok := store.(StreamStore); ok {
+		return streams
+	}
+	return memoryStreams{store}
+}
+
+// memoryStreams implements StreamStore for a Store that keeps ciphertexts in its records
+type memoryStreams struct {
+	Store
+}
+
+// SaveStream reads the ciphertext into the note and saves it
+func (s memoryStreams) SaveStream(ctx context.Context, n Note, cipher io.Reader, size int64) error {
+	var err error
+	if n.Cipher, err = readCipher(cipher, size); err != nil {
+		return err
+	}
+	return s.Save(ctx, n)
+}
+
+// GetStream retrieves a note and returns a stream of its ciphertext
+func (s memoryStreams) GetStream(ctx context.Context, id, hash string) (Note, io.ReadCloser, error) {
+	n, err := s.Get(ctx, id, hash)
+	if err != nil {
+		return n, nil, err
+	}
+
+	cipher := io.NopCloser(bytes.NewReader(n.Cipher))
+	n.Cipher = nil
+	return n, cipher, nil
+}
+
+// ViewStream views a note, passing a stream of its ciphertext to read
+func (s memoryStreams) ViewStream(ctx context.Context, id, hash string, read func(Note, io.Reader) error) (Note, io.ReadCloser, error) {
+	var cipher io.ReadCloser
+	n, err := s.View(ctx, id, hash, func(n Note) error {
+		cipher = io.NopCloser(bytes.NewReader(n.Cipher))
+		n.Cipher = nil
+		return read(n, cipher)
+	})
+	if err != nil {
+		return n, nil, err
+	}
+
+	n.Cipher = nil
+	return n, cipher, nil
+}
+
+// readCipher reads a ciphertext of size bytes into memory
+func readCipher(cipher io.Reader, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(cipher, data); err != nil {
+		return nil, fmt.Errorf("failed to read ciphertext: %w", err)
+	}
+	return data, nil
+}